@@ -8,14 +8,16 @@ import (
 	"fmt"
 	"github.com/labstack/gommon/log"
 	"io"
+	"kimi-chat/browser"
+	"kimi-chat/googlesearch"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
-	"github.com/chromedp/cdproto/page"
-	"github.com/chromedp/chromedp"
 	"github.com/glebarez/sqlite"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
@@ -33,12 +35,23 @@ type Dialog struct {
 }
 
 type ToolDescQuery struct {
-	Type        string `json:"type,omitempty"`
-	Description string `json:"description,omitempty"`
+	Type        string         `json:"type,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Enum        []string       `json:"enum,omitempty"`
+	Items       *ToolDescQuery `json:"items,omitempty"`
 }
 
 type ToolDescProperties struct {
-	Query ToolDescQuery `json:"query,omitempty"`
+	Query        *ToolDescQuery `json:"query,omitempty"`
+	Sites        *ToolDescQuery `json:"sites,omitempty"`
+	ExcludeSites *ToolDescQuery `json:"exclude_sites,omitempty"`
+	FileType     *ToolDescQuery `json:"filetype,omitempty"`
+	Recency      *ToolDescQuery `json:"recency,omitempty"`
+	ExactPhrase  *ToolDescQuery `json:"exact_phrase,omitempty"`
+	SafeSearch   *ToolDescQuery `json:"safe_search,omitempty"`
+	URL          *ToolDescQuery `json:"url,omitempty"`
+	Format       *ToolDescQuery `json:"format,omitempty"`
+	Screenshot   *ToolDescQuery `json:"screenshot,omitempty"`
 }
 
 type ToolDescParameters struct {
@@ -89,6 +102,8 @@ type MessageViewItem struct {
 	ToolCalls  []byte `json:"tool_calls,omitempty"`
 	ToolCallId string `json:"tool_call_id,omitempty"`
 	Name       string `json:"name,omitempty"`
+	// browse 工具截取的整页截图（JPEG）
+	Screenshot []byte `json:"screenshot,omitempty"`
 }
 
 type Message struct {
@@ -100,6 +115,8 @@ type Message struct {
 	ToolCalls  datatypes.JSON `json:"tool_calls,omitempty"`
 	ToolCallId string         `json:"tool_call_id,omitempty"`
 	Name       string         `json:"name,omitempty"`
+	// 截图只保存和展示，不发送给模型
+	Screenshot []byte `json:"-"`
 }
 
 //	type msgDTO struct {
@@ -160,14 +177,22 @@ type chatResp struct {
 
 // App struct
 type App struct {
-	ctx context.Context
-	db  *gorm.DB
-	//
-	allocatorCtx    context.Context
-	allocatorCancel context.CancelFunc
-	//
-	browserCtx    context.Context
-	browserCancel context.CancelFunc
+	ctx    context.Context
+	db     *gorm.DB
+	search *googlesearch.Client
+	// browser 懒启动 Chrome 并维护标签页池
+	browser *browser.Manager
+	// captchaBrowser 在需要用户手动完成验证码时显示窗口
+	captchaBrowser *browser.Manager
+	// profiles 保存有名字的浏览器配置（Cookie、登录状态等）
+	profiles *browser.UserDataStore
+	profile  string
+}
+
+// BrowserProfileList 是浏览器配置列表及当前使用的配置
+type BrowserProfileList struct {
+	Current string   `json:"current"`
+	Names   []string `json:"names"`
 }
 
 // NewApp creates a new App application struct
@@ -178,29 +203,44 @@ func NewApp() *App {
 		panic(err)
 	}
 	db.AutoMigrate(&Dialog{}, &Message{})
-	//
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("headless", true),
-		chromedp.DisableGPU,
-		chromedp.Flag("enable-automation", false),
-		// chromedp.UserDataDir(userdata),
-	)
-	allocatorCtx, allocatorCancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	browserCtx, browserCancel := chromedp.NewContext(allocatorCtx)
-	// it depends how will you manage the lifecycle of the browser, maybe you don't want to call browserCancel() here
-	err = chromedp.Run(browserCtx, chromedp.ActionFunc(func(cxt context.Context) error {
-		_, err := page.AddScriptToEvaluateOnNewDocument("Object.defineProperty(navigator, 'webdriver', { get: () => false, });").Do(cxt)
-		if err != nil {
-			return err
-		}
-		return nil
-	}))
+	// SEARCH_CACHE_TTL 控制搜索结果缓存时长，例如 "30m"
+	ttl, _ := time.ParseDuration(os.Getenv("SEARCH_CACHE_TTL"))
+	cache, err := googlesearch.NewCache(ttl, db)
+	if err != nil {
+		panic(err)
+	}
+	// BROWSER_TABS 控制同时可用的标签页数量
+	tabs, _ := strconv.Atoi(os.Getenv("BROWSER_TABS"))
+	// BROWSER_PROFILE 选择持久化的浏览器配置，默认 "default"
+	profile := os.Getenv("BROWSER_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	root, err := browser.DefaultUserDataRoot("kimi-chat")
+	if err != nil {
+		panic(err)
+	}
+	profiles, err := browser.NewUserDataStore(root)
+	if err != nil {
+		panic(err)
+	}
+	userDataDir, err := profiles.Dir(profile)
 	if err != nil {
-		fmt.Println(err)
+		panic(err)
 	}
 	//
-	return &App{db: db, allocatorCtx: allocatorCtx, allocatorCancel: allocatorCancel,
-		browserCtx: browserCtx, browserCancel: browserCancel}
+	a := &App{db: db, search: googlesearch.NewClient(cache),
+		browser:        browser.NewManager(browser.Options{Size: tabs, UserDataDir: userDataDir}),
+		captchaBrowser: browser.NewManager(browser.Options{Size: 1, Visible: true}),
+		profiles:       profiles, profile: profile}
+	// 先自动点击，失败时弹出浏览器窗口让用户手动验证
+	a.search.Challenges = googlesearch.Chain(googlesearch.AutoClick{}, &googlesearch.Manual{
+		Browser: a.captchaBrowser,
+		Notify: func(c googlesearch.Challenge) {
+			runtime.EventsEmit(a.ctx, "search:challenge", c.Engine, c.Kind)
+		},
+	})
+	return a
 }
 
 // startup is called at application startup
@@ -232,6 +272,7 @@ func (a *App) GetMessages(did uint) []MessageViewItem {
 			m.ToolCalls,
 			m.ToolCallId,
 			m.Name,
+			m.Screenshot,
 		})
 	}
 	return ret
@@ -313,14 +354,66 @@ func (a *App) SendMessage(did int, content string) SendResp {
 			Parameters: ToolDescParameters{
 				Type: "object",
 				Properties: ToolDescProperties{
-					Query: ToolDescQuery{
+					Query: &ToolDescQuery{
 						Type:        "string",
 						Description: "需要搜索的关键词",
 					},
+					Sites: &ToolDescQuery{
+						Type:        "array",
+						Description: "可选，只返回这些网站的结果，例如 go.dev",
+						Items:       &ToolDescQuery{Type: "string"},
+					},
+					ExcludeSites: &ToolDescQuery{
+						Type:        "array",
+						Description: "可选，排除这些网站的结果",
+						Items:       &ToolDescQuery{Type: "string"},
+					},
+					FileType: &ToolDescQuery{
+						Type:        "string",
+						Description: "可选，只返回该文件类型，例如 pdf",
+					},
+					Recency: &ToolDescQuery{
+						Type:        "string",
+						Description: "可选，只返回最近一段时间内的结果",
+						Enum:        []string{"day", "week", "month", "year"},
+					},
+					ExactPhrase: &ToolDescQuery{
+						Type:        "string",
+						Description: "可选，结果必须包含的完整短语",
+					},
+					SafeSearch: &ToolDescQuery{
+						Type:        "string",
+						Description: "可选，安全搜索级别",
+						Enum:        []string{"off", "moderate", "strict"},
+					},
 				},
 				Required: []string{"query"},
 			}},
 	})
+	toolDescs = append(toolDescs, ToolDesc{
+		Type: "function",
+		Function: ToolDescFunction{Name: "browse",
+			Description: "用浏览器打开网页并等待脚本渲染完成，返回页面正文。适合读取搜索结果或需要 JavaScript 渲染的页面。",
+			Parameters: ToolDescParameters{
+				Type: "object",
+				Properties: ToolDescProperties{
+					URL: &ToolDescQuery{
+						Type:        "string",
+						Description: "要打开的网页地址",
+					},
+					Format: &ToolDescQuery{
+						Type:        "string",
+						Description: "可选，正文格式，默认 markdown",
+						Enum:        []string{"markdown", "text"},
+					},
+					Screenshot: &ToolDescQuery{
+						Type:        "boolean",
+						Description: "可选，是否截取整页截图展示给用户",
+					},
+				},
+				Required: []string{"url"},
+			}},
+	})
 	reply := ""
 	loop := true
 	for loop {
@@ -350,26 +443,27 @@ func (a *App) SendMessage(did int, content string) SendResp {
 			fmt.Printf("ToolCalls: %#v\n", calls)
 			// 4.3 依次执行工具
 			for _, call := range calls {
-				if call.Function.Name != "search" {
-					continue
-				}
-				var args struct {
-					Query string `json:"query"`
-				}
-				_ = json.Unmarshal([]byte(call.Function.Arguments), &args)
-				fmt.Printf("\n>>> 正在执行工具 search(%q)...\n", args.Query)
-				result, err := searchTool(a.browserCtx, args.Query)
-				if err != nil {
-					result = "工具执行失败：" + err.Error()
+				switch call.Function.Name {
+				case "search":
+					var args searchArgs
+					_ = json.Unmarshal([]byte(call.Function.Arguments), &args)
+					fmt.Printf("\n>>> 正在执行工具 search(%q)...\n", args.Query)
+					result, err := searchTool(a.ctx, a.browser, a.search, args)
+					if err != nil {
+						result = "工具执行失败：" + err.Error()
+					}
+					// 4.4 把工具返回追加进 messages
+					msgs = append(msgs, Message{Role: "tool", ToolCallId: call.ID, Name: "search", Content: result})
+				case "browse":
+					var args browseArgs
+					_ = json.Unmarshal([]byte(call.Function.Arguments), &args)
+					fmt.Printf("\n>>> 正在执行工具 browse(%q)...\n", args.URL)
+					result, screenshot, err := browseTool(a.ctx, a.browser, args)
+					if err != nil {
+						result = "工具执行失败：" + err.Error()
+					}
+					msgs = append(msgs, Message{Role: "tool", ToolCallId: call.ID, Name: "browse", Content: result, Screenshot: screenshot})
 				}
-				// 4.4 把工具返回追加进 messages
-				//messages = append(messages, map[string]any{
-				//	"role":         "tool",
-				//	"tool_call_id": call.ID,
-				//	"name":         "search",
-				//	"content":      result,
-				//})
-				msgs = append(msgs, Message{Role: "tool", ToolCallId: call.ID, Name: "search", Content: result})
 			}
 		default:
 			loop = false
@@ -395,6 +489,55 @@ func (a *App) SendMessage(did int, content string) SendResp {
 	return SendResp{did, reply, 0}
 }
 
+// SearchCacheStats 返回搜索缓存命中统计
+func (a *App) SearchCacheStats() googlesearch.CacheStats {
+	return a.search.Cache.Stats()
+}
+
+// SearchEngineStatus 返回各搜索引擎的限流与退避状态
+func (a *App) SearchEngineStatus() []googlesearch.EngineStatus {
+	return a.search.Status()
+}
+
+// BrowserStatus 返回浏览器进程与标签页池的状态
+func (a *App) BrowserStatus() browser.Status {
+	return a.browser.Status()
+}
+
+// BrowserProfiles 返回所有浏览器配置
+func (a *App) BrowserProfiles() BrowserProfileList {
+	names, err := a.profiles.List()
+	if err != nil {
+		log.Error(err)
+	}
+	return BrowserProfileList{Current: a.profile, Names: names}
+}
+
+// SwitchBrowserProfile 切换到名为 name 的浏览器配置，不存在时新建
+func (a *App) SwitchBrowserProfile(name string) error {
+	dir, err := a.profiles.Dir(name)
+	if err != nil {
+		return err
+	}
+	if err := a.browser.SetUserDataDir(a.ctx, dir); err != nil {
+		return err
+	}
+	a.profile = name
+	return nil
+}
+
+// OpenLoginWindow 用当前配置打开可见的浏览器窗口，用户登录后关闭窗口即可保存登录状态
+func (a *App) OpenLoginWindow(startURL string) error {
+	dir, err := a.profiles.Dir(a.profile)
+	if err != nil {
+		return err
+	}
+	// Chrome 会锁定配置目录，登录期间暂停无头浏览器
+	return a.browser.Exclusive(a.ctx, func() error {
+		return browser.Login(a.ctx, dir, startURL)
+	})
+}
+
 func (a *App) DeleteDialog(id uint) {
 	a.db.Delete(&Dialog{}, id)
 	a.db.Where("dialog_id = ?", id).Delete(&Message{})
@@ -430,6 +573,6 @@ func (a *App) beforeClose(ctx context.Context) (prevent bool) {
 func (a *App) shutdown(ctx context.Context) {
 	// Perform your teardown here
 	// 在此处做一些资源释放的操作
-	a.allocatorCancel()
-	a.browserCancel()
+	a.browser.Close()
+	a.captchaBrowser.Close()
 }
@@ -0,0 +1,141 @@
+package browser
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"kimi-chat/colly/storage"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// ExportCookies copies the cookies the browser of ctx sends to each of urls into s, in
+// the format of storage.StringifyCookies, so a colly Collector using s shares the
+// browser's sessions.
+func ExportCookies(ctx context.Context, s storage.Storage, urls ...string) error {
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		var cookies []*network.Cookie
+		err = chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			cookies, err = network.GetCookies().WithURLs([]string{u.String()}).Do(ctx)
+			return err
+		}))
+		if err != nil {
+			return err
+		}
+		if len(cookies) > 0 {
+			s.SetCookies(u, storage.StringifyCookies(HTTPCookies(cookies)))
+		}
+	}
+	return nil
+}
+
+// ImportCookies copies the cookies s holds for each of urls into the browser of ctx.
+// Storages built on net/http/cookiejar only keep names and values, so the cookies are
+// set for the host of the URL they were read for.
+func ImportCookies(ctx context.Context, s storage.Storage, urls ...string) error {
+	var params []*network.CookieParam
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		stored := s.Cookies(u)
+		if stored == "" {
+			continue
+		}
+		for _, c := range storage.UnstringifyCookies(stored) {
+			params = append(params, cookieParam(u, c))
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return chromedp.Run(ctx, network.SetCookies(params))
+}
+
+// CookieParams converts cookies read from a browser into cookies to set in another one.
+func CookieParams(cookies []*network.Cookie) []*network.CookieParam {
+	params := make([]*network.CookieParam, 0, len(cookies))
+	for _, c := range cookies {
+		p := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			SameSite: c.SameSite,
+		}
+		if !c.Session {
+			expires := cdp.TimeSinceEpoch(time.Unix(int64(c.Expires), 0))
+			p.Expires = &expires
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+// HTTPCookies converts cookies read from a browser into net/http cookies.
+func HTTPCookies(cookies []*network.Cookie) []*http.Cookie {
+	out := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		hc := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+		}
+		if !c.Session {
+			hc.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		switch c.SameSite {
+		case network.CookieSameSiteStrict:
+			hc.SameSite = http.SameSiteStrictMode
+		case network.CookieSameSiteLax:
+			hc.SameSite = http.SameSiteLaxMode
+		case network.CookieSameSiteNone:
+			hc.SameSite = http.SameSiteNoneMode
+		}
+		out = append(out, hc)
+	}
+	return out
+}
+
+// cookieParam converts a net/http cookie received from u into a cookie to set in a browser.
+func cookieParam(u *url.URL, c *http.Cookie) *network.CookieParam {
+	p := &network.CookieParam{
+		Name:     c.Name,
+		Value:    c.Value,
+		URL:      u.String(),
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HTTPOnly: c.HttpOnly,
+	}
+	if p.Path == "" {
+		p.Path = "/"
+	}
+	if !c.Expires.IsZero() {
+		expires := cdp.TimeSinceEpoch(c.Expires)
+		p.Expires = &expires
+	}
+	switch c.SameSite {
+	case http.SameSiteStrictMode:
+		p.SameSite = network.CookieSameSiteStrict
+	case http.SameSiteLaxMode:
+		p.SameSite = network.CookieSameSiteLax
+	case http.SameSiteNoneMode:
+		p.SameSite = network.CookieSameSiteNone
+	}
+	return p
+}
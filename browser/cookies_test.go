@@ -0,0 +1,47 @@
+package browser
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"kimi-chat/colly/storage"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestCookieRoundTrip(t *testing.T) {
+	u, _ := url.Parse("https://example.com/account")
+	browserCookies := []*network.Cookie{
+		{Name: "session", Value: "abc", Domain: ".example.com", Path: "/", Secure: true, HTTPOnly: true, Session: true, SameSite: network.CookieSameSiteLax},
+		{Name: "lang", Value: "de", Domain: "example.com", Path: "/", Expires: 4102444800},
+	}
+
+	s := &storage.InMemoryStorage{}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetCookies(u, storage.StringifyCookies(HTTPCookies(browserCookies)))
+
+	params := map[string]*network.CookieParam{}
+	for _, c := range storage.UnstringifyCookies(s.Cookies(u)) {
+		params[c.Name] = cookieParam(u, c)
+	}
+	if len(params) != 2 {
+		t.Fatalf("got %d cookies back from the storage, want 2", len(params))
+	}
+	for _, c := range browserCookies {
+		p := params[c.Name]
+		if p == nil || p.Value != c.Value || p.URL != u.String() {
+			t.Errorf("cookie %s = %+v, want value %q for %s", c.Name, p, c.Value, u)
+		}
+	}
+
+	hc := HTTPCookies(browserCookies)
+	if !hc[0].Expires.IsZero() || hc[0].SameSite != http.SameSiteLaxMode || !hc[0].HttpOnly {
+		t.Errorf("session cookie = %+v, want no expiry, SameSite=Lax and HttpOnly", hc[0])
+	}
+	if got := hc[1].Expires.Unix(); got != 4102444800 {
+		t.Errorf("expires = %d, want 4102444800", got)
+	}
+}
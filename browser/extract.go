@@ -0,0 +1,329 @@
+package browser
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// minMainText is how much text a <main> or <article> element needs to be taken as the
+// content of a page instead of the whole body.
+const minMainText = 200
+
+// skippedTags never contribute content.
+var skippedTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"canvas": true, "iframe": true, "nav": true, "footer": true, "aside": true,
+	"form": true, "button": true, "input": true, "select": true, "textarea": true,
+	"head": true,
+}
+
+// blockTags start and end on their own line.
+var blockTags = map[string]bool{
+	"div": true, "section": true, "article": true, "main": true, "header": true,
+	"figure": true, "figcaption": true, "dl": true, "dt": true, "dd": true,
+	"address": true, "details": true, "summary": true, "body": true, "tr": true,
+}
+
+var (
+	spaceRe    = regexp.MustCompile(`\s+`)
+	newlinesRe = regexp.MustCompile(`\n{3,}`)
+)
+
+// Extract returns the main content of doc as Markdown or plain text. Relative links are
+// resolved against pageURL. Scripts, navigation, footers and forms are left out.
+func Extract(doc *goquery.Document, pageURL string, format Format) string {
+	root := doc.Find("body")
+	for _, sel := range []string{"main", "article", "[role=main]"} {
+		if s := doc.Find(sel); s.Length() == 1 && len(strings.TrimSpace(s.Text())) >= minMainText {
+			root = s
+			break
+		}
+	}
+	if root.Length() == 0 {
+		return ""
+	}
+
+	c := &converter{markdown: format != PlainText}
+	c.base, _ = url.Parse(pageURL)
+	for _, n := range root.Nodes {
+		c.children(n)
+	}
+	return c.String()
+}
+
+// converter writes the content of HTML nodes as Markdown or plain text.
+type converter struct {
+	markdown bool
+	base     *url.URL
+	buf      bytes.Buffer
+	depth    int
+}
+
+func (c *converter) String() string {
+	lines := strings.Split(c.buf.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	return strings.TrimSpace(newlinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func (c *converter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(child)
+	}
+}
+
+func (c *converter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+
+	tag := n.Data
+	switch {
+	case skippedTags[tag] || attr(n, "hidden") != "" || attr(n, "aria-hidden") == "true":
+	case tag == "br":
+		c.newline(1)
+	case tag == "hr":
+		c.newline(2)
+		if c.markdown {
+			c.buf.WriteString("---")
+		}
+		c.newline(2)
+	case len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6':
+		c.newline(2)
+		if c.markdown {
+			c.buf.WriteString(strings.Repeat("#", int(tag[1]-'0')) + " ")
+		}
+		c.buf.WriteString(c.inline(n))
+		c.newline(2)
+	case tag == "p":
+		c.newline(2)
+		c.children(n)
+		c.newline(2)
+	case tag == "ul" || tag == "ol":
+		c.list(n, tag == "ol")
+	case tag == "pre":
+		c.newline(2)
+		text := strings.Trim(textOf(n), "\n")
+		if c.markdown {
+			c.buf.WriteString("```\n" + text + "\n```")
+		} else {
+			c.buf.WriteString(text)
+		}
+		c.newline(2)
+	case tag == "blockquote":
+		c.newline(2)
+		sub := &converter{markdown: c.markdown, base: c.base}
+		sub.children(n)
+		text := sub.String()
+		if c.markdown {
+			text = "> " + strings.ReplaceAll(text, "\n", "\n> ")
+		}
+		c.buf.WriteString(text)
+		c.newline(2)
+	case tag == "table":
+		c.table(n)
+	case tag == "code":
+		text := spaceRe.ReplaceAllString(textOf(n), " ")
+		if c.markdown && text != "" {
+			text = "`" + text + "`"
+		}
+		c.buf.WriteString(text)
+	case tag == "a":
+		text := c.inline(n)
+		href := c.resolve(attr(n, "href"))
+		if c.markdown && text != "" && href != "" {
+			text = "[" + text + "](" + href + ")"
+		}
+		c.buf.WriteString(text)
+	case tag == "strong" || tag == "b":
+		c.wrap(n, "**")
+	case tag == "em" || tag == "i":
+		c.wrap(n, "*")
+	case tag == "img":
+		alt := strings.TrimSpace(attr(n, "alt"))
+		if alt == "" {
+			return
+		}
+		if src := c.resolve(attr(n, "src")); c.markdown && src != "" {
+			alt = "![" + alt + "](" + src + ")"
+		}
+		c.buf.WriteString(alt)
+	case blockTags[tag]:
+		c.newline(1)
+		c.children(n)
+		c.newline(1)
+	default:
+		c.children(n)
+	}
+}
+
+// text writes s with runs of whitespace collapsed.
+func (c *converter) text(s string) {
+	s = spaceRe.ReplaceAllString(s, " ")
+	if c.atLineStart() {
+		s = strings.TrimLeft(s, " ")
+	}
+	c.buf.WriteString(s)
+}
+
+// inline renders the children of n on a single line.
+func (c *converter) inline(n *html.Node) string {
+	sub := &converter{markdown: c.markdown, base: c.base}
+	sub.children(n)
+	return strings.TrimSpace(spaceRe.ReplaceAllString(sub.buf.String(), " "))
+}
+
+func (c *converter) wrap(n *html.Node, marker string) {
+	text := c.inline(n)
+	if c.markdown && text != "" {
+		text = marker + text + marker
+	}
+	c.buf.WriteString(text)
+}
+
+func (c *converter) list(n *html.Node, ordered bool) {
+	if c.depth == 0 {
+		c.newline(2)
+	} else {
+		c.newline(1)
+	}
+	c.depth++
+	i := 1
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		c.newline(1)
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+		c.buf.WriteString(strings.Repeat("  ", c.depth-1) + marker)
+		c.children(li)
+		i++
+	}
+	c.depth--
+	if c.depth == 0 {
+		c.newline(2)
+	} else {
+		c.newline(1)
+	}
+}
+
+func (c *converter) table(n *html.Node) {
+	var rows [][]string
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			case "tr":
+				var cells []string
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						cells = append(cells, strings.ReplaceAll(c.inline(cell), "|", `\|`))
+					}
+				}
+				if len(cells) > 0 {
+					rows = append(rows, cells)
+				}
+			}
+		}
+	}
+	collect(n)
+	if len(rows) == 0 {
+		return
+	}
+
+	c.newline(2)
+	for i, row := range rows {
+		if !c.markdown {
+			c.buf.WriteString(strings.Join(row, "\t") + "\n")
+			continue
+		}
+		c.buf.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			c.buf.WriteString("|" + strings.Repeat(" --- |", len(row)) + "\n")
+		}
+	}
+	c.newline(2)
+}
+
+// newline ends the current line and makes sure the output ends with n newlines.
+func (c *converter) newline(n int) {
+	b := bytes.TrimRight(c.buf.Bytes(), " ")
+	c.buf.Truncate(len(b))
+	if c.buf.Len() == 0 {
+		return
+	}
+	have := len(b) - len(bytes.TrimRight(b, "\n"))
+	for ; have < n; have++ {
+		c.buf.WriteByte('\n')
+	}
+}
+
+func (c *converter) atLineStart() bool {
+	b := c.buf.Bytes()
+	return len(b) == 0 || b[len(b)-1] == '\n' || b[len(b)-1] == ' '
+}
+
+// resolve returns href as an absolute URL, or "" for links that go nowhere.
+func (c *converter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if c.base != nil {
+		u = c.base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			if a.Val == "" {
+				// Boolean attributes such as hidden have no value.
+				return key
+			}
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textOf(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package browser
+
+import (
+	"os"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestExtract(t *testing.T) {
+	f, err := os.Open("testdata/article.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markdown := "# Generics in Go\n\n" +
+		"Go 1.18 added **type parameters**, see the [tutorial](https://go.dev/doc/tutorial/generics) and notes.\n\n" +
+		"## Constraints\n\n" +
+		"- any\n" +
+		"- comparable\n" +
+		"  1. maps\n" +
+		"  2. switches\n\n" +
+		"```\nfunc Map[T, U any](s []T, f func(T) U) []U {\n\treturn nil\n}\n```\n\n" +
+		"| Version | Feature |\n" +
+		"| --- | --- |\n" +
+		"| 1.18 | Generics |\n\n" +
+		"> Less is exponentially more."
+	if got := Extract(doc, "https://go.dev/blog/generics", Markdown); got != markdown {
+		t.Errorf("markdown:\n%s\n\nwant:\n%s", got, markdown)
+	}
+
+	text := "Generics in Go\n\n" +
+		"Go 1.18 added type parameters, see the tutorial and notes.\n\n" +
+		"Constraints\n\n" +
+		"- any\n" +
+		"- comparable\n" +
+		"  1. maps\n" +
+		"  2. switches\n\n" +
+		"func Map[T, U any](s []T, f func(T) U) []U {\n\treturn nil\n}\n\n" +
+		"Version\tFeature\n" +
+		"1.18\tGenerics\n\n" +
+		"Less is exponentially more."
+	if got := Extract(doc, "https://go.dev/blog/generics", PlainText); got != text {
+		t.Errorf("text:\n%s\n\nwant:\n%s", got, text)
+	}
+}
@@ -0,0 +1,494 @@
+// Package browser manages the headless Chrome instance used for searches and page rendering.
+package browser
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/inspector"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// DefaultSize is the number of tabs used when Options.Size is zero.
+	DefaultSize = 3
+
+	// DefaultMaxUses is the number of uses after which a tab is recycled when Options.MaxUses is zero.
+	DefaultMaxUses = 50
+
+	// DefaultHealthInterval is how often idle tabs are probed when Options.HealthInterval is zero.
+	DefaultHealthInterval = 30 * time.Second
+
+	// DefaultProbeTimeout is how long a probe may take when Options.ProbeTimeout is zero.
+	DefaultProbeTimeout = 5 * time.Second
+)
+
+// ErrClosed is returned by Acquire after the Manager has been closed.
+var ErrClosed = errors.New("browser manager closed")
+
+// Options configures a Manager.
+type Options struct {
+
+	// Size sets how many tabs can be used at the same time.
+	// Default: DefaultSize
+	Size int
+
+	// MaxUses sets how many times a tab is used before it is closed and replaced by a new one.
+	// Negative values disable recycling.
+	// Default: DefaultMaxUses
+	MaxUses int
+
+	// HealthInterval sets how often idle tabs are probed and the browser process is checked.
+	// Default: DefaultHealthInterval
+	HealthInterval time.Duration
+
+	// ProbeTimeout sets how long a tab may take to answer a probe before it is considered hung.
+	// Default: DefaultProbeTimeout
+	ProbeTimeout time.Duration
+
+	// Visible starts Chrome with a window instead of headless.
+	Visible bool
+
+	// UserDataDir is the Chrome profile directory, see UserDataStore. Leave it empty to
+	// start every run with a fresh temporary profile.
+	UserDataDir string
+
+	// Profile is the fingerprint applied to every tab.
+	// Default: DefaultProfile
+	Profile *Profile
+
+	// AllocatorOptions are appended to the default Chrome flags.
+	AllocatorOptions []chromedp.ExecAllocatorOption
+}
+
+// Status describes the state of a Manager.
+type Status struct {
+
+	// Running reports whether Chrome is currently started.
+	Running bool `json:"running"`
+
+	// PID is the process ID of Chrome, or zero if it isn't running.
+	PID int `json:"pid"`
+
+	// Size is the maximum number of tabs.
+	Size int `json:"size"`
+
+	// Busy is the number of tabs currently in use.
+	Busy int `json:"busy"`
+
+	// Idle is the number of open tabs waiting to be used.
+	Idle int `json:"idle"`
+
+	// Starts counts how many times Chrome has been started, including restarts.
+	Starts int `json:"starts"`
+
+	// Recycled counts tabs replaced after reaching MaxUses.
+	Recycled int `json:"recycled"`
+
+	// Replaced counts tabs replaced because they crashed or hung.
+	Replaced int `json:"replaced"`
+
+	// LastError is the last error that made the Manager start or replace something.
+	LastError string `json:"lastError"`
+}
+
+// Manager owns a Chrome process and a pool of tabs in it. Chrome is started lazily by
+// the first Acquire and restarted transparently if it dies.
+type Manager struct {
+	opts Options
+
+	lock          sync.Mutex
+	allocCancel   context.CancelFunc
+	browserCtx    context.Context
+	browserCancel context.CancelFunc
+	generation    int
+	starting      chan struct{}
+	idle          []*Tab
+	busy          int
+	closed        bool
+	status        Status
+
+	slots chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// Tab is a browser tab leased from a Manager.
+type Tab struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	generation int
+	uses       int
+	crashed    int32
+}
+
+// Context returns the chromedp context of the tab.
+func (t *Tab) Context() context.Context {
+	return t.ctx
+}
+
+// NewManager creates a Manager. Chrome isn't started until a tab is acquired.
+func NewManager(opts Options) *Manager {
+	if opts.Size <= 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.MaxUses == 0 {
+		opts.MaxUses = DefaultMaxUses
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = DefaultHealthInterval
+	}
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = DefaultProbeTimeout
+	}
+	m := &Manager{
+		opts:  opts,
+		slots: make(chan struct{}, opts.Size),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	m.status.Size = opts.Size
+	go m.healthLoop()
+	return m
+}
+
+// Acquire leases a tab, starting Chrome if needed. It blocks until a tab is free or ctx
+// is done. The tab must be handed back with Release.
+func (m *Manager) Acquire(ctx context.Context) (*Tab, error) {
+	select {
+	case m.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	t, err := m.take()
+	if err != nil {
+		<-m.slots
+		return nil, err
+	}
+	return t, nil
+}
+
+// Release hands a tab back. Pass the error of the work done in the tab, if any: a tab
+// that failed is probed before it is reused.
+func (m *Manager) Release(t *Tab, err error) {
+	defer func() { <-m.slots }()
+	t.uses++
+
+	healthy := atomic.LoadInt32(&t.crashed) == 0
+	if healthy && err != nil {
+		healthy = m.probe(t) == nil
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.busy--
+	switch {
+	case m.closed || t.generation != m.generation:
+		t.cancel()
+	case !healthy:
+		m.status.Replaced++
+		if err != nil {
+			m.status.LastError = err.Error()
+		}
+		t.cancel()
+	case m.opts.MaxUses > 0 && t.uses >= m.opts.MaxUses:
+		m.status.Recycled++
+		t.cancel()
+	default:
+		m.idle = append(m.idle, t)
+	}
+}
+
+// Run acquires a tab, runs f with a context derived from it and releases the tab.
+// The context passed to f is also cancelled when ctx is done.
+func (m *Manager) Run(ctx context.Context, f func(ctx context.Context) error) error {
+	t, err := m.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	tctx, cancel := context.WithCancel(t.Context())
+	stop := context.AfterFunc(ctx, cancel)
+	err = f(tctx)
+	stop()
+	cancel()
+	m.Release(t, err)
+	return err
+}
+
+// Exclusive waits until no tab is in use, stops Chrome and runs f while no tab can be
+// acquired, e.g. to let another Chrome use the same user data directory. Chrome is
+// started again by the next Acquire.
+func (m *Manager) Exclusive(ctx context.Context, f func() error) error {
+	held := 0
+	defer func() {
+		for ; held > 0; held-- {
+			<-m.slots
+		}
+	}()
+	for ; held < m.opts.Size; held++ {
+		select {
+		case m.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return ErrClosed
+	}
+	m.shutdownLocked()
+	m.lock.Unlock()
+	return f()
+}
+
+// SetUserDataDir switches to another Chrome profile directory. It waits until no tab is
+// in use and stops Chrome, which is started again with dir by the next Acquire.
+func (m *Manager) SetUserDataDir(ctx context.Context, dir string) error {
+	return m.Exclusive(ctx, func() error {
+		m.lock.Lock()
+		m.opts.UserDataDir = dir
+		m.lock.Unlock()
+		return nil
+	})
+}
+
+// Status returns the current state of the Manager.
+func (m *Manager) Status() Status {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := m.status
+	s.Busy = m.busy
+	s.Idle = len(m.idle)
+	if m.browserCtx != nil {
+		if c := chromedp.FromContext(m.browserCtx); c != nil && c.Browser != nil {
+			if p := c.Browser.Process(); p != nil {
+				s.PID = p.Pid
+			}
+		}
+	}
+	return s
+}
+
+// Close closes every tab and stops Chrome. Tabs still leased are closed when released.
+func (m *Manager) Close() {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	m.closed = true
+	m.shutdownLocked()
+	m.lock.Unlock()
+	close(m.stop)
+	<-m.done
+}
+
+// take returns an idle tab or opens a new one. A slot must be held.
+func (m *Manager) take() (*Tab, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for {
+		if m.closed {
+			return nil, ErrClosed
+		}
+		if m.browserCtx != nil && m.lostConnectionLocked() {
+			m.status.LastError = "browser connection lost"
+			m.shutdownLocked()
+		}
+		if m.browserCtx == nil {
+			if starting := m.starting; starting != nil {
+				// Another caller is starting Chrome.
+				m.lock.Unlock()
+				<-starting
+				m.lock.Lock()
+				continue
+			}
+			if err := m.startLocked(); err != nil {
+				m.status.LastError = err.Error()
+				return nil, err
+			}
+		}
+		if n := len(m.idle); n > 0 {
+			t := m.idle[n-1]
+			m.idle = m.idle[:n-1]
+			m.busy++
+			return t, nil
+		}
+
+		// Opening a tab can take a while, so it is done without holding the lock.
+		browserCtx, generation, profile := m.browserCtx, m.generation, DefaultProfile
+		if m.opts.Profile != nil {
+			profile = *m.opts.Profile
+		}
+		m.lock.Unlock()
+		t, err := newTab(browserCtx, generation, profile)
+		m.lock.Lock()
+		if err != nil {
+			m.status.LastError = err.Error()
+			return nil, err
+		}
+		if m.closed || t.generation != m.generation {
+			// Chrome was stopped meanwhile.
+			t.cancel()
+			continue
+		}
+		m.busy++
+		return t, nil
+	}
+}
+
+// startLocked launches Chrome. m.lock must be held. It is released while Chrome starts,
+// so that Status isn't blocked, and other callers wait for m.starting meanwhile.
+func (m *Manager) startLocked() error {
+	starting := make(chan struct{})
+	m.starting = starting
+	defer func() {
+		m.starting = nil
+		close(starting)
+	}()
+
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("headless", !m.opts.Visible),
+		chromedp.DisableGPU,
+		chromedp.Flag("enable-automation", false),
+	)
+	if m.opts.UserDataDir != "" {
+		opts = append(opts, chromedp.UserDataDir(m.opts.UserDataDir))
+	}
+	opts = append(opts, m.opts.AllocatorOptions...)
+	m.lock.Unlock()
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+	err := chromedp.Run(browserCtx)
+	m.lock.Lock()
+	if err == nil && m.closed {
+		err = ErrClosed
+	}
+	if err != nil {
+		browserCancel()
+		allocCancel()
+		return err
+	}
+	m.allocCancel = allocCancel
+	m.browserCtx = browserCtx
+	m.browserCancel = browserCancel
+	m.generation++
+	m.status.Running = true
+	m.status.Starts++
+	return nil
+}
+
+// shutdownLocked closes idle tabs and stops Chrome. Leased tabs are closed when
+// released, as they belong to an old generation. m.lock must be held.
+func (m *Manager) shutdownLocked() {
+	for _, t := range m.idle {
+		t.cancel()
+	}
+	m.idle = nil
+	if m.browserCtx != nil {
+		m.browserCancel()
+		m.allocCancel()
+		m.browserCtx = nil
+	}
+	m.status.Running = false
+}
+
+func (m *Manager) lostConnectionLocked() bool {
+	c := chromedp.FromContext(m.browserCtx)
+	if c == nil || c.Browser == nil {
+		return true
+	}
+	select {
+	case <-c.Browser.LostConnection:
+		return true
+	default:
+		return false
+	}
+}
+
+// newTab opens a tab in the browser of browserCtx, which belongs to generation, and
+// applies profile to it.
+func newTab(browserCtx context.Context, generation int, profile Profile) (*Tab, error) {
+	ctx, cancel := chromedp.NewContext(browserCtx)
+	t := &Tab{ctx: ctx, cancel: cancel, generation: generation}
+	// Listen before the tab is created, so that a crash during setup is caught too.
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		if _, ok := ev.(*inspector.EventTargetCrashed); ok {
+			atomic.StoreInt32(&t.crashed, 1)
+			// Fail whatever is running in the tab instead of letting it hang.
+			go cancel()
+		}
+	})
+	if err := chromedp.Run(ctx, profile.Apply()); err != nil {
+		cancel()
+		return nil, err
+	}
+	return t, nil
+}
+
+// probe checks that the tab still evaluates JavaScript in time.
+func (m *Manager) probe(t *Tab) error {
+	ctx, cancel := context.WithTimeout(t.ctx, m.opts.ProbeTimeout)
+	defer cancel()
+	var ok bool
+	return chromedp.Run(ctx, chromedp.Evaluate("true", &ok))
+}
+
+// healthLoop periodically restarts a dead browser and replaces hung or crashed idle tabs.
+func (m *Manager) healthLoop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		m.lock.Lock()
+		if m.browserCtx != nil && m.lostConnectionLocked() {
+			// Chrome is started again by the next Acquire.
+			m.status.LastError = "browser connection lost"
+			m.shutdownLocked()
+		}
+		tabs := m.idle
+		m.idle = nil
+		m.lock.Unlock()
+
+		var healthy []*Tab
+		var lastErr error
+		replaced := 0
+		for _, t := range tabs {
+			err := m.probe(t)
+			if err == nil && atomic.LoadInt32(&t.crashed) == 0 {
+				healthy = append(healthy, t)
+				continue
+			}
+			if err != nil {
+				lastErr = err
+			}
+			replaced++
+			t.cancel()
+		}
+
+		m.lock.Lock()
+		m.status.Replaced += replaced
+		if lastErr != nil {
+			m.status.LastError = lastErr.Error()
+		}
+		for _, t := range healthy {
+			if m.closed || t.generation != m.generation {
+				t.cancel()
+				continue
+			}
+			m.idle = append(m.idle, t)
+		}
+		m.lock.Unlock()
+	}
+}
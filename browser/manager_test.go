@@ -0,0 +1,95 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestManagerLazyStart(t *testing.T) {
+	m := NewManager(Options{Size: 2})
+	if s := m.Status(); s.Running || s.Starts != 0 || s.Size != 2 {
+		t.Fatalf("status before first Acquire = %+v, want a stopped browser", s)
+	}
+	m.Close()
+	if _, err := m.Acquire(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Acquire after Close: got error %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestManagerRecycle(t *testing.T) {
+	requireChrome(t)
+	m := NewManager(Options{Size: 1, MaxUses: 2})
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := m.Run(context.Background(), func(ctx context.Context) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := m.Status()
+	if !s.Running || s.Starts != 1 {
+		t.Errorf("status = %+v, want Chrome started once", s)
+	}
+	if s.Recycled != 1 || s.Idle != 1 || s.Busy != 0 {
+		t.Errorf("status = %+v, want one recycled tab and one idle tab", s)
+	}
+}
+
+func TestManagerStatusWhileStarting(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as Chrome")
+	}
+	// A Chrome that takes a while to start and then fails.
+	chrome := filepath.Join(t.TempDir(), "chrome")
+	if err := os.WriteFile(chrome, []byte("#!/bin/sh\nsleep 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(Options{Size: 2, AllocatorOptions: []chromedp.ExecAllocatorOption{chromedp.ExecPath(chrome)}})
+	defer m.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := m.Acquire(context.Background())
+			errs <- err
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	status := make(chan Status)
+	go func() { status <- m.Status() }()
+	select {
+	case s := <-status:
+		if s.Running {
+			t.Errorf("status while starting = %+v, want a stopped browser", s)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Status blocked while Chrome starts")
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Error("Acquire with a failing Chrome succeeded")
+		}
+	}
+	if s := m.Status(); s.Running || s.Starts != 0 || s.LastError == "" {
+		t.Errorf("status after a failed start = %+v", s)
+	}
+}
+
+// requireChrome skips tests that need a Chrome binary when none is installed.
+func requireChrome(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"google-chrome", "chromium", "chromium-browser", "chrome"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return
+		}
+	}
+	t.Skip("Chrome is not installed")
+}
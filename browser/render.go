@@ -0,0 +1,159 @@
+package browser
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// DefaultIdleTime is how long the network must be quiet when RenderOptions.IdleTime is zero.
+	DefaultIdleTime = 500 * time.Millisecond
+
+	// DefaultRenderTimeout bounds waiting for network idle when RenderOptions.Timeout is zero.
+	DefaultRenderTimeout = 20 * time.Second
+
+	// screenshotQuality is the JPEG quality of full-page screenshots.
+	screenshotQuality = 70
+)
+
+// Format is the text format a rendered page is extracted to.
+type Format string
+
+// Supported formats.
+const (
+	Markdown  Format = "markdown"
+	PlainText Format = "text"
+)
+
+// RenderOptions configures Render.
+type RenderOptions struct {
+
+	// Format sets how the content is extracted.
+	// Default: Markdown
+	Format Format
+
+	// Screenshot captures the whole page as a JPEG.
+	Screenshot bool
+
+	// IdleTime is how long no request may be in flight for the network to be idle.
+	// Default: DefaultIdleTime
+	IdleTime time.Duration
+
+	// Timeout bounds how long to wait for the network to become idle. The page is
+	// extracted as it is once it elapses.
+	// Default: DefaultRenderTimeout
+	Timeout time.Duration
+}
+
+// Page is a page rendered by Chrome.
+type Page struct {
+
+	// URL is the address of the page after redirects.
+	URL string
+
+	Title string
+
+	// Content is the main content of the page in the requested format.
+	Content string
+
+	// Screenshot is the full-page JPEG, if requested.
+	Screenshot []byte
+}
+
+// Render loads pageURL in the tab of ctx, waits for the network to become idle so
+// scripts have rendered the page, and extracts its content.
+func Render(ctx context.Context, pageURL string, opts RenderOptions) (*Page, error) {
+	if opts.Format == "" {
+		opts.Format = Markdown
+	}
+	if opts.IdleTime <= 0 {
+		opts.IdleTime = DefaultIdleTime
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultRenderTimeout
+	}
+
+	if err := navigateIdle(ctx, pageURL, opts.IdleTime, opts.Timeout); err != nil {
+		return nil, err
+	}
+
+	p := &Page{}
+	var html string
+	err := chromedp.Run(ctx,
+		chromedp.Location(&p.URL),
+		chromedp.Title(&p.Title),
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Screenshot {
+		if err := chromedp.Run(ctx, chromedp.FullScreenshot(&p.Screenshot, screenshotQuality)); err != nil {
+			return nil, err
+		}
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+	p.Content = Extract(doc, p.URL, opts.Format)
+	return p, nil
+}
+
+// navigateIdle navigates to pageURL and waits until no request has been in flight for
+// idle, or until timeout elapses.
+func navigateIdle(ctx context.Context, pageURL string, idle, timeout time.Duration) error {
+	var (
+		lock     sync.Mutex
+		inflight = map[network.RequestID]struct{}{}
+		last     = time.Now()
+	)
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chromedp.ListenTarget(lctx, func(ev interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			inflight[e.RequestID] = struct{}{}
+		case *network.EventLoadingFinished:
+			delete(inflight, e.RequestID)
+		case *network.EventLoadingFailed:
+			delete(inflight, e.RequestID)
+		default:
+			return
+		}
+		last = time.Now()
+	})
+
+	if err := chromedp.Run(ctx, chromedp.Navigate(pageURL)); err != nil {
+		return err
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(idle / 5)
+	defer ticker.Stop()
+	for {
+		lock.Lock()
+		quiet := len(inflight) == 0 && time.Since(last) >= idle
+		lock.Unlock()
+		if quiet {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
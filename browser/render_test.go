@@ -0,0 +1,40 @@
+package browser
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	requireChrome(t)
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+	m := NewManager(Options{Size: 1})
+	defer m.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var page *Page
+	err := m.Run(ctx, func(ctx context.Context) (err error) {
+		page, err = Render(ctx, ts.URL+"/spa.html", RenderOptions{Screenshot: true})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Title != "Generics in Go" {
+		t.Errorf("title = %q, want the one set by the script", page.Title)
+	}
+	if !strings.Contains(page.Content, "## Constraints") || strings.Contains(page.Content, "Loading") {
+		t.Errorf("content isn't the rendered article:\n%s", page.Content)
+	}
+	if !bytes.HasPrefix(page.Screenshot, []byte{0xff, 0xd8}) {
+		t.Errorf("screenshot isn't a JPEG")
+	}
+}
@@ -0,0 +1,351 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Profile is a consistent browser fingerprint. Applying it overrides what automated or
+// headless Chrome gives away: navigator.webdriver, the user agent and its client hints,
+// plugins, languages, WebGL vendor, permissions, the chrome runtime object, timezone
+// and viewport.
+type Profile struct {
+
+	// Name identifies the profile.
+	Name string `json:"name"`
+
+	// UserAgent is sent in the User-Agent header and reported by navigator.userAgent.
+	// Its Chrome version is also used for the Sec-CH-UA brands.
+	UserAgent string `json:"userAgent"`
+
+	// Platform is reported by navigator.platform, e.g. "Win32".
+	Platform string `json:"platform"`
+
+	// OS and OSVersion are reported by the Sec-CH-UA-Platform client hints, e.g. "Windows".
+	OS        string `json:"os"`
+	OSVersion string `json:"osVersion"`
+
+	// Languages are reported by navigator.languages and sent in Accept-Language, most
+	// preferred first.
+	Languages []string `json:"languages"`
+
+	// Timezone is an IANA time zone such as "Europe/Berlin". Empty keeps the time zone of the host.
+	Timezone string `json:"timezone"`
+
+	// Width and Height set the viewport and screen size in CSS pixels.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// DeviceScaleFactor is reported by window.devicePixelRatio.
+	DeviceScaleFactor float64 `json:"deviceScaleFactor"`
+
+	// HardwareConcurrency and DeviceMemory are reported by navigator.
+	HardwareConcurrency int `json:"hardwareConcurrency"`
+	DeviceMemory        int `json:"deviceMemory"`
+
+	// WebGLVendor and WebGLRenderer are reported by the WEBGL_debug_renderer_info extension.
+	WebGLVendor   string `json:"webglVendor"`
+	WebGLRenderer string `json:"webglRenderer"`
+}
+
+// Built-in profiles of a desktop Chrome on each major OS.
+var (
+	WindowsProfile = Profile{
+		Name:                "windows",
+		UserAgent:           "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36",
+		Platform:            "Win32",
+		OS:                  "Windows",
+		OSVersion:           "10.0.0",
+		Languages:           []string{"en-US", "en"},
+		Width:               1920,
+		Height:              1080,
+		DeviceScaleFactor:   1,
+		HardwareConcurrency: 8,
+		DeviceMemory:        8,
+		WebGLVendor:         "Google Inc. (NVIDIA)",
+		WebGLRenderer:       "ANGLE (NVIDIA, NVIDIA GeForce GTX 1650 Direct3D11 vs_5_0 ps_5_0, D3D11)",
+	}
+
+	MacProfile = Profile{
+		Name:                "mac",
+		UserAgent:           "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36",
+		Platform:            "MacIntel",
+		OS:                  "macOS",
+		OSVersion:           "14.4.0",
+		Languages:           []string{"en-US", "en"},
+		Width:               1440,
+		Height:              900,
+		DeviceScaleFactor:   2,
+		HardwareConcurrency: 8,
+		DeviceMemory:        8,
+		WebGLVendor:         "Google Inc. (Apple)",
+		WebGLRenderer:       "ANGLE (Apple, ANGLE Metal Renderer: Apple M1, Unspecified Version)",
+	}
+
+	LinuxProfile = Profile{
+		Name:                "linux",
+		UserAgent:           "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36",
+		Platform:            "Linux x86_64",
+		OS:                  "Linux",
+		Languages:           []string{"en-US", "en"},
+		Width:               1920,
+		Height:              1080,
+		DeviceScaleFactor:   1,
+		HardwareConcurrency: 4,
+		DeviceMemory:        8,
+		WebGLVendor:         "Google Inc. (Intel)",
+		WebGLRenderer:       "ANGLE (Intel, Mesa Intel(R) UHD Graphics 620 (KBL GT2), OpenGL 4.6)",
+	}
+
+	// DefaultProfile is used when no profile is configured.
+	DefaultProfile = WindowsProfile
+)
+
+// Profiles lists the built-in profiles by name.
+var Profiles = map[string]Profile{
+	WindowsProfile.Name: WindowsProfile,
+	MacProfile.Name:     MacProfile,
+	LinuxProfile.Name:   LinuxProfile,
+}
+
+var chromeVersionRe = regexp.MustCompile(`Chrome/(\d+)((?:\.\d+)*)`)
+
+// WithLanguage returns a copy of p preferring language lc, e.g. "de" or "zh-TW". The
+// profile is returned unchanged if its preferred language already is lc.
+func (p Profile) WithLanguage(lc string) Profile {
+	if lc == "" || len(p.Languages) > 0 && strings.EqualFold(baseLanguage(p.Languages[0]), baseLanguage(lc)) {
+		return p
+	}
+	languages := []string{lc}
+	if base := baseLanguage(lc); base != lc {
+		languages = append(languages, base)
+	}
+	for _, l := range p.Languages {
+		if !strings.EqualFold(baseLanguage(l), baseLanguage(lc)) {
+			languages = append(languages, l)
+		}
+	}
+	p.Languages = languages
+	return p
+}
+
+// AcceptLanguage returns the Accept-Language header matching p.Languages.
+func (p Profile) AcceptLanguage() string {
+	parts := make([]string, 0, len(p.Languages))
+	for i, l := range p.Languages {
+		q := 10 - i
+		switch {
+		case i == 0:
+			parts = append(parts, l)
+		case q > 1:
+			parts = append(parts, fmt.Sprintf("%s;q=0.%d", l, q))
+		default:
+			parts = append(parts, l+";q=0.1")
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Apply returns the action applying p to the target of the context it runs in, both to
+// the current document and to every document loaded afterwards.
+func (p Profile) Apply() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		script, err := p.script()
+		if err != nil {
+			return err
+		}
+		if _, err := page.AddScriptToEvaluateOnNewDocument(script).Do(ctx); err != nil {
+			return err
+		}
+		// The script above only runs in documents loaded from now on.
+		_, exception, err := runtime.Evaluate(script).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if exception != nil {
+			return exception
+		}
+
+		if p.UserAgent != "" {
+			override := emulation.SetUserAgentOverride(p.UserAgent).
+				WithPlatform(p.Platform).
+				WithUserAgentMetadata(p.clientHints())
+			if len(p.Languages) > 0 {
+				override = override.WithAcceptLanguage(p.AcceptLanguage())
+			}
+			if err := override.Do(ctx); err != nil {
+				return err
+			}
+		}
+		if len(p.Languages) > 0 {
+			if err := emulation.SetLocaleOverride().WithLocale(p.Languages[0]).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if p.Timezone != "" {
+			if err := emulation.SetTimezoneOverride(p.Timezone).Do(ctx); err != nil {
+				return fmt.Errorf("timezone %q: %w", p.Timezone, err)
+			}
+		}
+		if p.Width > 0 && p.Height > 0 {
+			scale := p.DeviceScaleFactor
+			if scale <= 0 {
+				scale = 1
+			}
+			err := emulation.SetDeviceMetricsOverride(int64(p.Width), int64(p.Height), scale, false).
+				WithScreenWidth(int64(p.Width)).
+				WithScreenHeight(int64(p.Height)).
+				Do(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// clientHints returns the Sec-CH-UA metadata matching the Chrome version of p.UserAgent.
+func (p Profile) clientHints() *emulation.UserAgentMetadata {
+	major, full := "", ""
+	if m := chromeVersionRe.FindStringSubmatch(p.UserAgent); m != nil {
+		major, full = m[1], m[1]+m[2]
+	}
+	md := &emulation.UserAgentMetadata{
+		Platform:        p.OS,
+		PlatformVersion: p.OSVersion,
+		Architecture:    "x86",
+		Bitness:         "64",
+	}
+	if p.OS == "macOS" && strings.Contains(p.WebGLRenderer, "Apple M") {
+		md.Architecture = "arm"
+	}
+	if major != "" {
+		md.Brands = []*emulation.UserAgentBrandVersion{
+			{Brand: "Google Chrome", Version: major},
+			{Brand: "Chromium", Version: major},
+			{Brand: "Not.A/Brand", Version: "99"},
+		}
+		md.FullVersionList = []*emulation.UserAgentBrandVersion{
+			{Brand: "Google Chrome", Version: full},
+			{Brand: "Chromium", Version: full},
+			{Brand: "Not.A/Brand", Version: "99.0.0.0"},
+		}
+	}
+	return md
+}
+
+// script returns the JavaScript patching navigator and friends for p.
+func (p Profile) script() (string, error) {
+	config, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(stealthScript, config), nil
+}
+
+func baseLanguage(lc string) string {
+	return strings.SplitN(lc, "-", 2)[0]
+}
+
+// stealthScript is evaluated before any script of a document. %s is the profile as JSON.
+const stealthScript = `(() => {
+	const p = %s;
+
+	// Patched functions claim to be native, like the ones they replace.
+	const nativeToString = Function.prototype.toString;
+	const patched = new WeakMap();
+	const toString = function () {
+		return patched.has(this) ? patched.get(this) : nativeToString.call(this);
+	};
+	patched.set(toString, nativeToString.call(nativeToString));
+	Function.prototype.toString = toString;
+	const patch = (obj, name, fn) => {
+		const original = obj[name];
+		patched.set(fn, nativeToString.call(original));
+		obj[name] = fn;
+	};
+	const getter = (obj, name, value) => {
+		try {
+			Object.defineProperty(obj, name, { get: () => value, configurable: true, enumerable: true });
+		} catch (e) {}
+	};
+
+	getter(Navigator.prototype, 'webdriver', false);
+	if (p.platform) getter(Navigator.prototype, 'platform', p.platform);
+	if (p.languages && p.languages.length) {
+		getter(Navigator.prototype, 'language', p.languages[0]);
+		getter(Navigator.prototype, 'languages', Object.freeze(p.languages.slice()));
+	}
+	if (p.hardwareConcurrency) getter(Navigator.prototype, 'hardwareConcurrency', p.hardwareConcurrency);
+	if (p.deviceMemory) getter(Navigator.prototype, 'deviceMemory', p.deviceMemory);
+
+	// Headless Chrome used to report no plugins at all.
+	if (navigator.plugins.length === 0) {
+		const mime = { type: 'application/pdf', suffixes: 'pdf', description: 'Portable Document Format' };
+		const names = ['PDF Viewer', 'Chrome PDF Viewer', 'Chromium PDF Viewer', 'Microsoft Edge PDF Viewer', 'WebKit built-in PDF'];
+		const plugins = names.map((name) => {
+			const plugin = { name, filename: 'internal-pdf-viewer', description: 'Portable Document Format', length: 1, 0: mime };
+			plugin.item = (i) => (i === 0 ? mime : null);
+			plugin.namedItem = (type) => (type === mime.type ? mime : null);
+			return Object.setPrototypeOf(plugin, Plugin.prototype);
+		});
+		const list = Object.assign(Object.create(PluginArray.prototype), plugins, {
+			length: plugins.length,
+			item: (i) => plugins[i] || null,
+			namedItem: (name) => plugins.find((pl) => pl.name === name) || null,
+			refresh: () => {},
+		});
+		getter(Navigator.prototype, 'plugins', list);
+	}
+
+	// UNMASKED_VENDOR_WEBGL and UNMASKED_RENDERER_WEBGL.
+	for (const gl of [window.WebGLRenderingContext, window.WebGL2RenderingContext]) {
+		if (!gl || !p.webglVendor) continue;
+		const getParameter = gl.prototype.getParameter;
+		patch(gl.prototype, 'getParameter', function (param) {
+			if (param === 37445) return p.webglVendor;
+			if (param === 37446) return p.webglRenderer;
+			return getParameter.call(this, param);
+		});
+	}
+
+	// Headless Chrome answers "denied" for notifications while Notification.permission is "default".
+	if (navigator.permissions && window.Notification) {
+		const permissions = Object.getPrototypeOf(navigator.permissions);
+		const query = permissions.query;
+		patch(permissions, 'query', function (descriptor) {
+			if (descriptor && descriptor.name === 'notifications') {
+				const state = Notification.permission === 'default' ? 'prompt' : Notification.permission;
+				return Promise.resolve(Object.setPrototypeOf({ state, onchange: null }, PermissionStatus.prototype));
+			}
+			return query.call(this, descriptor);
+		});
+	}
+
+	if (!window.chrome) {
+		Object.defineProperty(window, 'chrome', { value: {}, writable: true, configurable: true });
+	}
+	if (!window.chrome.runtime) {
+		window.chrome.runtime = {
+			OnInstalledReason: { CHROME_UPDATE: 'chrome_update', INSTALL: 'install', SHARED_MODULE_UPDATE: 'shared_module_update', UPDATE: 'update' },
+			PlatformOs: { ANDROID: 'android', CROS: 'cros', LINUX: 'linux', MAC: 'mac', OPENBSD: 'openbsd', WIN: 'win' },
+			connect: function connect() {},
+			sendMessage: function sendMessage() {},
+		};
+	}
+
+	if (p.width && p.height) {
+		getter(Screen.prototype, 'availWidth', p.width);
+		getter(Screen.prototype, 'availHeight', p.height - 40);
+		getter(window, 'outerWidth', p.width);
+		getter(window, 'outerHeight', p.height - 40);
+	}
+})();`
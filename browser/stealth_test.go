@@ -0,0 +1,129 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestProfileWithLanguage(t *testing.T) {
+	tests := []struct {
+		lc   string
+		want []string
+	}{
+		{"", []string{"en-US", "en"}},
+		{"en", []string{"en-US", "en"}},
+		{"de", []string{"de", "en-US", "en"}},
+		{"zh-TW", []string{"zh-TW", "zh", "en-US", "en"}},
+	}
+	for _, tt := range tests {
+		if got := WindowsProfile.WithLanguage(tt.lc).Languages; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("WithLanguage(%q) = %q, want %q", tt.lc, got, tt.want)
+		}
+	}
+	if got, want := WindowsProfile.WithLanguage("zh-TW").AcceptLanguage(), "zh-TW,zh;q=0.9,en-US;q=0.8,en;q=0.7"; got != want {
+		t.Errorf("AcceptLanguage() = %q, want %q", got, want)
+	}
+}
+
+func TestProfileClientHints(t *testing.T) {
+	md := MacProfile.clientHints()
+	if md.Platform != "macOS" || md.Architecture != "arm" {
+		t.Errorf("platform = %q %q, want macOS arm", md.Platform, md.Architecture)
+	}
+	if len(md.Brands) == 0 || md.Brands[0].Brand != "Google Chrome" || md.Brands[0].Version != "135" {
+		t.Errorf("brands = %+v, want Google Chrome 135 first", md.Brands)
+	}
+}
+
+func TestProfileFingerprint(t *testing.T) {
+	requireChrome(t)
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	profile := MacProfile.WithLanguage("de")
+	profile.Timezone = "Asia/Tokyo"
+	m := NewManager(Options{Size: 1, Profile: &profile})
+	defer m.Close()
+
+	var raw string
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := m.Run(ctx, func(ctx context.Context) error {
+		return chromedp.Run(ctx,
+			chromedp.Navigate(ts.URL+"/fingerprint.html"),
+			chromedp.WaitVisible("body.done", chromedp.ByQuery),
+			chromedp.Text("#fingerprint", &raw, chromedp.ByQuery),
+		)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fp struct {
+		Webdriver           bool     `json:"webdriver"`
+		UserAgent           string   `json:"userAgent"`
+		Platform            string   `json:"platform"`
+		UAPlatform          string   `json:"uaPlatform"`
+		UABrands            []string `json:"uaBrands"`
+		Language            string   `json:"language"`
+		Languages           []string `json:"languages"`
+		Plugins             int      `json:"plugins"`
+		HardwareConcurrency int      `json:"hardwareConcurrency"`
+		Timezone            string   `json:"timezone"`
+		ScreenWidth         int      `json:"screenWidth"`
+		ScreenHeight        int      `json:"screenHeight"`
+		InnerWidth          int      `json:"innerWidth"`
+		ChromeRuntime       bool     `json:"chromeRuntime"`
+		NativeToString      bool     `json:"nativeToString"`
+		WebGLVendor         string   `json:"webglVendor"`
+		WebGLRenderer       string   `json:"webglRenderer"`
+		Notifications       string   `json:"notifications"`
+	}
+	if err := json.Unmarshal([]byte(raw), &fp); err != nil {
+		t.Fatalf("parsing fingerprint %q: %v", raw, err)
+	}
+
+	if fp.Webdriver {
+		t.Error("navigator.webdriver is true")
+	}
+	if fp.UserAgent != profile.UserAgent || fp.Platform != profile.Platform || fp.UAPlatform != profile.OS {
+		t.Errorf("user agent = %q on %q/%q, want %q on %q/%q", fp.UserAgent, fp.Platform, fp.UAPlatform, profile.UserAgent, profile.Platform, profile.OS)
+	}
+	if len(fp.UABrands) == 0 || fp.UABrands[0] != "Google Chrome" {
+		t.Errorf("brands = %q, want Google Chrome", fp.UABrands)
+	}
+	if fp.Language != "de" || !reflect.DeepEqual(fp.Languages, profile.Languages) {
+		t.Errorf("languages = %q %q, want %q", fp.Language, fp.Languages, profile.Languages)
+	}
+	if fp.Plugins == 0 {
+		t.Error("no plugins")
+	}
+	if fp.HardwareConcurrency != profile.HardwareConcurrency {
+		t.Errorf("hardwareConcurrency = %d, want %d", fp.HardwareConcurrency, profile.HardwareConcurrency)
+	}
+	if fp.Timezone != profile.Timezone {
+		t.Errorf("timezone = %q, want %q", fp.Timezone, profile.Timezone)
+	}
+	if fp.ScreenWidth != profile.Width || fp.ScreenHeight != profile.Height || fp.InnerWidth != profile.Width {
+		t.Errorf("screen = %dx%d, inner width %d, want %dx%d", fp.ScreenWidth, fp.ScreenHeight, fp.InnerWidth, profile.Width, profile.Height)
+	}
+	if !fp.ChromeRuntime {
+		t.Error("window.chrome.runtime is missing")
+	}
+	if !fp.NativeToString {
+		t.Error("patched permissions.query doesn't look native")
+	}
+	if fp.WebGLVendor != "" && (fp.WebGLVendor != profile.WebGLVendor || fp.WebGLRenderer != profile.WebGLRenderer) {
+		t.Errorf("webgl = %q %q, want %q %q", fp.WebGLVendor, fp.WebGLRenderer, profile.WebGLVendor, profile.WebGLRenderer)
+	}
+	if fp.Notifications == "denied" {
+		t.Error("notifications permission is denied, which only headless Chrome reports")
+	}
+}
@@ -0,0 +1,103 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/chromedp/chromedp"
+)
+
+// ErrInvalidProfileName is returned for user data profile names that aren't safe as directory names.
+var ErrInvalidProfileName = errors.New("invalid browser profile name")
+
+var profileNameRe = regexp.MustCompile(`^[\p{L}\p{N}_-][\p{L}\p{N}_. -]{0,63}$`)
+
+// UserDataStore keeps named Chrome user data directories, so cookies, logins and local
+// storage persist across runs. Each name is a directory under Root.
+type UserDataStore struct {
+	Root string
+}
+
+// DefaultUserDataRoot returns the directory user data profiles of app are kept in by default.
+func DefaultUserDataRoot(app string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, app, "browser-profiles"), nil
+}
+
+// NewUserDataStore creates a UserDataStore, creating root if needed.
+func NewUserDataStore(root string) (*UserDataStore, error) {
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, err
+	}
+	return &UserDataStore{Root: root}, nil
+}
+
+// Dir returns the user data directory of the profile called name, creating it if needed.
+func (s *UserDataStore) Dir(name string) (string, error) {
+	if !profileNameRe.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidProfileName, name)
+	}
+	dir := filepath.Join(s.Root, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// List returns the names of the existing profiles in alphabetical order.
+func (s *UserDataStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && profileNameRe.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete removes the profile called name with everything stored in it.
+func (s *UserDataStore) Delete(name string) error {
+	if !profileNameRe.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidProfileName, name)
+	}
+	return os.RemoveAll(filepath.Join(s.Root, name))
+}
+
+// Login opens startURL in a visible Chrome window using userDataDir and returns once the
+// user has closed the window, so logins made there are kept in the profile. Chrome locks
+// its user data directory: no other Chrome may use userDataDir meanwhile, see
+// Manager.Exclusive.
+func Login(ctx context.Context, userDataDir string, startURL string) error {
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("headless", false),
+		chromedp.Flag("enable-automation", false),
+		chromedp.UserDataDir(userDataDir),
+	)
+	allocCtx, allocCancel := chromedp.NewExecAllocator(ctx, opts...)
+	defer allocCancel()
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+	defer browserCancel()
+
+	if err := chromedp.Run(browserCtx, chromedp.Navigate(startURL)); err != nil {
+		return err
+	}
+	select {
+	case <-chromedp.FromContext(browserCtx).Browser.LostConnection:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"xrmcp/colly"
+	"xrmcp/colly/distributed"
+)
+
+// Start the coordinator with
+//
+//	go run distributed.go -listen :8080
+//
+// and as many workers as needed, on any machine, with
+//
+//	go run distributed.go -coordinator http://localhost:8080
+func main() {
+	listen := flag.String("listen", "", "address to serve the coordinator at")
+	coordinator := flag.String("coordinator", "", "URL of the coordinator to work for")
+	flag.Parse()
+
+	if *listen != "" {
+		// The coordinator owns the queue and the visited URLs
+		c, err := distributed.NewCoordinator(nil, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.AddURL("http://go-colly.org/")
+		server := &http.Server{Addr: *listen, Handler: c}
+		go func() {
+			<-c.Done()
+			// let the other workers learn that the crawl is over
+			time.Sleep(5 * distributed.DefaultPollInterval)
+			server.Close()
+		}()
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Workers run the requests leased from the coordinator, 4 at a time
+	w := distributed.NewWorker(*coordinator, 4)
+	c := colly.NewCollector(
+		// The coordinator filters the visited URLs
+		colly.AllowURLRevisit(),
+		colly.AllowedDomains("go-colly.org"),
+	)
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		// Send the links found to the coordinator
+		w.AddURL(e.Request.AbsoluteURL(e.Attr("href")))
+	})
+	c.OnRequest(func(r *colly.Request) {
+		fmt.Println("visiting", r.URL)
+	})
+	if err := w.Run(c); err != nil {
+		log.Fatal(err)
+	}
+}
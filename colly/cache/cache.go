@@ -0,0 +1,382 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache implements a private HTTP cache following RFC 9111, with
+// pluggable stores.
+package cache
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a response held in a Store.
+type Entry struct {
+	// StatusCode, Header and Body are those of the stored response
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Vary lists the canonical names of the request headers selecting
+	// the response. Entries stored under the primary key of responses
+	// with a Vary header only hold Vary and point to their variants.
+	Vary []string
+	// RequestTime is when the request was sent
+	RequestTime time.Time
+	// ResponseTime is when the response was received
+	ResponseTime time.Time
+}
+
+// Store is the interface of cache storage backends.
+// Store must be concurrently safe for multiple goroutines.
+type Store interface {
+	// Get returns the entry stored under key, or nil if there is none
+	Get(key string) (*Entry, error)
+	// Set stores e under key, replacing any previous entry
+	Set(key string, e *Entry) error
+	// Delete removes the entry stored under key, if any
+	Delete(key string) error
+}
+
+// maxHeuristicLifetime bounds freshness lifetimes computed from Last-Modified.
+const maxHeuristicLifetime = 24 * time.Hour
+
+// heuristicStatusCodes can be cached without explicit freshness information.
+var heuristicStatusCodes = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// Cache decides which responses are stored in Store, when they can be
+// reused and how they are revalidated. Only GET responses are cached.
+type Cache struct {
+	// Store holds the cached responses
+	Store Store
+	// TTL, if positive, replaces the freshness lifetime given by the
+	// headers of every response below 500, including no-cache ones.
+	// Responses marked no-store are never stored.
+	TTL time.Duration
+	// now returns the current time; tests replace it
+	now func() time.Time
+}
+
+// New creates a Cache holding responses in s.
+func New(s Store) *Cache {
+	return &Cache{Store: s}
+}
+
+// Key returns the primary key of the responses to req: its method and
+// URL without fragment.
+func Key(req *http.Request) string {
+	return key(req.Method, req.URL)
+}
+
+func key(method string, u *url.URL) string {
+	stripped := *u
+	stripped.Fragment = ""
+	stripped.RawFragment = ""
+	return method + " " + stripped.String()
+}
+
+// Lookup returns the entry stored for req, or nil if there is none or
+// the stored response was selected by other request headers.
+func (c *Cache) Lookup(req *http.Request) (*Entry, error) {
+	if req.Method != http.MethodGet {
+		return nil, nil
+	}
+	e, err := c.Store.Get(Key(req))
+	if err != nil || e == nil || e.Vary == nil || e.StatusCode != 0 {
+		return e, err
+	}
+	variant, ok := variantKey(req, e.Vary)
+	if !ok {
+		return nil, nil
+	}
+	return c.Store.Get(variant)
+}
+
+// Fresh reports whether e may answer req without being revalidated.
+func (c *Cache) Fresh(req *http.Request, e *Entry) bool {
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+	if len(reqCC) == 0 && strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache") {
+		return false
+	}
+	respCC := parseCacheControl(e.Header)
+	if _, ok := respCC["no-cache"]; ok && c.TTL <= 0 {
+		return false
+	}
+
+	lifetime := c.lifetime(e)
+	age := c.age(e)
+	if maxAge, ok := seconds(reqCC, "max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := seconds(reqCC, "min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	if age < lifetime {
+		return true
+	}
+	if _, ok := respCC["must-revalidate"]; ok {
+		return false
+	}
+	v, ok := reqCC["max-stale"]
+	if !ok {
+		return false
+	}
+	if v == "" {
+		return true
+	}
+	maxStale, ok := seconds(reqCC, "max-stale")
+	return ok && age-lifetime <= maxStale
+}
+
+// Age returns the current age of e, as sent in the Age header of the
+// responses it answers.
+func (c *Cache) Age(e *Entry) time.Duration {
+	return c.age(e)
+}
+
+// AddConditions makes req conditional on the validators of e, so the
+// server answers 304 Not Modified if e is still valid. Conditions
+// already set on req are kept. It reports whether e has validators.
+func AddConditions(req *http.Request, e *Entry) bool {
+	etag, lastModified := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	if etag != "" && req.Header.Get("If-None-Match") == "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" && req.Header.Get("If-Modified-Since") == "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return etag != "" || lastModified != ""
+}
+
+// Merge returns the headers of e updated with those of a 304 response.
+func Merge(e *Entry, header http.Header) http.Header {
+	merged := e.Header.Clone()
+	for k, v := range header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		merged[k] = append([]string(nil), v...)
+	}
+	return merged
+}
+
+// Revalidated stores e as updated by the 304 response to req carrying
+// header, and returns the updated entry.
+func (c *Cache) Revalidated(req *http.Request, e *Entry, header http.Header, requestTime, responseTime time.Time) (*Entry, error) {
+	updated := *e
+	updated.Header = Merge(e, header)
+	updated.RequestTime, updated.ResponseTime = requestTime, responseTime
+	k := Key(req)
+	if updated.Vary != nil {
+		var ok bool
+		if k, ok = variantKey(req, updated.Vary); !ok {
+			return &updated, nil
+		}
+	}
+	return &updated, c.Store.Set(k, &updated)
+}
+
+// Put stores the response to req if RFC 9111 allows it.
+func (c *Cache) Put(req *http.Request, statusCode int, header http.Header, body []byte, requestTime, responseTime time.Time) error {
+	if !c.storable(req, statusCode, header) {
+		return nil
+	}
+	e := &Entry{
+		StatusCode:   statusCode,
+		Header:       header.Clone(),
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+	k := Key(req)
+	if vary := varyNames(header); vary != nil {
+		variant, ok := variantKey(req, vary)
+		if !ok {
+			return nil
+		}
+		if err := c.Store.Set(k, &Entry{Vary: vary}); err != nil {
+			return err
+		}
+		e.Vary, k = vary, variant
+	}
+	return c.Store.Set(k, e)
+}
+
+// Invalidate removes the responses stored for the URL of req if req is
+// an unsafe request answered with statusCode, as changes on the server
+// are to be expected.
+func (c *Cache) Invalidate(req *http.Request, statusCode int) error {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+	if statusCode < 200 || statusCode >= 400 {
+		return nil
+	}
+	return c.Store.Delete(key(http.MethodGet, req.URL))
+}
+
+func (c *Cache) storable(req *http.Request, statusCode int, header http.Header) bool {
+	if req.Method != http.MethodGet || statusCode < 200 || statusCode == http.StatusPartialContent || statusCode == http.StatusNotModified {
+		return false
+	}
+	if _, ok := parseCacheControl(req.Header)["no-store"]; ok {
+		return false
+	}
+	cc := parseCacheControl(header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if c.TTL > 0 && statusCode < 500 {
+		return true
+	}
+	for _, d := range []string{"public", "max-age", "s-maxage"} {
+		if _, ok := cc[d]; ok {
+			return true
+		}
+	}
+	return header.Get("Expires") != "" || heuristicStatusCodes[statusCode]
+}
+
+// lifetime returns the freshness lifetime of e (RFC 9111 4.2.1).
+func (c *Cache) lifetime(e *Entry) time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	cc := parseCacheControl(e.Header)
+	if maxAge, ok := seconds(cc, "max-age"); ok {
+		return maxAge
+	}
+	date := e.ResponseTime
+	if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		date = d
+	}
+	if v, ok := e.Header["Expires"]; ok {
+		expires, err := http.ParseTime(v[0])
+		if err != nil {
+			// Invalid dates, like "0", mean already expired.
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if !heuristicStatusCodes[e.StatusCode] {
+		return 0
+	}
+	lastModified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	if err != nil || !lastModified.Before(date) {
+		return 0
+	}
+	lifetime := date.Sub(lastModified) / 10
+	if lifetime > maxHeuristicLifetime {
+		lifetime = maxHeuristicLifetime
+	}
+	return lifetime
+}
+
+// age returns the current age of e (RFC 9111 4.2.3).
+func (c *Cache) age(e *Entry) time.Duration {
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil && e.ResponseTime.After(date) {
+		apparentAge = e.ResponseTime.Sub(date)
+	}
+	var ageValue time.Duration
+	if s, err := strconv.Atoi(strings.TrimSpace(e.Header.Get("Age"))); err == nil && s > 0 {
+		ageValue = time.Duration(s) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if correctedAge < apparentAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+// varyNames returns the sorted canonical header names of the Vary header,
+// or nil if there is none.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// variantKey returns the key of the response to req varying on the headers
+// named in vary. Responses varying on "*" can't be selected.
+func variantKey(req *http.Request, vary []string) (string, bool) {
+	var b strings.Builder
+	b.WriteString(Key(req))
+	for _, name := range vary {
+		if name == "*" {
+			return "", false
+		}
+		values := append([]string(nil), req.Header.Values(name)...)
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString("\n" + name + ": " + strings.Join(values, ", "))
+	}
+	return b.String(), true
+}
+
+// parseCacheControl returns the directives of the Cache-Control header
+// with their values, unquoted.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, v := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// seconds returns the value of directive as a duration.
+func seconds(directives map[string]string, directive string) (time.Duration, bool) {
+	v, ok := directives[directive]
+	if !ok {
+		return 0, false
+	}
+	s, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || s < 0 {
+		return 0, false
+	}
+	if s > math.MaxInt32 {
+		// RFC 9111 1.2.2: greater values are taken as 2^31.
+		s = math.MaxInt32
+	}
+	return time.Duration(s) * time.Second, true
+}
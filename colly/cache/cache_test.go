@@ -0,0 +1,213 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newRequest(t *testing.T, cacheControl string) *http.Request {
+	req, err := http.NewRequest("GET", "http://example.com/page#top", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cacheControl != "" {
+		req.Header.Set("Cache-Control", cacheControl)
+	}
+	return req
+}
+
+func TestFresh(t *testing.T) {
+	date := now.Add(-time.Minute).Format(http.TimeFormat)
+	tests := []struct {
+		header       http.Header
+		cacheControl string
+		ttl          time.Duration
+		fresh        bool
+	}{
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=120"}}, "", 0, true},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=30"}}, "", 0, false},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=120"}, "Age": {"90"}}, "", 0, false},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=120, no-cache"}}, "", 0, false},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=120"}}, "no-cache", 0, false},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=120"}}, "max-age=30", 0, false},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=120"}}, "min-fresh=90", 0, false},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=30"}}, "max-stale=60", 0, true},
+		{http.Header{"Date": {date}, "Cache-Control": {"max-age=30, must-revalidate"}}, "max-stale", 0, false},
+		{http.Header{"Date": {date}, "Expires": {now.Add(time.Minute).Format(http.TimeFormat)}}, "", 0, true},
+		{http.Header{"Date": {date}, "Expires": {"0"}}, "", 0, false},
+		// Heuristic freshness: a tenth of the time since the last modification.
+		{http.Header{"Date": {date}, "Last-Modified": {now.Add(-time.Hour).Format(http.TimeFormat)}}, "", 0, true},
+		{http.Header{"Date": {date}, "Last-Modified": {now.Add(-5 * time.Minute).Format(http.TimeFormat)}}, "", 0, false},
+		{http.Header{"Date": {date}}, "", 0, false},
+		{http.Header{"Date": {date}, "Cache-Control": {"no-cache"}}, "", time.Hour, true},
+	}
+	for i, tt := range tests {
+		c := &Cache{TTL: tt.ttl, now: func() time.Time { return now }}
+		e := &Entry{StatusCode: 200, Header: tt.header, RequestTime: now.Add(-time.Minute), ResponseTime: now.Add(-time.Minute)}
+		if fresh := c.Fresh(newRequest(t, tt.cacheControl), e); fresh != tt.fresh {
+			t.Errorf("%d: Fresh() = %v, want %v", i, fresh, tt.fresh)
+		}
+	}
+}
+
+func TestPutVary(t *testing.T) {
+	c := New(NewLRUStore(0))
+	header := http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-language"}}
+	for _, lang := range []string{"en", "fr"} {
+		req := newRequest(t, "")
+		req.Header.Set("Accept-Language", lang)
+		if err := c.Put(req, 200, header, []byte(lang), now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, lang := range []string{"en", "fr", "de"} {
+		req := newRequest(t, "")
+		req.Header.Set("Accept-Language", lang)
+		e, err := c.Lookup(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case lang == "de" && e != nil:
+			t.Errorf("Variant %q found for another Accept-Language", e.Body)
+		case lang != "de" && (e == nil || string(e.Body) != lang):
+			t.Errorf("Variant %q not found: %v", lang, e)
+		}
+	}
+
+	// Lookups don't normalize the headers of requests.
+	req := newRequest(t, "")
+	req.Header.Set("Accept-Language", "en,  fr")
+	if _, err := c.Lookup(req); err != nil {
+		t.Fatal(err)
+	}
+	if v := req.Header.Get("Accept-Language"); v != "en,  fr" {
+		t.Errorf("Request header changed to %q", v)
+	}
+}
+
+func TestPutStorable(t *testing.T) {
+	c := New(NewLRUStore(0))
+	tests := []struct {
+		status int
+		header http.Header
+		stored bool
+	}{
+		{200, http.Header{}, true},
+		{200, http.Header{"Cache-Control": {"no-store"}}, false},
+		{200, http.Header{"Vary": {"*"}}, false},
+		{500, http.Header{}, false},
+		{500, http.Header{"Cache-Control": {"max-age=10"}}, true},
+		{429, http.Header{}, false},
+	}
+	for i, tt := range tests {
+		req := newRequest(t, "")
+		req.URL.Path = fmt.Sprintf("/%d", i)
+		if err := c.Put(req, tt.status, tt.header, nil, now, now); err != nil {
+			t.Fatal(err)
+		}
+		if e, _ := c.Lookup(req); (e != nil) != tt.stored {
+			t.Errorf("%d: stored = %v, want %v", i, e != nil, tt.stored)
+		}
+	}
+}
+
+func TestAddConditions(t *testing.T) {
+	req := newRequest(t, "")
+	e := &Entry{Header: http.Header{"Etag": {`"v1"`}, "Last-Modified": {now.Format(http.TimeFormat)}}}
+	if !AddConditions(req, e) {
+		t.Fatal("Validators not found")
+	}
+	if req.Header.Get("If-None-Match") != `"v1"` || req.Header.Get("If-Modified-Since") != now.Format(http.TimeFormat) {
+		t.Errorf("Wrong conditions: %v", req.Header)
+	}
+}
+
+func TestStores(t *testing.T) {
+	for name, s := range map[string]Store{
+		"disk": NewDiskStore(t.TempDir()),
+		"lru":  NewLRUStore(0),
+	} {
+		if e, err := s.Get("a"); e != nil || err != nil {
+			t.Errorf("%s: Get() of a missing key = %v, %v", name, e, err)
+		}
+		want := &Entry{StatusCode: 200, Header: http.Header{"A": {"b"}}, Body: []byte("body")}
+		if err := s.Set("a", want); err != nil {
+			t.Fatal(err)
+		}
+		if e, err := s.Get("a"); err != nil || e == nil || string(e.Body) != "body" || e.Header.Get("A") != "b" {
+			t.Errorf("%s: Get() = %v, %v", name, e, err)
+		}
+		if err := s.Delete("a"); err != nil {
+			t.Fatal(err)
+		}
+		if e, _ := s.Get("a"); e != nil {
+			t.Errorf("%s: entry not deleted", name)
+		}
+	}
+}
+
+func TestDiskStoreConcurrentSet(t *testing.T) {
+	dir := t.TempDir()
+	s := NewDiskStore(dir)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := bytes.Repeat([]byte{byte('a' + i)}, 1<<16)
+			if err := s.Set("a", &Entry{StatusCode: 200, Body: body}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	e, err := s.Get("a")
+	if err != nil || e == nil {
+		t.Fatalf("Get() after concurrent Set() = %v, %v", e, err)
+	}
+	if len(e.Body) != 1<<16 || !bytes.Equal(e.Body, bytes.Repeat(e.Body[:1], 1<<16)) {
+		t.Errorf("Get() after concurrent Set() = a corrupt body of %d bytes", len(e.Body))
+	}
+	// No temporary file is left behind.
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if len(files) != 1 {
+		t.Errorf("Files in the store: %v", files)
+	}
+}
+
+func TestLRUStoreEviction(t *testing.T) {
+	s := &LRUStore{MaxEntries: 2}
+	s.Set("a", &Entry{})
+	s.Set("b", &Entry{})
+	s.Get("a")
+	s.Set("c", &Entry{})
+	if e, _ := s.Get("b"); e != nil {
+		t.Error("Least recently used entry not evicted")
+	}
+	if e, _ := s.Get("a"); e == nil || s.Len() != 2 {
+		t.Error("Recently used entry evicted")
+	}
+}
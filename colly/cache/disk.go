@@ -0,0 +1,95 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// DiskStore is a Store keeping each entry in a gob encoded file under Dir.
+type DiskStore struct {
+	// Dir is the directory of the cache files
+	Dir string
+}
+
+// NewDiskStore creates a DiskStore keeping entries under dir.
+func NewDiskStore(dir string) *DiskStore {
+	return &DiskStore{Dir: dir}
+}
+
+// Get implements Store.Get() function
+func (s *DiskStore) Get(key string) (*Entry, error) {
+	file, err := os.Open(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	e := &Entry{}
+	if err := gob.NewDecoder(file).Decode(e); err != nil {
+		// Files of older formats are cache misses.
+		return nil, nil
+	}
+	return e, nil
+}
+
+// Set implements Store.Set() function
+func (s *DiskStore) Set(key string, e *Entry) error {
+	filename := s.filename(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		return err
+	}
+	// Writers of the same key use distinct temporary files.
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*~")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(e); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	// Readers never see partially written files.
+	if err := os.Rename(file.Name(), filename); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete implements Store.Delete() function
+func (s *DiskStore) Delete(key string) error {
+	err := os.Remove(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *DiskStore) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(s.Dir, hash[:2], hash)
+}
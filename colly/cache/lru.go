@@ -0,0 +1,110 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRUStore is a Store holding entries in memory. The least recently used
+// entries are evicted once MaxEntries or MaxBytes is exceeded.
+type LRUStore struct {
+	// MaxEntries bounds the number of entries, 0 means no limit
+	MaxEntries int
+	// MaxBytes bounds the approximate size of the entries, 0 means no limit
+	MaxBytes int64
+	lock     sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List
+	size     int64
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// NewLRUStore creates an LRUStore holding at most maxBytes of entries.
+func NewLRUStore(maxBytes int64) *LRUStore {
+	return &LRUStore{MaxBytes: maxBytes}
+}
+
+// Get implements Store.Get() function
+func (s *LRUStore) Get(key string) (*Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*lruItem).entry, nil
+}
+
+// Set implements Store.Set() function
+func (s *LRUStore) Set(key string, e *Entry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.items == nil {
+		s.items = make(map[string]*list.Element)
+		s.order = list.New()
+	}
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	item := &lruItem{key: key, entry: e, size: entrySize(key, e)}
+	s.items[key] = s.order.PushFront(item)
+	s.size += item.size
+	for s.order.Len() > 1 && (s.MaxEntries > 0 && s.order.Len() > s.MaxEntries || s.MaxBytes > 0 && s.size > s.MaxBytes) {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Delete implements Store.Delete() function
+func (s *LRUStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+// Len returns the number of entries held.
+func (s *LRUStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.items)
+}
+
+func (s *LRUStore) remove(el *list.Element) {
+	item := s.order.Remove(el).(*lruItem)
+	delete(s.items, item.key)
+	s.size -= item.size
+}
+
+func entrySize(key string, e *Entry) int64 {
+	size := int64(len(key) + len(e.Body))
+	for k, vs := range e.Header {
+		size += int64(len(k))
+		for _, v := range vs {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
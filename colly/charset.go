@@ -0,0 +1,147 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"bytes"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// prescanSize is the number of bytes of a body searched for an encoding
+// declaration, as in the WHATWG prescan.
+const prescanSize = 1024
+
+var byteOrderMarks = []struct {
+	bom  []byte
+	name string
+}{
+	{[]byte{0xef, 0xbb, 0xbf}, "utf-8"},
+	{[]byte{0xfe, 0xff}, "utf-16be"},
+	{[]byte{0xff, 0xfe}, "utf-16le"},
+}
+
+var xmlEncodingRegexp = regexp.MustCompile(`^<\?xml\s[^>]*?\bencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+// chardetCharsets maps the charsets reported by chardet which aren't
+// encoding labels.
+var chardetCharsets = map[string]string{
+	"GB-18030": "gb18030",
+}
+
+// sniffCharset returns the name of the encoding of body, following the
+// WHATWG encoding sniffing algorithm: a byte order mark, whose length is
+// returned as well, then the charset of contentType, then an encoding
+// declaration in the first 1024 bytes, either in the XML declaration or,
+// for HTML, in a meta element. It returns an empty name if none is found.
+func sniffCharset(body []byte, contentType string) (string, int) {
+	for _, m := range byteOrderMarks {
+		if bytes.HasPrefix(body, m.bom) {
+			return m.name, len(m.bom)
+		}
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if name := lookupCharset(params["charset"]); name != "" {
+			return name, 0
+		}
+	}
+	if len(body) > prescanSize {
+		body = body[:prescanSize]
+	}
+	if m := xmlEncodingRegexp.FindSubmatch(body); m != nil {
+		if name := lookupCharset(string(m[1])); name != "" {
+			return name, 0
+		}
+	}
+	if contentType == "" || strings.Contains(contentType, "html") {
+		return metaCharset(body), 0
+	}
+	return "", 0
+}
+
+// metaCharset returns the encoding declared by the first meta element of
+// head with a known charset attribute or Content-Type pragma.
+func metaCharset(head []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(head))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttr := z.TagName()
+			if string(tag) != "meta" || !hasAttr {
+				continue
+			}
+			var cs, content string
+			var pragma bool
+			for more := true; more; {
+				var key, val []byte
+				key, val, more = z.TagAttr()
+				switch string(key) {
+				case "charset":
+					cs = string(val)
+				case "http-equiv":
+					pragma = strings.EqualFold(string(val), "content-type")
+				case "content":
+					content = string(val)
+				}
+			}
+			if cs == "" && pragma {
+				if _, params, err := mime.ParseMediaType(content); err == nil {
+					cs = params["charset"]
+				}
+			}
+			name := lookupCharset(cs)
+			switch {
+			case name == "":
+				continue
+			case strings.HasPrefix(name, "utf-16"):
+				// A document really encoded in UTF-16 would start
+				// with a byte order mark, unlike this ASCII tag.
+				return "utf-8"
+			case name == "x-user-defined":
+				return "windows-1252"
+			}
+			return name
+		}
+	}
+}
+
+// utf8XMLDeclaration rewrites the encoding of the XML declaration of a body
+// converted to UTF-8, so that XML parsers don't decode it again.
+func utf8XMLDeclaration(body []byte) []byte {
+	m := xmlEncodingRegexp.FindSubmatchIndex(body)
+	if m == nil {
+		return body
+	}
+	return append(append(body[:m[2]:m[2]], "UTF-8"...), body[m[3]:]...)
+}
+
+// lookupCharset returns the canonical name of the encoding label, or an
+// empty string if it's unknown.
+func lookupCharset(label string) string {
+	if cs, ok := chardetCharsets[label]; ok {
+		label = cs
+	}
+	if label == "" {
+		return ""
+	}
+	_, name := charset.Lookup(label)
+	return name
+}
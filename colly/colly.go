@@ -22,7 +22,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"log"
@@ -42,8 +41,8 @@ import (
 	"github.com/antchfx/htmlquery"
 	"github.com/antchfx/xmlquery"
 	"github.com/kennygrant/sanitize"
-	"github.com/temoto/robotstxt"
 	"google.golang.org/appengine/urlfetch"
+	"xrmcp/colly/cache"
 	"xrmcp/colly/debug"
 	"xrmcp/colly/storage"
 )
@@ -55,13 +54,17 @@ type CollectorOption func(*Collector)
 type Collector struct {
 	// UserAgent is the User-Agent string used by HTTP requests
 	UserAgent string
+	// HeaderProfile sets the default headers of requests, e.g. the
+	// ones of a browser. Headers set on a request always win.
+	HeaderProfile *HeaderProfile
 	// MaxDepth limits the recursion depth of visited URLs.
 	// Set it to 0 for infinite recursion (default).
 	MaxDepth int
-	// AllowedDomains is a domain whitelist.
+	// AllowedDomains is a domain whitelist of MatchDomain patterns.
 	// Leave it blank to allow any domains to be visited
 	AllowedDomains []string
-	// DisallowedDomains is a domain blacklist.
+	// DisallowedDomains is a domain blacklist of MatchDomain patterns.
+	// It takes precedence over AllowedDomains.
 	DisallowedDomains []string
 	// DisallowedURLFilters is a list of regular expressions which restricts
 	// visiting URLs. If any of the rules matches to a URL the
@@ -83,8 +86,9 @@ type Collector struct {
 	// 0 means unlimited.
 	// The default value for MaxBodySize is 10MB (10 * 1024 * 1024 bytes).
 	MaxBodySize int
-	// CacheDir specifies a location where GET requests are cached as files.
-	// When it's not defined, caching is disabled.
+	// CacheDir specifies a location where GET requests are cached as files,
+	// following the caching rules of HTTP. It's ignored when a cache is set
+	// with SetCache. When neither is defined, caching is disabled.
 	CacheDir string
 	// IgnoreRobotsTxt allows the Collector to ignore any restrictions set by
 	// the target host's robots.txt file.  See http://www.robotstxt.org/ for more
@@ -107,22 +111,33 @@ type Collector struct {
 	redirectHandler func(req *http.Request, via []*http.Request) error
 	// CheckHead performs a HEAD request before every GET to pre-validate the response
 	CheckHead bool
+	// Fingerprinter computes the fingerprints of requests recorded as
+	// visited, DefaultFingerprinter if nil
+	Fingerprinter Fingerprinter
+	// RetryPolicy, if set, retries requests failing with transient errors
+	// before they are reported to OnError callbacks.
+	RetryPolicy *RetryPolicy
 	// TraceHTTP enables capturing and reporting request performance for crawler tuning.
 	// When set to true, the Response.Trace will be filled in with an HTTPTrace object.
 	TraceHTTP                bool
 	store                    storage.Storage
 	debugger                 debug.Debugger
-	robotsMap                map[string]*robotstxt.RobotsData
+	robots                   *RobotsManager
 	htmlCallbacks            []*htmlCallbackContainer
 	xmlCallbacks             []*xmlCallbackContainer
 	requestCallbacks         []RequestCallback
 	responseCallbacks        []ResponseCallback
 	responseHeadersCallbacks []ResponseHeadersCallback
+	responseStreamCallbacks  []ResponseStreamCallback
 	errorCallbacks           []ErrorCallback
 	scrapedCallbacks         []ScrapedCallback
 	requestCount             uint32
 	responseCount            uint32
 	backend                  *httpBackend
+	fetcher                  Fetcher
+	httpCache                *cache.Cache
+	diskCache                *cache.Cache
+	diskCacheDir             string
 	wg                       *sync.WaitGroup
 	lock                     *sync.RWMutex
 }
@@ -176,8 +191,9 @@ type key int
 const ProxyURLKey key = iota
 
 var (
-	// ErrForbiddenDomain is the error thrown if visiting
-	// a domain which is not allowed in AllowedDomains
+	// ErrForbiddenDomain is the error thrown if visiting or being
+	// redirected to a domain which is not allowed in AllowedDomains
+	// or is disallowed in DisallowedDomains
 	ErrForbiddenDomain = errors.New("Forbidden domain")
 	// ErrMissingURL is the error type for missing URL errors
 	ErrMissingURL = errors.New("Missing URL")
@@ -225,6 +241,11 @@ var envMap = map[string]func(*Collector, string){
 	"IGNORE_ROBOTSTXT": func(c *Collector, val string) {
 		c.IgnoreRobotsTxt = isYesString(val)
 	},
+	"HEADER_PROFILE": func(c *Collector, val string) {
+		if p, ok := HeaderProfiles[strings.ToLower(val)]; ok {
+			c.HeaderProfile = p
+		}
+	},
 	"FOLLOW_REDIRECTS": func(c *Collector, val string) {
 		if !isYesString(val) {
 			c.redirectHandler = func(req *http.Request, via []*http.Request) error {
@@ -408,7 +429,7 @@ func (c *Collector) Init() {
 	c.backend.Client.CheckRedirect = c.checkRedirectFunc()
 	c.wg = &sync.WaitGroup{}
 	c.lock = &sync.RWMutex{}
-	c.robotsMap = make(map[string]*robotstxt.RobotsData)
+	c.robots = NewRobotsManager()
 	c.IgnoreRobotsTxt = true
 	c.ID = atomic.AddUint32(&collectorCounter, 1)
 	c.TraceHTTP = false
@@ -439,40 +460,69 @@ func (c *Collector) Appengine(ctx context.Context) {
 // request to the URL specified in parameter.
 // Visit also calls the previously provided callbacks
 func (c *Collector) Visit(URL string) error {
+	return c.VisitContext(context.Background(), URL)
+}
+
+// VisitContext is like Visit, but the request and every request made from
+// its callbacks through Request.Visit, Request.Post and alike are bound
+// to ctx. Once ctx is done, requests bound to it no longer start and
+// those in flight are aborted, including while waiting for a LimitRule.
+func (c *Collector) VisitContext(ctx context.Context, URL string) error {
 	if c.CheckHead {
-		if check := c.scrape(URL, "HEAD", 1, nil, nil, nil, true); check != nil {
+		if check := c.scrape(ctx, URL, "HEAD", 1, nil, nil, nil, true); check != nil {
 			return check
 		}
 	}
-	return c.scrape(URL, "GET", 1, nil, nil, nil, true)
+	return c.scrape(ctx, URL, "GET", 1, nil, nil, nil, true)
 }
 
-// HasVisited checks if the provided URL has been visited
+// HasVisited checks if the provided URL has been visited. It matches GET
+// requests sent without headers, like the ones of Visit: use HasRequested
+// for requests sent with headers distinguishing them.
 func (c *Collector) HasVisited(URL string) (bool, error) {
-	return c.checkHasVisited(URL, nil)
+	return c.checkHasVisited("GET", URL, nil, nil)
 }
 
 // HasPosted checks if the provided URL and requestData has been visited
 // This method is useful more likely to prevent re-visit same URL and POST body
+// Like HasVisited, it matches requests sent without headers.
 func (c *Collector) HasPosted(URL string, requestData map[string]string) (bool, error) {
-	return c.checkHasVisited(URL, requestData)
+	if requestData == nil {
+		return c.HasVisited(URL)
+	}
+	body, _, _ := peekBody(createFormReader(requestData))
+	return c.checkHasVisited("POST", URL, body, nil)
+}
+
+// HasRequested checks if a request made by Request with the same arguments
+// has been visited, including the headers used by the Fingerprinter.
+// In-memory readers of requestData aren't consumed.
+func (c *Collector) HasRequested(method, URL string, requestData io.Reader, hdr http.Header) (bool, error) {
+	var body []byte
+	if method != "GET" && requestData != nil {
+		var err error
+		if body, _, err = peekBody(requestData); err != nil {
+			return false, err
+		}
+	}
+	return c.checkHasVisited(method, URL, body, hdr)
 }
 
 // Head starts a collector job by creating a HEAD request.
 func (c *Collector) Head(URL string) error {
-	return c.scrape(URL, "HEAD", 1, nil, nil, nil, false)
+	return c.scrape(context.Background(), URL, "HEAD", 1, nil, nil, nil, false)
 }
 
 // Post starts a collector job by creating a POST request.
 // Post also calls the previously provided callbacks
 func (c *Collector) Post(URL string, requestData map[string]string) error {
-	return c.scrape(URL, "POST", 1, createFormReader(requestData), nil, nil, true)
+	return c.scrape(context.Background(), URL, "POST", 1, createFormReader(requestData), nil, nil, true)
 }
 
 // PostRaw starts a collector job by creating a POST request with raw binary data.
 // Post also calls the previously provided callbacks
 func (c *Collector) PostRaw(URL string, requestData []byte) error {
-	return c.scrape(URL, "POST", 1, bytes.NewReader(requestData), nil, nil, true)
+	return c.scrape(context.Background(), URL, "POST", 1, bytes.NewReader(requestData), nil, nil, true)
 }
 
 // PostMultipart starts a collector job by creating a Multipart POST request
@@ -481,8 +531,7 @@ func (c *Collector) PostMultipart(URL string, requestData map[string][]byte) err
 	boundary := randomBoundary()
 	hdr := http.Header{}
 	hdr.Set("Content-Type", "multipart/form-data; boundary="+boundary)
-	hdr.Set("User-Agent", c.UserAgent)
-	return c.scrape(URL, "POST", 1, createMultipartReader(boundary, requestData), nil, hdr, true)
+	return c.scrape(context.Background(), URL, "POST", 1, createMultipartReader(boundary, requestData), nil, hdr, true)
 }
 
 // Request starts a collector job by creating a custom HTTP request
@@ -497,7 +546,13 @@ func (c *Collector) PostMultipart(URL string, requestData map[string][]byte) err
 //   - "PATCH"
 //   - "OPTIONS"
 func (c *Collector) Request(method, URL string, requestData io.Reader, ctx *Context, hdr http.Header) error {
-	return c.scrape(URL, method, 1, requestData, ctx, hdr, true)
+	return c.RequestContext(context.Background(), method, URL, requestData, ctx, hdr)
+}
+
+// RequestContext is like Request, with the request bound to goCtx as
+// described in VisitContext.
+func (c *Collector) RequestContext(goCtx context.Context, method, URL string, requestData io.Reader, ctx *Context, hdr http.Header) error {
+	return c.scrape(goCtx, URL, method, 1, requestData, ctx, hdr, true)
 }
 
 // SetDebugger attaches a debugger to the collector
@@ -536,24 +591,25 @@ func (c *Collector) UnmarshalRequest(r []byte) (*Request, error) {
 	}, nil
 }
 
-func (c *Collector) scrape(u, method string, depth int, requestData io.Reader, ctx *Context, hdr http.Header, checkRevisit bool) error {
+func (c *Collector) scrape(goCtx context.Context, u, method string, depth int, requestData io.Reader, ctx *Context, hdr http.Header, checkRevisit bool) error {
+	if err := goCtx.Err(); err != nil {
+		return err
+	}
 	parsedURL, err := url.Parse(u)
 	if err != nil {
 		return err
 	}
-	if err := c.requestCheck(u, parsedURL, method, requestData, depth, checkRevisit); err != nil {
+	requestData, err = c.requestCheck(goCtx, u, parsedURL, method, requestData, hdr, depth, checkRevisit)
+	if err != nil {
 		return err
 	}
 
 	if hdr == nil {
-		hdr = http.Header{"User-Agent": []string{c.UserAgent}}
-	}
-	//
-	hdr.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	hdr.Set("Accept-Language", "en-US,en;q=0.9")
-	hdr.Set("Upgrade-Insecure-Requests", "1")
-	hdr.Set("Connection", "keep-alive")
-	//
+		hdr = http.Header{}
+	}
+	if _, ok := hdr["User-Agent"]; !ok {
+		hdr.Set("User-Agent", c.userAgent())
+	}
 	rc, ok := requestData.(io.ReadCloser)
 	if !ok && requestData != nil {
 		rc = ioutil.NopCloser(requestData)
@@ -574,6 +630,7 @@ func (c *Collector) scrape(u, method string, depth int, requestData io.Reader, c
 		Body:   rc,
 		Host:   host,
 	}
+	req = req.WithContext(goCtx)
 	setRequestBody(req, requestData)
 	u = parsedURL.String()
 	c.wg.Add(1)
@@ -618,6 +675,9 @@ func setRequestBody(req *http.Request, body io.Reader) {
 
 func (c *Collector) fetch(u, method string, depth int, requestData io.Reader, ctx *Context, hdr http.Header, req *http.Request) error {
 	defer c.wg.Done()
+	if err := req.Context().Err(); err != nil {
+		return err
+	}
 	if ctx == nil {
 		ctx = NewContext()
 	}
@@ -631,6 +691,9 @@ func (c *Collector) fetch(u, method string, depth int, requestData io.Reader, ct
 		collector: c,
 		ID:        atomic.AddUint32(&c.requestCount, 1),
 	}
+	streamCtx, goCtx := c.streamRequest(req.Context(), request)
+	request.goCtx = goCtx
+	req = req.WithContext(streamCtx)
 
 	c.handleOnRequest(request)
 
@@ -638,6 +701,10 @@ func (c *Collector) fetch(u, method string, depth int, requestData io.Reader, ct
 		return nil
 	}
 
+	if c.HeaderProfile != nil {
+		c.HeaderProfile.apply(req.Header)
+	}
+
 	if method == "POST" && req.Header.Get("Content-Type") == "" {
 		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	}
@@ -657,7 +724,10 @@ func (c *Collector) fetch(u, method string, depth int, requestData io.Reader, ct
 	}
 
 	origURL := req.URL
-	response, err := c.backend.Cache(req, c.MaxBodySize, checkHeadersFunc, c.CacheDir)
+	req, response, err := c.do(request, req, checkHeadersFunc)
+	if err == nil {
+		err = streamBody(req, response)
+	}
 	if proxyURL, ok := req.Context().Value(ProxyURLKey).(string); ok {
 		request.ProxyURL = proxyURL
 	}
@@ -695,97 +765,64 @@ func (c *Collector) fetch(u, method string, depth int, requestData io.Reader, ct
 	return err
 }
 
-func (c *Collector) requestCheck(u string, parsedURL *url.URL, method string, requestData io.Reader, depth int, checkRevisit bool) error {
+// requestCheck returns an error if the request mustn't be sent, along with
+// the body to send in place of requestData, which it may have read.
+func (c *Collector) requestCheck(goCtx context.Context, u string, parsedURL *url.URL, method string, requestData io.Reader, hdr http.Header, depth int, checkRevisit bool) (io.Reader, error) {
 	if u == "" {
-		return ErrMissingURL
+		return requestData, ErrMissingURL
 	}
 	if c.MaxDepth > 0 && c.MaxDepth < depth {
-		return ErrMaxDepth
+		return requestData, ErrMaxDepth
 	}
 	if len(c.DisallowedURLFilters) > 0 {
 		if isMatchingFilter(c.DisallowedURLFilters, []byte(u)) {
-			return ErrForbiddenURL
+			return requestData, ErrForbiddenURL
 		}
 	}
 	if len(c.URLFilters) > 0 {
 		if !isMatchingFilter(c.URLFilters, []byte(u)) {
-			return ErrNoURLFiltersMatch
+			return requestData, ErrNoURLFiltersMatch
 		}
 	}
 	if !c.isDomainAllowed(parsedURL.Hostname()) {
-		return ErrForbiddenDomain
+		return requestData, ErrForbiddenDomain
 	}
 	if method != "HEAD" && !c.IgnoreRobotsTxt {
-		if err := c.checkRobots(parsedURL); err != nil {
-			return err
+		if err := c.checkRobots(goCtx, parsedURL); err != nil {
+			return requestData, err
 		}
 	}
 	if checkRevisit && !c.AllowURLRevisit {
-		h := fnv.New64a()
-		h.Write([]byte(u))
-
-		var uHash uint64
-		if method == "GET" {
-			uHash = h.Sum64()
-		} else if requestData != nil {
-			h.Write(streamToByte(requestData))
-			uHash = h.Sum64()
-		} else {
-			return nil
+		var body []byte
+		if method != "GET" {
+			if requestData == nil {
+				return requestData, nil
+			}
+			var err error
+			if body, requestData, err = peekBody(requestData); err != nil {
+				return requestData, err
+			}
 		}
-
+		uHash := c.fingerprint(method, u, parsedURL, hdr, body)
 		visited, err := c.store.IsVisited(uHash)
 		if err != nil {
-			return err
+			return requestData, err
 		}
 		if visited {
-			return ErrAlreadyVisited
+			return requestData, ErrAlreadyVisited
 		}
-		return c.store.Visited(uHash)
+		return requestData, c.store.Visited(uHash)
 	}
-	return nil
+	return requestData, nil
 }
 
-func (c *Collector) isDomainAllowed(domain string) bool {
-	for _, d2 := range c.DisallowedDomains {
-		if d2 == domain {
-			return false
-		}
-	}
-	if c.AllowedDomains == nil || len(c.AllowedDomains) == 0 {
-		return true
-	}
-	for _, d2 := range c.AllowedDomains {
-		if d2 == domain {
-			return true
-		}
-	}
-	return false
-}
-
-func (c *Collector) checkRobots(u *url.URL) error {
-	c.lock.RLock()
-	robot, ok := c.robotsMap[u.Host]
-	c.lock.RUnlock()
-
-	if !ok {
-		// no robots file cached
-		resp, err := c.backend.Client.Get(u.Scheme + "://" + u.Host + "/robots.txt")
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		robot, err = robotstxt.FromResponse(resp)
-		if err != nil {
-			return err
-		}
-		c.lock.Lock()
-		c.robotsMap[u.Host] = robot
-		c.lock.Unlock()
+func (c *Collector) checkRobots(goCtx context.Context, u *url.URL) error {
+	robot, err := c.robots.get(goCtx, c, u)
+	if err != nil {
+		return err
 	}
 
-	uaGroup := robot.FindGroup(c.UserAgent)
+	uaGroup := robot.FindGroup(c.userAgent())
 	if uaGroup == nil {
 		return nil
 	}
@@ -819,6 +856,22 @@ func (c *Collector) Wait() {
 	c.wg.Wait()
 }
 
+// WaitContext is like Wait, but returns the error of ctx if it is done
+// before the collector jobs are finished.
+func (c *Collector) WaitContext(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // OnRequest registers a function. Function will be executed on every
 // request made by the Collector
 func (c *Collector) OnRequest(f RequestCallback) {
@@ -977,6 +1030,53 @@ func (c *Collector) SetStorage(s storage.Storage) error {
 	return nil
 }
 
+// SetCache sets the HTTP cache of GET responses, which takes precedence
+// over CacheDir.
+func (c *Collector) SetCache(httpCache *cache.Cache) {
+	c.httpCache = httpCache
+}
+
+// SetRobotsManager sets the manager of the robots.txt files checked
+// unless IgnoreRobotsTxt is set.
+func (c *Collector) SetRobotsManager(m *RobotsManager) {
+	c.robots = m
+}
+
+// RobotsManager returns the manager of the robots.txt files of the
+// Collector, which is shared with its clones.
+func (c *Collector) RobotsManager() *RobotsManager {
+	return c.robots
+}
+
+// Sitemaps returns the sitemap URLs listed in the robots.txt of the host
+// of URL, fetching it if needed.
+func (c *Collector) Sitemaps(URL string) ([]string, error) {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return nil, err
+	}
+	robot, err := c.robots.get(context.Background(), c, u)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), robot.Sitemaps...), nil
+}
+
+// responseCache returns the HTTP cache of the Collector, if any. The cache
+// of CacheDir is created once, and again only if CacheDir changes.
+func (c *Collector) responseCache() *cache.Cache {
+	if c.httpCache != nil || c.CacheDir == "" {
+		return c.httpCache
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.diskCache == nil || c.diskCacheDir != c.CacheDir {
+		c.diskCache = cache.New(cache.NewDiskStore(c.CacheDir))
+		c.diskCacheDir = c.CacheDir
+	}
+	return c.diskCache
+}
+
 // SetProxy sets a proxy for the collector. This method overrides the previously
 // used http.Transport if the type of the transport is not http.RoundTripper.
 // The proxy type is determined by the URL scheme. "http"
@@ -1144,10 +1244,10 @@ func (c *Collector) handleOnXML(resp *Response) error {
 }
 
 func (c *Collector) handleOnError(response *Response, err error, request *Request, ctx *Context) error {
-	if err == nil && (c.ParseHTTPErrorResponse || response.StatusCode < 203) {
+	if err == nil && (c.ParseHTTPErrorResponse || isSuccess(response.StatusCode, request)) {
 		return nil
 	}
-	if err == nil && response.StatusCode >= 203 {
+	if err == nil {
 		err = errors.New(http.StatusText(response.StatusCode))
 	}
 	if response == nil {
@@ -1245,9 +1345,14 @@ func (c *Collector) Clone() *Collector {
 		CheckHead:              c.CheckHead,
 		ParseHTTPErrorResponse: c.ParseHTTPErrorResponse,
 		UserAgent:              c.UserAgent,
+		HeaderProfile:          c.HeaderProfile,
+		RetryPolicy:            c.RetryPolicy,
+		Fingerprinter:          c.Fingerprinter,
 		TraceHTTP:              c.TraceHTTP,
 		store:                  c.store,
 		backend:                c.backend,
+		fetcher:                c.fetcher,
+		httpCache:              c.httpCache,
 		debugger:               c.debugger,
 		Async:                  c.Async,
 		redirectHandler:        c.redirectHandler,
@@ -1258,7 +1363,7 @@ func (c *Collector) Clone() *Collector {
 		lock:                   c.lock,
 		requestCallbacks:       make([]RequestCallback, 0, 8),
 		responseCallbacks:      make([]ResponseCallback, 0, 8),
-		robotsMap:              c.robotsMap,
+		robots:                 c.robots,
 		wg:                     &sync.WaitGroup{},
 	}
 }
@@ -1266,7 +1371,7 @@ func (c *Collector) Clone() *Collector {
 func (c *Collector) checkRedirectFunc() func(req *http.Request, via []*http.Request) error {
 	return func(req *http.Request, via []*http.Request) error {
 		if !c.isDomainAllowed(req.URL.Hostname()) {
-			return fmt.Errorf("Not following redirect to %s because its not in AllowedDomains", req.URL.Host)
+			return fmt.Errorf("Not following redirect to %s: %w", req.URL.Host, ErrForbiddenDomain)
 		}
 
 		if c.redirectHandler != nil {
@@ -1303,15 +1408,12 @@ func (c *Collector) parseSettingsFromEnv() {
 	}
 }
 
-func (c *Collector) checkHasVisited(URL string, requestData map[string]string) (bool, error) {
-	h := fnv.New64a()
-	h.Write([]byte(URL))
-
-	if requestData != nil {
-		h.Write(streamToByte(createFormReader(requestData)))
+func (c *Collector) checkHasVisited(method, URL string, body []byte, hdr http.Header) (bool, error) {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return false, err
 	}
-
-	return c.store.IsVisited(h.Sum64())
+	return c.store.IsVisited(c.fingerprint(method, URL, u, hdr, body))
 }
 
 // SanitizeFileName replaces dangerous characters in a string
@@ -1422,16 +1524,3 @@ func isMatchingFilter(fs []*regexp.Regexp, d []byte) bool {
 	}
 	return false
 }
-
-func streamToByte(r io.Reader) []byte {
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(r)
-
-	if strReader, k := r.(*strings.Reader); k {
-		strReader.Seek(0, 0)
-	} else if bReader, kb := r.(*bytes.Reader); kb {
-		bReader.Seek(0, 0)
-	}
-
-	return buf.Bytes()
-}
@@ -17,18 +17,35 @@ package colly
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 
+	"xrmcp/colly/cache"
 	"xrmcp/colly/debug"
 )
 
@@ -430,6 +447,47 @@ func TestCollectorVisitWithDisallowedDomains(t *testing.T) {
 	}
 }
 
+func TestMatchDomain(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		match         bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "www.example.com", false},
+		{"example.com:8080", "example.com", true},
+		{"[::1]:80", "::1", true},
+		{".example.com", "example.com", true},
+		{".example.com", "a.b.example.com", true},
+		{".example.com", "badexample.com", false},
+		{"*.gov.cn", "www.beijing.gov.cn", true},
+		{"*.gov.cn", "gov.cn", false},
+		{"shop-?.example.com", "shop-1.example.com", true},
+		{"site:www.example.co.uk", "example.co.uk", true},
+		{"site:www.example.co.uk", "a.shop.example.co.uk", true},
+		{"site:www.example.co.uk", "other.co.uk", false},
+		{"site:localhost", "localhost", true},
+		{"", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := MatchDomain(tt.pattern, tt.host); got != tt.match {
+			t.Errorf("MatchDomain(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.match)
+		}
+	}
+}
+
+func TestRedirectToForbiddenDomain(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://www.example.com/", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c := NewCollector(AllowedDomains("127.0.0.1", ".example.org"), DisallowedDomains("*.example.com"))
+	if err := c.Visit(ts.URL); !errors.Is(err, ErrForbiddenDomain) {
+		t.Errorf("Redirect should fail with ErrForbiddenDomain, but got %v", err)
+	}
+}
+
 func TestCollectorVisitResponseHeaders(t *testing.T) {
 	ts := newTestServer()
 	defer ts.Close()
@@ -740,6 +798,106 @@ func TestCollectorPostRawRevisit(t *testing.T) {
 	}
 }
 
+func TestCanonicalizer(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"HTTP://Example.COM:80", "http://example.com/"},
+		{"https://example.com:443/a?b=2&a=1#top", "https://example.com/a?a=1&b=2"},
+		{"https://example.com:8443/a?", "https://example.com:8443/a"},
+		{"http://example.com/?utm_source=x&id=1&utm_medium=y&gclid=z", "http://example.com/?id=1"},
+		{"http://example.com/?b=2&a=1&b=1", "http://example.com/?a=1&b=2&b=1"},
+		{"http://example.com/?q=a%20b&%61=1", "http://example.com/?%61=1&q=a%20b"},
+	}
+	for _, tt := range tests {
+		out, err := DefaultCanonicalizer.CanonicalizeString(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tt.out {
+			t.Errorf("Canonicalize(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+
+	c := &Canonicalizer{KeepFragment: true, KeepQueryOrder: true}
+	if out, _ := c.CanonicalizeString("http://example.com/?b=2&a=1#top"); out != "http://example.com/?b=2&a=1#top" {
+		t.Errorf("Options ignored: %q", out)
+	}
+}
+
+func TestFingerprinter(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+	}))
+	defer ts.Close()
+
+	c := NewCollector(Fingerprints(&RequestFingerprinter{
+		Canonicalizer: DefaultCanonicalizer,
+		Headers:       []string{"Accept-Language"},
+	}))
+	c.Visit(ts.URL + "/?a=1&b=2")
+	if err := c.Visit(ts.URL + "/?b=2&a=1&utm_source=x#top"); err != ErrAlreadyVisited {
+		t.Errorf("Equivalent URL visited again: %v", err)
+	}
+	if visited, _ := c.HasVisited(ts.URL + "/?b=2&a=1"); !visited {
+		t.Error("HasVisited() doesn't use the Fingerprinter")
+	}
+	hdr := http.Header{"Accept-Language": {"fr"}}
+	if visited, _ := c.HasRequested("GET", ts.URL+"/?a=1&b=2", nil, hdr); visited {
+		t.Error("HasRequested() ignores the selected headers")
+	}
+	if err := c.Request("GET", ts.URL+"/?a=1&b=2", nil, nil, hdr); err != nil {
+		t.Errorf("Request with another selected header not sent: %v", err)
+	}
+	if visited, _ := c.HasRequested("GET", ts.URL+"/?b=2&a=1", nil, hdr); !visited {
+		t.Error("HasRequested() doesn't match the request with headers")
+	}
+
+	c.PostMultipart(ts.URL+"/form", map[string][]byte{"name": []byte("value")})
+	if len(bodies) != 3 || !strings.Contains(bodies[2], "value") {
+		t.Fatalf("Body consumed before being sent: %q", bodies)
+	}
+	if err := c.Request("PUT", ts.URL+"/form", ioutil.NopCloser(strings.NewReader("streamed")), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Request("PUT", ts.URL+"/form", ioutil.NopCloser(strings.NewReader("streamed")), nil, nil); err != ErrAlreadyVisited {
+		t.Errorf("Same PUT sent again: %v", err)
+	}
+	if len(bodies) != 4 || bodies[3] != "PUT /form streamed" {
+		t.Errorf("Streamed body not sent: %q", bodies)
+	}
+	body := strings.NewReader("streamed")
+	if visited, _ := c.HasRequested("PUT", ts.URL+"/form", body, nil); !visited || body.Len() != 8 {
+		t.Errorf("HasRequested() of a PUT = %v, with %d bytes of the body left", visited, body.Len())
+	}
+
+	// Stores written by earlier versions are read with LegacyFingerprinter.
+	h := fnv.New64a()
+	h.Write([]byte(ts.URL + "/old?b=2&a=1"))
+	c = NewCollector(Fingerprints(LegacyFingerprinter))
+	c.store.Visited(h.Sum64())
+	if visited, _ := c.HasVisited(ts.URL + "/old?b=2&a=1"); !visited {
+		t.Error("LegacyFingerprinter doesn't match the fingerprints of earlier versions")
+	}
+	// URLs are hashed as given, even those that parsing re-encodes.
+	for _, u := range []string{ts.URL + "/caf\u00e9", ts.URL + "/a b"} {
+		if parsed, _ := url.Parse(u); parsed.String() == u {
+			t.Fatalf("%q isn't re-encoded by url.Parse", u)
+		}
+		h := fnv.New64a()
+		h.Write([]byte(u))
+		c.store.Visited(h.Sum64())
+		if visited, _ := c.HasVisited(u); !visited {
+			t.Errorf("LegacyFingerprinter doesn't match the fingerprint of %q of earlier versions", u)
+		}
+		if err := c.Visit(u); err != ErrAlreadyVisited {
+			t.Errorf("Visit(%q) recorded by an earlier version = %v", u, err)
+		}
+	}
+}
+
 func TestRedirect(t *testing.T) {
 	ts := newTestServer()
 	defer ts.Close()
@@ -908,6 +1066,103 @@ func TestConnectionErrorOnRobotsTxtResultsInError(t *testing.T) {
 	if err == nil {
 		t.Fatal("Error expected")
 	}
+	if !errors.Is(err, ErrRobotsTxtUnreachable) {
+		t.Fatalf("Wrong error: %v", err)
+	}
+}
+
+func TestRobotsStatusAndExpiry(t *testing.T) {
+	var lock sync.Mutex
+	status, fetches := http.StatusServiceUnavailable, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			fetches++
+			w.WriteHeader(status)
+			w.Write([]byte("User-agent: *\nDisallow: /\n"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	setStatus := func(s int) {
+		lock.Lock()
+		status = s
+		lock.Unlock()
+	}
+
+	now := time.Now()
+	m := &RobotsManager{TTL: time.Hour, ErrorTTL: time.Minute, now: func() time.Time { return now }}
+	c := NewCollector(AllowURLRevisit())
+	c.IgnoreRobotsTxt = false
+	c.SetRobotsManager(m)
+
+	if err := c.Visit(ts.URL + "/a"); !errors.Is(err, ErrRobotsTxtUnreachable) {
+		t.Fatalf("5xx robots.txt should disallow the host, got %v", err)
+	}
+	setStatus(http.StatusNotFound)
+	if err := c.Visit(ts.URL + "/a"); !errors.Is(err, ErrRobotsTxtUnreachable) {
+		t.Fatalf("Unreachable robots.txt should be cached, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err := c.Visit(ts.URL + "/a"); err != nil {
+		t.Fatalf("4xx robots.txt should allow everything, got %v", err)
+	}
+	setStatus(http.StatusOK)
+	if err := c.Visit(ts.URL + "/a"); err != nil {
+		t.Fatalf("Cached robots.txt should be used, got %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if err := c.Visit(ts.URL + "/a"); err != ErrRobotsTxtBlocked {
+		t.Fatalf("Expired robots.txt should be fetched again, got %v", err)
+	}
+	setStatus(http.StatusInternalServerError)
+	now = now.Add(2 * time.Hour)
+	if err := c.Visit(ts.URL + "/a"); err != ErrRobotsTxtBlocked {
+		t.Fatalf("Last robots.txt should be used while unreachable, got %v", err)
+	}
+	if fetches != 4 {
+		t.Errorf("robots.txt fetched %d times, want 4", fetches)
+	}
+}
+
+func TestRobotsCrawlDelayAndSitemaps(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nCrawl-delay: 0.05\nDisallow: /private\n\nSitemap: http://example.com/sitemap.xml\n"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	c := NewCollector(AllowURLRevisit())
+	c.IgnoreRobotsTxt = false
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := c.Visit(ts.URL + "/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Crawl-delay not applied, 3 requests took %v", elapsed)
+	}
+	u, _ := url.Parse(ts.URL)
+	if r := c.backend.GetMatchingRule(u.Host); r == nil || r.Delay != 50*time.Millisecond {
+		t.Errorf("Wrong LimitRule of Crawl-delay: %+v", r)
+	}
+
+	sitemaps, err := c.Sitemaps(ts.URL + "/any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sitemaps, []string{"http://example.com/sitemap.xml"}) {
+		t.Errorf("Wrong sitemaps: %q", sitemaps)
+	}
+	if got := c.RobotsManager().Sitemaps()[u.Host]; !reflect.DeepEqual(got, sitemaps) {
+		t.Errorf("Wrong sitemaps of the manager: %q", got)
+	}
 }
 
 func TestEnvSettings(t *testing.T) {
@@ -934,6 +1189,38 @@ func TestEnvSettings(t *testing.T) {
 	}
 }
 
+func TestHeaderProfile(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer ts.Close()
+
+	c := NewCollector(DefaultHeaders(ChromeHeaders), AllowURLRevisit())
+	c.Visit(ts.URL)
+	if ua := got.Get("User-Agent"); ua != ChromeHeaders.UserAgent {
+		t.Errorf("Wrong User-Agent %q", ua)
+	}
+	if got.Get("Sec-Ch-Ua") == "" || got.Get("Accept") != ChromeHeaders.Headers.Get("Accept") {
+		t.Errorf("Profile headers missing: %v", got)
+	}
+
+	hdr := http.Header{"Accept": []string{"application/json"}}
+	c.Request("GET", ts.URL, nil, nil, hdr)
+	if a := got.Get("Accept"); a != "application/json" {
+		t.Errorf("Caller Accept header overridden with %q", a)
+	}
+
+	c.UserAgent = "test"
+	c.Visit(ts.URL)
+	if ua := got.Get("User-Agent"); ua != "test" {
+		t.Errorf("Collector.UserAgent overridden with %q", ua)
+	}
+	if got.Get("Sec-Ch-Ua") != "" {
+		t.Error("Client hints sent for another User-Agent")
+	}
+}
+
 func TestParseHTTPErrorResponse(t *testing.T) {
 	contentCount := 0
 	ts := newTestServer()
@@ -1084,6 +1371,579 @@ func TestCollectorVisitWithCheckHead(t *testing.T) {
 	}
 }
 
+func TestCollectorFetcher(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	renderer := FetcherFunc(func(req *http.Request, bodySize int, checkHeadersFunc CheckHeadersFunc) (*Response, error) {
+		header := http.Header{"Content-Type": []string{"text/html"}}
+		if !checkHeadersFunc(200, header) {
+			return nil, ErrAbortedAfterHeaders
+		}
+		return &Response{
+			StatusCode: 200,
+			Body:       []byte(`<html><body><p id="rendered">rendered</p></body></html>`),
+			Headers:    &header,
+		}, nil
+	})
+
+	c := NewCollector(AllowURLRevisit())
+	c.OnRequest(func(r *Request) {
+		if r.Ctx.Get("render") == "yes" {
+			r.Fetcher = renderer
+		}
+	})
+	var texts []string
+	c.OnHTML("p", func(e *HTMLElement) {
+		texts = append(texts, e.Text)
+	})
+
+	ctx := NewContext()
+	ctx.Put("render", "yes")
+	if err := c.Request("GET", ts.URL+"/html", nil, ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 1 || texts[0] != "rendered" {
+		t.Errorf("Request.Fetcher wasn't used: %q", texts)
+	}
+
+	texts = nil
+	if err := c.Visit(ts.URL + "/html"); err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || texts[0] != "This is a test page" {
+		t.Errorf("Default fetcher wasn't used: %q", texts)
+	}
+
+	texts = nil
+	c.SetFetcher(renderer)
+	if err := c.Visit(ts.URL + "/other"); err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 1 || texts[0] != "rendered" {
+		t.Errorf("Collector fetcher wasn't used: %q", texts)
+	}
+}
+
+func TestCollectorCache(t *testing.T) {
+	hits := map[string]int{}
+	revalidated := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				revalidated++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write([]byte("body of " + r.URL.Path))
+	}))
+	defer ts.Close()
+
+	c := NewCollector(AllowURLRevisit())
+	c.SetCache(cache.New(cache.NewLRUStore(0)))
+	var bodies []string
+	c.OnResponse(func(r *Response) {
+		if r.StatusCode != 200 {
+			t.Errorf("Wrong status code: %d", r.StatusCode)
+		}
+		bodies = append(bodies, string(r.Body))
+	})
+
+	for i := 0; i < 2; i++ {
+		for _, path := range []string{"/fresh", "/etag", "/none"} {
+			if err := c.Visit(ts.URL + path); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if hits["/fresh"] != 1 {
+		t.Errorf("Fresh response fetched %d times", hits["/fresh"])
+	}
+	if hits["/etag"] != 2 || revalidated != 1 {
+		t.Errorf("Stale response not revalidated: %d hits, %d revalidations", hits["/etag"], revalidated)
+	}
+	if hits["/none"] != 2 {
+		t.Errorf("Uncacheable response fetched %d times", hits["/none"])
+	}
+	want := []string{"body of /fresh", "body of /etag", "body of /none", "body of /fresh", "body of /etag", "body of /none"}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("Wrong bodies: %q", bodies)
+	}
+
+	httpCache := cache.New(cache.NewLRUStore(0))
+	httpCache.TTL = time.Hour
+	c.SetCache(httpCache)
+	for i := 0; i < 2; i++ {
+		if err := c.Visit(ts.URL + "/none?ttl"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits["/none"] != 3 {
+		t.Errorf("TTL override ignored: %d hits", hits["/none"])
+	}
+}
+
+func TestCollectorCacheTruncated(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("0123456789"))
+	}))
+	defer ts.Close()
+
+	c := NewCollector(AllowURLRevisit(), MaxBodySize(4), CacheDir(t.TempDir()))
+	var bodies []string
+	c.OnResponse(func(r *Response) {
+		if !r.Truncated {
+			t.Error("Truncated body served as complete")
+		}
+		bodies = append(bodies, string(r.Body))
+	})
+	for i := 0; i < 2; i++ {
+		if err := c.Visit(ts.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits != 2 {
+		t.Errorf("Truncated response cached: %d hits", hits)
+	}
+	if !reflect.DeepEqual(bodies, []string{"0123", "0123"}) {
+		t.Errorf("Wrong bodies: %q", bodies)
+	}
+}
+
+type eventRecorder struct {
+	events []*debug.Event
+}
+
+func (r *eventRecorder) Init() error { return nil }
+
+func (r *eventRecorder) Event(e *debug.Event) { r.events = append(r.events, e) }
+
+func TestRetryPolicy(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch {
+		case r.URL.Path == "/fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case attempts == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case attempts == 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(body)
+		}
+	}))
+	defer ts.Close()
+
+	d := &eventRecorder{}
+	c := NewCollector(Retries(&RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}), Debugger(d))
+	var retries interface{}
+	var body string
+	c.OnResponse(func(r *Response) {
+		retries = r.Ctx.GetAny(RetriesKey)
+		body = string(r.Body)
+	})
+	var failed error
+	c.OnError(func(r *Response, err error) {
+		retries = r.Ctx.GetAny(RetriesKey)
+		failed = err
+	})
+
+	if err := c.PostRaw(ts.URL, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || retries != 2 {
+		t.Errorf("Got %d attempts and %v retries, want 3 and 2", attempts, retries)
+	}
+	if body != "payload" {
+		t.Errorf("Retried request body = %q", body)
+	}
+	n := 0
+	for _, e := range d.events {
+		if e.Type == "retry" {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("Got %d retry events, want 2", n)
+	}
+
+	attempts = 0
+	if err := c.Visit(ts.URL + "/fail"); err == nil || failed == nil {
+		t.Error("Error expected after the last attempt")
+	}
+	if attempts != 3 || retries != 2 {
+		t.Errorf("Got %d attempts and %v retries, want 3 and 2", attempts, retries)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+	for attempt, max := range []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		p.MaxAttempts = attempt + 1
+		d, ok := p.next(attempt, nil, syscall.ECONNRESET)
+		if !ok || d < max/2 || d > max {
+			t.Errorf("Attempt %d: delay %v, want between %v and %v", attempt, d, max/2, max)
+		}
+	}
+	if _, ok := p.next(1, nil, ErrAbortedAfterHeaders); ok {
+		t.Error("Aborted request retried")
+	}
+	hdr := http.Header{"Retry-After": []string{"2"}}
+	if d, _ := p.next(1, &Response{StatusCode: 503, Headers: &hdr}, nil); d != 2*time.Second {
+		t.Errorf("Retry-After ignored: delay %v", d)
+	}
+}
+
+func TestVisitContext(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	c := NewCollector(Async())
+	c.Limit(&LimitRule{DomainGlob: "*", Parallelism: 1})
+	var errs []error
+	var lock sync.Mutex
+	c.OnError(func(_ *Response, err error) {
+		lock.Lock()
+		errs = append(errs, err)
+		lock.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// The second request waits for the LimitRule behind the first one.
+	c.VisitContext(ctx, ts.URL+"/1")
+	c.VisitContext(ctx, ts.URL+"/2")
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	wctx, wcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer wcancel()
+	if err := c.WaitContext(wctx); err != nil {
+		t.Fatal("Requests not aborted")
+	}
+	if len(errs) != 2 {
+		t.Errorf("Got %d errors, want 2: %v", len(errs), errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Got error %v, want %v", err, context.Canceled)
+		}
+	}
+	if err := c.VisitContext(ctx, ts.URL+"/3"); err != context.Canceled {
+		t.Errorf("Request started after cancellation: %v", err)
+	}
+}
+
+func TestOnResponseStream(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	c := NewCollector(MaxBodySize(1000))
+	var streamed int64
+	c.OnResponseStream(func(r *Response, body io.Reader) error {
+		if r.Truncated {
+			t.Error("Truncated before the body is read")
+		}
+		n, err := io.Copy(ioutil.Discard, body)
+		streamed = n
+		return err
+	})
+	c.OnHTML("p", func(_ *HTMLElement) {
+		t.Error("OnHTML called on a streamed response")
+	})
+	var truncated bool
+	c.OnScraped(func(r *Response) {
+		if len(r.Body) != 0 {
+			t.Errorf("Body of %d bytes read in memory", len(r.Body))
+		}
+		truncated = r.Truncated
+	})
+	if err := c.Visit(ts.URL + "/large_binary"); err != nil {
+		t.Fatal(err)
+	}
+	if streamed != 1000 || !truncated {
+		t.Errorf("Streamed %d bytes, truncated: %v", streamed, truncated)
+	}
+	if err := c.Visit(ts.URL + "/html"); err != nil {
+		t.Fatal(err)
+	}
+	if streamed == 0 || truncated {
+		t.Errorf("Streamed %d bytes, truncated: %v", streamed, truncated)
+	}
+
+	c = NewCollector(MaxBodySize(10))
+	c.OnResponse(func(r *Response) {
+		if len(r.Body) != 10 || !r.Truncated {
+			t.Errorf("Body of %d bytes, truncated: %v", len(r.Body), r.Truncated)
+		}
+	})
+	c.Visit(ts.URL + "/html")
+}
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	sum := sha256.Sum256(content)
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "file.bin")
+	c := NewCollector(MaxBodySize(4000))
+	var errs int
+	c.OnError(func(_ *Response, _ error) { errs++ })
+	var written, total int64
+	d := &Download{
+		Path:     path,
+		Checksum: sum[:],
+		Progress: func(w, t int64) { written, total = w, t },
+	}
+	if err := c.Download(ts.URL, d); err != ErrBodyTruncated {
+		t.Fatalf("Download() cut by MaxBodySize = %v", err)
+	}
+	if written != 4000 || total != int64(len(content)) {
+		t.Errorf("Progress of %d/%d bytes", written, total)
+	}
+
+	// The download is resumed where it stopped.
+	c.MaxBodySize = 0
+	if err := c.Download(ts.URL, d); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "bytes=4000-"}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("Range headers %q, want %q", ranges, want)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(b, content) {
+		t.Errorf("Downloaded %d bytes, %v", len(b), err)
+	}
+	if written != total || errs != 1 {
+		t.Errorf("Progress of %d/%d bytes, %d errors", written, total, errs)
+	}
+
+	d.Path = filepath.Join(t.TempDir(), "corrupted.bin")
+	d.Checksum = make([]byte, sha256.Size)
+	if err := c.Download(ts.URL, d); err != ErrChecksumMismatch {
+		t.Errorf("Download() with a wrong checksum = %v", err)
+	}
+	if _, err := os.Stat(d.Path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Corrupted download kept: %v", err)
+	}
+}
+
+func TestOnResponseStreamRetries(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	attempts := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts[r.URL.Path]++
+		switch {
+		case r.URL.Path == "/unavailable" && attempts[r.URL.Path] == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case attempts[r.URL.Path] == 1:
+			// cut the body halfway on the first attempt
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+		default:
+			w.Write(content)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewCollector(AllowURLRevisit(), Retries(&RetryPolicy{BaseDelay: time.Millisecond}))
+	var streams []string
+	c.OnResponseStream(func(r *Response, body io.Reader) error {
+		b, err := ioutil.ReadAll(body)
+		streams = append(streams, fmt.Sprintf("%s %d bytes after %v retries", r.Request.URL.Path, len(b), r.Ctx.GetAny(RetriesKey)))
+		return err
+	})
+	// Bodies passed to the callbacks aren't replayed.
+	if err := c.Visit(ts.URL + "/cut"); err == nil {
+		t.Error("Cut body not reported")
+	}
+	if err := c.Visit(ts.URL + "/unavailable"); err != nil {
+		t.Error(err)
+	}
+	want := []string{"/cut 500 bytes after 0 retries", "/unavailable 1000 bytes after 1 retries"}
+	if attempts["/cut"] != 1 || !reflect.DeepEqual(streams, want) {
+		t.Errorf("%d attempts, streams %q, want %q", attempts["/cut"], streams, want)
+	}
+
+	// Downloads are retried, rewriting the part of the failed attempt.
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := c.Download(ts.URL+"/download", &Download{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); attempts["/download"] != 2 || !bytes.Equal(b, content) {
+		t.Errorf("Downloaded %d bytes in %d attempts", len(b), attempts["/download"])
+	}
+}
+
+// encodeBody applies the content codings to b in order.
+func encodeBody(t *testing.T, b []byte, codings ...string) []byte {
+	for _, coding := range codings {
+		buf := &bytes.Buffer{}
+		var w io.WriteCloser
+		switch coding {
+		case "gzip":
+			w = gzip.NewWriter(buf)
+		case "deflate":
+			w = zlib.NewWriter(buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(buf)
+		case "zstd":
+			w, _ = zstd.NewWriter(buf)
+		default:
+			continue
+		}
+		w.Write(b)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		b = buf.Bytes()
+	}
+	return b
+}
+
+func TestContentDecoding(t *testing.T) {
+	page := []byte("<html><head><title>Decoded</title></head><body></body></html>")
+	bomb := encodeBody(t, make([]byte, 50<<20), "gzip", "zstd")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate, br, zstd" {
+			t.Errorf("Accept-Encoding: %q", got)
+		}
+		codings := strings.Split(r.URL.Query().Get("ce"), ",")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", strings.Replace(r.URL.Query().Get("ce"), "raw-deflate", "deflate", 1))
+		if r.URL.Path == "/bomb" {
+			w.Write(bomb)
+			return
+		}
+		w.Write(encodeBody(t, page, codings...))
+	}))
+	defer ts.Close()
+
+	c := NewCollector(AllowURLRevisit())
+	var title, encoding string
+	c.OnHTML("title", func(e *HTMLElement) {
+		title = e.Text
+	})
+	c.OnResponse(func(r *Response) {
+		encoding = r.Headers.Get("Content-Encoding")
+	})
+	for _, ce := range []string{"gzip", "deflate", "raw-deflate", "br", "zstd", "gzip,br", "deflate,zstd,br", "identity", "compress"} {
+		title = ""
+		if err := c.Visit(ts.URL + "/?ce=" + ce); err != nil {
+			t.Errorf("Visit() with %s encoding: %v", ce, err)
+		}
+		if title != "Decoded" {
+			t.Errorf("Body with %s encoding not decoded: %q", ce, title)
+		}
+		// unsupported codings are left as is
+		want := ""
+		if ce == "compress" {
+			want = ce
+		}
+		if encoding != want {
+			t.Errorf("Content-Encoding %q left after decoding %s", encoding, ce)
+		}
+	}
+
+	// MaxBodySize applies to the decoded body.
+	c = NewCollector(MaxBodySize(1 << 20))
+	c.OnResponse(func(r *Response) {
+		if len(r.Body) != 1<<20 || !r.Truncated {
+			t.Errorf("Decompression bomb decoded to %d bytes", len(r.Body))
+		}
+	})
+	if err := c.Visit(ts.URL + "/bomb?ce=gzip,zstd"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCharsetSniffing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		http.ServeFile(w, r, filepath.Join("testdata", "charset", filepath.Base(r.URL.Path)))
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		file        string
+		contentType string
+		detect      bool
+		title       string
+	}{
+		// BOM, then the header, then the prescan
+		{"utf-16le.html", "text/html; charset=iso-8859-1", false, "日本語のページ"},
+		{"euc-kr.html", "text/html; charset=euc-kr", false, "한국어 페이지"},
+		{"shift_jis.html", "text/html", false, "日本語のページ"},
+		{"gbk.html", "text/html", false, "简体中文网页"},
+		{"big5.html", "text/html", false, "繁體中文網頁"},
+		{"euc-kr.html", "text/html", false, "한국어 페이지"},
+		{"euc-jp.xml", "application/xml", false, "日本語の文書"},
+		// chardet is the last resort
+		{"gb18030.html", "text/html", true, "简体中文网页"},
+	} {
+		c := NewCollector()
+		c.DetectCharset = tt.detect
+		var title string
+		c.OnHTML("title", func(e *HTMLElement) {
+			title = e.Text
+		})
+		c.OnXML("/page/title", func(e *XMLElement) {
+			title = e.Text
+		})
+		if err := c.Visit(ts.URL + "/" + tt.file + "?type=" + url.QueryEscape(tt.contentType)); err != nil {
+			t.Fatal(err)
+		}
+		if title != tt.title {
+			t.Errorf("Title of %s served as %q is %q, want %q", tt.file, tt.contentType, title, tt.title)
+		}
+	}
+
+	// A declaration found by the prescan comes first.
+	for _, tt := range []struct {
+		body, contentType, want string
+	}{
+		{"<meta charset=gbk>", "", "gbk"},
+		{`<META HTTP-EQUIV="content-type" CONTENT="text/html; charset=Shift_JIS">`, "text/html", "shift_jis"},
+		{"<meta charset=utf-16le>", "text/html", "utf-8"},
+		{"<meta charset=unknown><meta charset=euc-jp>", "text/html", "euc-jp"},
+		{`<?xml version="1.0" encoding="GB2312"?><meta charset=big5>`, "application/xhtml+xml", "gbk"},
+		{"<meta charset=gbk>", "text/plain", ""},
+		{strings.Repeat(" ", 1024) + "<meta charset=gbk>", "text/html", ""},
+	} {
+		if got, _ := sniffCharset([]byte(tt.body), tt.contentType); got != tt.want {
+			t.Errorf("sniffCharset(%q, %q) = %q, want %q", tt.body, tt.contentType, got, tt.want)
+		}
+	}
+}
+
 func BenchmarkOnHTML(b *testing.B) {
 	ts := newTestServer()
 	defer ts.Close()
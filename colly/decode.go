@@ -0,0 +1,160 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding is the Accept-Encoding header sent by the HTTP backend
+// when requests don't set one, listing the content codings it decodes.
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// zstdMaxWindow is the largest window of zstd encoded bodies, as advised
+// by RFC 9659, to bound the memory used to decode them.
+const zstdMaxWindow = 8 << 20
+
+// contentDecoders create the readers decoding the content codings of
+// response bodies.
+var contentDecoders = map[string]func(r *bufio.Reader) (io.ReadCloser, error){
+	"gzip":   newGzipReader,
+	"x-gzip": newGzipReader,
+	"deflate": func(r *bufio.Reader) (io.ReadCloser, error) {
+		// "deflate" is the zlib format, but some servers send raw
+		// deflate data.
+		if h, err := r.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+			return zlib.NewReader(r)
+		}
+		return flate.NewReader(r), nil
+	},
+	"br": func(r *bufio.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	},
+	"zstd": func(r *bufio.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	},
+	"identity": func(r *bufio.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(r), nil
+	},
+}
+
+func newGzipReader(r *bufio.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// decodeBody returns a reader of the body of res decoded from the content
+// codings listed by its Content-Encoding header, in the reverse order they
+// were applied, along with a function releasing the decoders. Decoded
+// codings are removed from the header, with the Content-Length which
+// doesn't apply anymore. Decoding stops at the first unsupported coding,
+// leaving the rest of the body encoded.
+//
+// Bodies of gzip files are decoded as well when they are served without
+// Content-Encoding, like sitemaps.
+func decodeBody(res *http.Response) (io.Reader, func(), error) {
+	var body io.Reader = res.Body
+	if res.Uncompressed {
+		return body, func() {}, nil
+	}
+	codings := contentCodings(res.Header.Get("Content-Encoding"))
+	if len(codings) == 0 && isGzipFile(res) {
+		codings = []string{"gzip"}
+	}
+	if len(codings) == 0 {
+		return body, func() {}, nil
+	}
+	br := bufio.NewReader(body)
+	if _, err := br.Peek(1); err == io.EOF {
+		// empty bodies, like the ones of HEAD requests, aren't encoded
+		return br, func() {}, nil
+	}
+	body = br
+
+	var closers []io.Closer
+	release := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	decoded := 0
+	for i := len(codings) - 1; i >= 0; i-- {
+		newDecoder, ok := contentDecoders[codings[i]]
+		if !ok {
+			break
+		}
+		if decoded > 0 {
+			br = bufio.NewReader(body)
+		}
+		d, err := newDecoder(br)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		closers = append(closers, d)
+		body = d
+		decoded++
+	}
+	if res.Header.Get("Content-Encoding") != "" && decoded > 0 {
+		if left := codings[:len(codings)-decoded]; len(left) > 0 {
+			res.Header.Set("Content-Encoding", strings.Join(left, ", "))
+		} else {
+			res.Header.Del("Content-Encoding")
+		}
+		res.Header.Del("Content-Length")
+	}
+	return body, release, nil
+}
+
+// setAcceptEncoding advertises the content codings decoded by the HTTP
+// backend, unless request sets Accept-Encoding. Range requests ask for
+// ranges of the encoded body, which can't be decoded alone, so they're
+// left alone too.
+func setAcceptEncoding(request *http.Request) {
+	if request.Header.Get("Accept-Encoding") == "" && request.Header.Get("Range") == "" {
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+}
+
+// contentCodings parses the value of a Content-Encoding header.
+func contentCodings(v string) []string {
+	var codings []string
+	for _, c := range strings.Split(v, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			codings = append(codings, c)
+		}
+	}
+	return codings
+}
+
+func isGzipFile(res *http.Response) bool {
+	if strings.Contains(strings.ToLower(res.Header.Get("Content-Type")), "gzip") {
+		return true
+	}
+	return res.Request != nil && strings.HasSuffix(strings.ToLower(res.Request.URL.Path), ".xml.gz")
+}
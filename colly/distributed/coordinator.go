@@ -0,0 +1,354 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package distributed runs a crawl in several processes. A Coordinator
+// owns the queue of requests and the set of visited requests, and serves
+// them over HTTP to Workers, which lease batches of serialized requests,
+// run them with a Collector and acknowledge them along with the requests
+// they found. The requests of a lease which isn't acknowledged in time,
+// because its Worker crashed or hung, are queued again.
+package distributed
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"xrmcp/colly"
+	"xrmcp/colly/queue"
+	"xrmcp/colly/storage"
+)
+
+const (
+	// DefaultLeaseTTL is the time Workers have to acknowledge the
+	// requests of a lease if Coordinator.LeaseTTL isn't set.
+	DefaultLeaseTTL = time.Minute
+	// DefaultBatchSize is the maximum number of requests of a lease if
+	// Coordinator.MaxBatchSize isn't set.
+	DefaultBatchSize = 10
+)
+
+// leaseRequest is the body of a POST /lease request.
+type leaseRequest struct {
+	Max int `json:"max"`
+}
+
+// leaseResponse is the body of the response to a POST /lease request.
+// Done is true when the crawl is over: the queue is empty and no request
+// is leased.
+type leaseResponse struct {
+	Requests []leasedRequest `json:"requests"`
+	Done     bool            `json:"done"`
+}
+
+type leasedRequest struct {
+	ID      uint64          `json:"id"`
+	Request json.RawMessage `json:"request"`
+}
+
+// ackRequest is the body of a POST /ack request, acknowledging the leased
+// requests IDs and adding Requests to the queue.
+type ackRequest struct {
+	IDs      []uint64          `json:"ids"`
+	Requests []json.RawMessage `json:"requests"`
+}
+
+// Coordinator is an http.Handler serving the requests of a crawl to
+// Workers. It serves the POST /lease and POST /ack endpoints, to be
+// mounted with http.StripPrefix under another path.
+//
+// Requests are added to the queue once: the requests which were added
+// before, according to Fingerprinter, are dropped. Requests are run at
+// least once, a request may be run again if its lease expired while it
+// was running.
+type Coordinator struct {
+	// LeaseTTL is the time Workers have to acknowledge the requests of a
+	// lease, DefaultLeaseTTL if 0. Each acknowledgement renews the lease
+	// of the other requests of the batch
+	LeaseTTL time.Duration
+	// MaxBatchSize is the maximum number of requests of a lease,
+	// DefaultBatchSize if 0
+	MaxBatchSize int
+	// Fingerprinter identifies the requests added to the queue,
+	// colly.DefaultFingerprinter if nil
+	Fingerprinter colly.Fingerprinter
+	queue         queue.Storage
+	visited       storage.Storage
+	collector     *colly.Collector
+	mux           *http.ServeMux
+	lock          sync.Mutex
+	seq           uint64
+	leased        map[uint64]*leasedItem
+	done          chan struct{}
+	now           func() time.Time
+}
+
+type leasedItem struct {
+	request []byte
+	// storageID is the ID of the request in a queue.ResumableStorage
+	storageID uint64
+	batch     *batch
+}
+
+type batch struct {
+	deadline time.Time
+}
+
+// NewCoordinator creates a Coordinator keeping the queued requests in q and
+// the visited requests in s. In-memory storages are used if they're nil.
+// Requests taken by a previous Coordinator from a queue.ResumableStorage
+// and not acknowledged are queued again.
+func NewCoordinator(q queue.Storage, s storage.Storage) (*Coordinator, error) {
+	if q == nil {
+		q = &queue.InMemoryQueueStorage{MaxSize: 100000}
+	}
+	if s == nil {
+		s = &storage.InMemoryStorage{}
+	}
+	if err := q.Init(); err != nil {
+		return nil, err
+	}
+	if err := s.Init(); err != nil {
+		return nil, err
+	}
+	if rs, ok := q.(queue.ResumableStorage); ok {
+		if err := rs.Resume(); err != nil {
+			return nil, err
+		}
+	}
+	c := &Coordinator{
+		queue:     q,
+		visited:   s,
+		collector: colly.NewCollector(),
+		leased:    make(map[uint64]*leasedItem),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+	c.mux = http.NewServeMux()
+	c.mux.HandleFunc("/lease", c.handleLease)
+	c.mux.HandleFunc("/ack", c.handleAck)
+	return c, nil
+}
+
+// AddURL adds a GET request of URL to the queue. It returns
+// colly.ErrAlreadyVisited if it was added before.
+func (c *Coordinator) AddURL(URL string) error {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return err
+	}
+	return c.AddRequest(&colly.Request{URL: u, Method: "GET"})
+}
+
+// AddRequest adds r to the queue. It returns colly.ErrAlreadyVisited if it
+// was added before.
+func (c *Coordinator) AddRequest(r *colly.Request) error {
+	d, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.addLocked(d)
+}
+
+// Size returns the number of queued and leased requests.
+func (c *Coordinator) Size() (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	size, err := c.queue.QueueSize()
+	return size + len(c.leased), err
+}
+
+// Done returns a channel closed once a Worker was told that the crawl is
+// over, because the queue is empty and no request is leased. The seed
+// requests must be added before the Workers are started.
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.ServeHTTP(w, r)
+}
+
+func (c *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	req := &leaseRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	maxSize := c.MaxBatchSize
+	if maxSize <= 0 {
+		maxSize = DefaultBatchSize
+	}
+	if req.Max <= 0 || req.Max > maxSize {
+		req.Max = maxSize
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	if err := c.expireLocked(now); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := &leaseResponse{Requests: []leasedRequest{}}
+	b := &batch{deadline: now.Add(c.leaseTTL())}
+	rs, resumable := c.queue.(queue.ResumableStorage)
+	for len(resp.Requests) < req.Max {
+		var id uint64
+		var d []byte
+		var err error
+		if resumable {
+			id, d, err = rs.TakeRequest()
+		} else {
+			d, err = c.queue.GetRequest()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if d == nil {
+			break
+		}
+		c.seq++
+		c.leased[c.seq] = &leasedItem{request: d, storageID: id, batch: b}
+		resp.Requests = append(resp.Requests, leasedRequest{ID: c.seq, Request: d})
+	}
+	if len(resp.Requests) == 0 {
+		size, err := c.queue.QueueSize()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if size == 0 && len(c.leased) == 0 {
+			resp.Done = true
+			select {
+			case <-c.done:
+			default:
+				close(c.done)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (c *Coordinator) handleAck(w http.ResponseWriter, r *http.Request) {
+	req := &ackRequest{}
+	if !decodeRequest(w, r, req) {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// New requests are queued first, so that the crawl never looks over
+	// between the acknowledgement and their addition.
+	for _, d := range req.Requests {
+		if err := c.addLocked(d); err != nil && err != colly.ErrAlreadyVisited {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	rs, resumable := c.queue.(queue.ResumableStorage)
+	deadline := c.now().Add(c.leaseTTL())
+	for _, id := range req.IDs {
+		item, ok := c.leased[id]
+		if !ok {
+			// The lease expired and the request was queued again.
+			continue
+		}
+		delete(c.leased, id)
+		item.batch.deadline = deadline
+		if resumable {
+			if err := rs.Ack(item.storageID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addLocked queues the serialized request d unless it was added before.
+func (c *Coordinator) addLocked(d []byte) error {
+	r, err := c.collector.UnmarshalRequest(d)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	fp := c.Fingerprinter
+	if fp == nil {
+		fp = colly.DefaultFingerprinter
+	}
+	id := fp.Fingerprint(r.Method, r.URL, *r.Headers, body)
+	visited, err := c.visited.IsVisited(id)
+	if err != nil {
+		return err
+	}
+	if visited {
+		return colly.ErrAlreadyVisited
+	}
+	if err := c.queue.AddRequest(d); err != nil {
+		return err
+	}
+	return c.visited.Visited(id)
+}
+
+// expireLocked queues the requests of the expired leases again. They're
+// added before the taken ones are acknowledged, so that a crash in between
+// runs them twice rather than never.
+func (c *Coordinator) expireLocked(now time.Time) error {
+	rs, resumable := c.queue.(queue.ResumableStorage)
+	for id, item := range c.leased {
+		if now.Before(item.batch.deadline) {
+			continue
+		}
+		if err := c.queue.AddRequest(item.request); err != nil {
+			return err
+		}
+		delete(c.leased, id)
+		if resumable {
+			if err := rs.Ack(item.storageID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Coordinator) leaseTTL() time.Duration {
+	if c.LeaseTTL > 0 {
+		return c.LeaseTTL
+	}
+	return DefaultLeaseTTL
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
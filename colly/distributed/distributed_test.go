@@ -0,0 +1,310 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package distributed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"xrmcp/colly"
+	"xrmcp/colly/sqlite"
+)
+
+const pageCount = 40
+
+// newSite serves pages linking to two other pages each, and counts the
+// visits of every page.
+func newSite() (*httptest.Server, func() map[string]int) {
+	var lock sync.Mutex
+	visits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		visits[r.URL.Path]++
+		lock.Unlock()
+		i, _ := strconv.Atoi(r.URL.Path[1:])
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>")
+		for _, j := range []int{2*i + 1, 2*i + 2, i} {
+			if j < pageCount {
+				fmt.Fprintf(w, `<a href="/%d">%d</a>`, j, j)
+			}
+		}
+		fmt.Fprint(w, "</body></html>")
+	}))
+	return ts, func() map[string]int {
+		lock.Lock()
+		defer lock.Unlock()
+		return visits
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	c, err := NewCoordinator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LeaseTTL = time.Minute
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := c.AddURL(fmt.Sprintf("http://example.com/%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AddURL("http://example.com/1"); err != colly.ErrAlreadyVisited {
+		t.Errorf("AddURL() of a queued URL = %v", err)
+	}
+
+	w := NewWorker(ts.URL, 2)
+	ctx := context.Background()
+	first, err := w.lease(ctx)
+	if err != nil || len(first.Requests) != 2 {
+		t.Fatalf("lease() = %v, %v", first, err)
+	}
+	second, err := w.lease(ctx)
+	if err != nil || len(second.Requests) != 1 {
+		t.Fatalf("lease() = %v, %v", second, err)
+	}
+	if resp, _ := w.lease(ctx); len(resp.Requests) != 0 || resp.Done {
+		t.Fatalf("lease() of an empty queue = %v, want to wait for the leases", resp)
+	}
+
+	// Acknowledging a request renews the lease of its batch.
+	now = now.Add(40 * time.Second)
+	w.AddURL("http://example.com/0")
+	w.AddURL("http://example.com/3")
+	if err := w.ack(ctx, []uint64{first.Requests[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := c.Size(); size != 3 {
+		t.Errorf("Size() = %d, want 3", size)
+	}
+	now = now.Add(40 * time.Second)
+	third, err := w.lease(ctx)
+	if err != nil || len(third.Requests) != 2 {
+		t.Fatalf("lease() = %v, %v", third, err)
+	}
+	got := map[string]bool{}
+	for _, l := range third.Requests {
+		r, _ := c.collector.UnmarshalRequest(l.Request)
+		got[r.URL.Path] = true
+	}
+	if !got["/3"] || !got["/2"] {
+		t.Errorf("Expired lease not queued again: %v", got)
+	}
+
+	// Late acknowledgements of expired leases are ignored.
+	if err := w.ack(ctx, []uint64{second.Requests[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if err := w.ack(ctx, []uint64{first.Requests[1].ID, third.Requests[0].ID, third.Requests[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := w.lease(ctx)
+	if len(resp.Requests) != 0 || !resp.Done {
+		t.Fatalf("lease() at the end = %v", resp)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Coordinator not done")
+	}
+}
+
+// TestLeaseExpiryCrash checks that a request whose lease expired survives
+// a crash of the Coordinator with a persistent queue.
+func TestLeaseExpiryCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	db, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := sqlite.NewQueueStorage(db)
+	q.FlushInterval = time.Hour
+	c, err := NewCoordinator(q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LeaseTTL = time.Minute
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+	if err := c.AddURL("http://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWorker(ts.URL, 1)
+	if resp, err := w.lease(context.Background()); err != nil || len(resp.Requests) != 1 {
+		t.Fatalf("lease() = %v, %v", resp, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.lock.Lock()
+	err = c.expireLocked(now)
+	c.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash without closing q.
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	db, err = sqlite.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+	c, err = NewCoordinator(sqlite.NewQueueStorage(db), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := c.Size(); size != 1 {
+		t.Errorf("Size() after the crash = %d, want the expired request", size)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	site, visits := newSite()
+	defer site.Close()
+	c, err := NewCoordinator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.StripPrefix("/crawl", c))
+	defer ts.Close()
+	c.AddURL(site.URL + "/0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runWorker(ts.URL + "/crawl/"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	checkVisits(t, visits())
+}
+
+// TestWorkerProcesses crawls the site with several worker processes, the
+// first of which crashes with a leased batch.
+func TestWorkerProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test starting processes in short mode")
+	}
+	site, visits := newSite()
+	defer site.Close()
+	c, err := NewCoordinator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LeaseTTL = 200 * time.Millisecond
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+	c.AddURL(site.URL + "/0")
+
+	start := func(crash bool) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperWorkerProcess$")
+		cmd.Env = append(os.Environ(), "DISTRIBUTED_COORDINATOR="+ts.URL)
+		if crash {
+			cmd.Env = append(cmd.Env, "DISTRIBUTED_CRASH=1")
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+	if err := start(true).Wait(); err != nil {
+		t.Fatalf("Crashing worker: %v", err)
+	}
+	if size, _ := c.Size(); size != 1 {
+		t.Fatalf("Size() after the crash = %d, want 1", size)
+	}
+	var workers []*exec.Cmd
+	for i := 0; i < 3; i++ {
+		workers = append(workers, start(false))
+	}
+	for _, w := range workers {
+		if err := w.Wait(); err != nil {
+			t.Errorf("Worker: %v", err)
+		}
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Coordinator not done")
+	}
+	checkVisits(t, visits())
+}
+
+// TestHelperWorkerProcess is the worker process started by
+// TestWorkerProcesses.
+func TestHelperWorkerProcess(t *testing.T) {
+	coordinator := os.Getenv("DISTRIBUTED_COORDINATOR")
+	if coordinator == "" {
+		t.Skip("not a worker process")
+	}
+	if os.Getenv("DISTRIBUTED_CRASH") != "" {
+		w := NewWorker(coordinator, 1)
+		if _, err := w.lease(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if err := runWorker(coordinator); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runWorker(coordinator string) error {
+	w := NewWorker(coordinator, 2)
+	w.PollInterval = 20 * time.Millisecond
+	c := colly.NewCollector(colly.AllowURLRevisit())
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		w.AddURL(e.Request.AbsoluteURL(e.Attr("href")))
+	})
+	return w.Run(c)
+}
+
+func checkVisits(t *testing.T, visits map[string]int) {
+	t.Helper()
+	if len(visits) != pageCount {
+		t.Errorf("%d pages visited, want %d", len(visits), pageCount)
+	}
+	for path, n := range visits {
+		if n != 1 {
+			t.Errorf("%s visited %d times", path, n)
+		}
+	}
+}
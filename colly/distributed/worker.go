@@ -0,0 +1,247 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"xrmcp/colly"
+)
+
+// DefaultPollInterval is the time a Worker waits before leasing requests
+// again, when the queue is empty but other Workers have requests in
+// flight, if Worker.PollInterval isn't set.
+const DefaultPollInterval = 500 * time.Millisecond
+
+// Worker runs the requests leased from a Coordinator with a Collector.
+// The requests found by the callbacks of the Collector are added with
+// AddURL or AddRequest, and sent to the Coordinator along with the
+// acknowledgement of the request being run.
+//
+// The Coordinator keeps track of the visited requests, so the Collector
+// should allow URL revisits: a request leased again after its lease
+// expired would be skipped as already visited otherwise.
+type Worker struct {
+	// Coordinator is the URL the Coordinator is served at
+	Coordinator string
+	// Threads defines the number of requests run concurrently
+	Threads int
+	// BatchSize is the number of requests leased at once, Threads if 0
+	BatchSize int
+	// PollInterval is the time to wait for requests when the queue is
+	// empty, DefaultPollInterval if 0
+	PollInterval time.Duration
+	// Client is the HTTP client talking to the Coordinator,
+	// http.DefaultClient if nil
+	Client  *http.Client
+	lock    sync.Mutex
+	pending []json.RawMessage
+}
+
+// NewWorker creates a Worker of the Coordinator served at coordinatorURL,
+// running threads requests concurrently.
+func NewWorker(coordinatorURL string, threads int) *Worker {
+	return &Worker{
+		Coordinator: strings.TrimSuffix(coordinatorURL, "/"),
+		Threads:     threads,
+	}
+}
+
+// AddURL adds a GET request of URL to the queue of the Coordinator.
+func (w *Worker) AddURL(URL string) error {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return err
+	}
+	return w.AddRequest(&colly.Request{URL: u, Method: "GET"})
+}
+
+// AddRequest adds r to the queue of the Coordinator. Requests are sent
+// with the next acknowledgement, or before the next lease.
+func (w *Worker) AddRequest(r *colly.Request) error {
+	d, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	w.lock.Lock()
+	w.pending = append(w.pending, d)
+	w.lock.Unlock()
+	return nil
+}
+
+// Run leases requests from the Coordinator and runs them with c until the
+// crawl is over.
+func (w *Worker) Run(c *colly.Collector) error {
+	return w.RunContext(context.Background(), c)
+}
+
+// RunContext is like Run, but stops once ctx is done, aborting the requests
+// in flight as described in colly.Collector.VisitContext. The aborted
+// requests aren't acknowledged, so they are queued again once their lease
+// expires.
+func (w *Worker) RunContext(ctx context.Context, c *colly.Collector) error {
+	threads := w.Threads
+	if threads <= 0 {
+		threads = 1
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.ack(ctx, nil); err != nil {
+			return err
+		}
+		resp, err := w.lease(ctx)
+		if err != nil {
+			return err
+		}
+		if len(resp.Requests) == 0 {
+			if resp.Done {
+				return nil
+			}
+			if err := w.sleep(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.runBatch(ctx, c, resp.Requests, threads); err != nil {
+			return err
+		}
+	}
+}
+
+// runBatch runs the leased requests in threads goroutines and
+// acknowledges them one by one.
+func (w *Worker) runBatch(ctx context.Context, c *colly.Collector, requests []leasedRequest, threads int) error {
+	requestc := make(chan leasedRequest)
+	errc := make(chan error, threads)
+	for i := 0; i < threads; i++ {
+		go func() {
+			var ackErr error
+			for l := range requestc {
+				req, err := c.UnmarshalRequest(l.Request)
+				if err == nil {
+					err = req.DoContext(ctx)
+				}
+				if err != nil && ctx.Err() != nil {
+					// left for the lease to expire
+					continue
+				}
+				// Failed requests are acknowledged as well, like in a
+				// queue.Queue: the errors are handled by the callbacks.
+				if err := w.ack(ctx, []uint64{l.ID}); err != nil && ackErr == nil {
+					ackErr = err
+				}
+			}
+			errc <- ackErr
+		}()
+	}
+	for _, l := range requests {
+		requestc <- l
+	}
+	close(requestc)
+	var err error
+	for i := 0; i < threads; i++ {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+func (w *Worker) lease(ctx context.Context) (*leaseResponse, error) {
+	size := w.BatchSize
+	if size <= 0 {
+		size = w.Threads
+	}
+	resp := &leaseResponse{}
+	return resp, w.post(ctx, "/lease", &leaseRequest{Max: size}, resp)
+}
+
+// ack acknowledges the requests ids and sends the pending requests. The
+// pending requests are kept for the next call if it fails.
+func (w *Worker) ack(ctx context.Context, ids []uint64) error {
+	w.lock.Lock()
+	pending := w.pending
+	w.pending = nil
+	w.lock.Unlock()
+	if len(ids) == 0 && len(pending) == 0 {
+		return nil
+	}
+	err := w.post(ctx, "/ack", &ackRequest{IDs: ids, Requests: pending}, nil)
+	if err != nil {
+		w.lock.Lock()
+		w.pending = append(pending, w.pending...)
+		w.lock.Unlock()
+	}
+	return err
+}
+
+func (w *Worker) post(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Coordinator+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Coordinator responded %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (w *Worker) sleep(ctx context.Context) error {
+	d := w.PollInterval
+	if d <= 0 {
+		d = DefaultPollInterval
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"net"
+	"path"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// SiteDomainPrefix marks AllowedDomains and DisallowedDomains patterns
+// matching every host of the same registrable domain, e.g.
+// "site:www.example.co.uk" matches "example.co.uk" and "shop.example.co.uk",
+// but not "other.co.uk".
+const SiteDomainPrefix = "site:"
+
+// MatchDomain reports whether the host matches the domain pattern.
+// Patterns and hosts are compared case-insensitively and ignoring ports.
+// A pattern can be
+//
+//   - a host name, matching only that host: "example.com"
+//   - a suffix starting with a dot, matching the domain and all of its
+//     subdomains: ".example.com"
+//   - a glob with the syntax of path.Match, whose "*" also matches dots:
+//     "*.gov.cn", "shop-?.example.com"
+//   - a host prefixed with SiteDomainPrefix, matching the hosts of the
+//     same registrable domain according to the public suffix list
+func MatchDomain(pattern, host string) bool {
+	host = normalizeHost(host)
+	if host == "" {
+		return false
+	}
+	if strings.HasPrefix(pattern, SiteDomainPrefix) {
+		site, err := registrableDomain(normalizeHost(pattern[len(SiteDomainPrefix):]))
+		if err != nil {
+			return false
+		}
+		domain, err := registrableDomain(host)
+		return err == nil && domain == site
+	}
+	pattern = normalizeHost(pattern)
+	switch {
+	case pattern == "":
+		return false
+	case strings.ContainsAny(pattern, "*?["):
+		ok, err := path.Match(pattern, host)
+		return err == nil && ok
+	case pattern[0] == '.':
+		return host == pattern[1:] || strings.HasSuffix(host, pattern)
+	}
+	return host == pattern
+}
+
+func (c *Collector) isDomainAllowed(domain string) bool {
+	for _, d2 := range c.DisallowedDomains {
+		if MatchDomain(d2, domain) {
+			return false
+		}
+	}
+	if c.AllowedDomains == nil || len(c.AllowedDomains) == 0 {
+		return true
+	}
+	for _, d2 := range c.AllowedDomains {
+		if MatchDomain(d2, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost lowercases h and strips its port, IPv6 brackets and
+// trailing dot.
+func normalizeHost(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	if host, _, err := net.SplitHostPort(h); err == nil {
+		h = host
+	}
+	h = strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+	return strings.TrimSuffix(h, ".")
+}
+
+// registrableDomain returns the eTLD+1 of host. IP addresses and hosts
+// without a public suffix, like "localhost", are their own registrable
+// domain.
+func registrableDomain(host string) (string, error) {
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return host, nil
+	}
+	return publicsuffix.EffectiveTLDPlusOne(host)
+}
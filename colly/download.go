@@ -0,0 +1,203 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrChecksumMismatch is the error returned when a downloaded file
+	// doesn't match Download.Checksum
+	ErrChecksumMismatch = errors.New("Checksum mismatch")
+	// ErrBodyTruncated is the error returned when a download is cut by
+	// MaxBodySize
+	ErrBodyTruncated = errors.New("Body truncated by MaxBodySize")
+)
+
+// partSuffix is appended to the path of a download until it's complete.
+const partSuffix = ".part"
+
+// Download describes a file downloaded by Collector.Download.
+type Download struct {
+	// Path is the file the body is saved to. The body is written to
+	// Path+".part" until it's complete and verified, and a download
+	// finding this file resumes it with a Range request
+	Path string
+	// Checksum is the expected hash of the file, not verified if nil
+	Checksum []byte
+	// Hash creates the hash compared to Checksum, sha256.New if nil
+	Hash func() hash.Hash
+	// Progress is called after each write with the number of bytes of the
+	// file written so far and the size of the file, -1 if unknown
+	Progress func(written, total int64)
+}
+
+// Download fetches URL to the file at d.Path, streaming the body to disk
+// in place of the OnResponseStream callbacks. The download goes through
+// the usual OnRequest, OnResponse, OnScraped and OnError callbacks, with an
+// empty body. Downloads aren't checked against visited requests.
+//
+// A download interrupted by an error, a canceled context or MaxBodySize
+// leaves a partial file, which the next Download of the same path resumes
+// from its end, if the server supports Range requests. The download
+// starts over otherwise, and a partial file which can't be resumed because
+// the server answered 416 Range Not Satisfiable is removed.
+//
+// With an Async Collector, Download returns once the request is started,
+// and errors are only passed to OnError callbacks.
+func (c *Collector) Download(URL string, d *Download) error {
+	return c.DownloadContext(context.Background(), URL, d)
+}
+
+// DownloadContext is like Download, but the request is bound to ctx as
+// described in VisitContext.
+func (c *Collector) DownloadContext(ctx context.Context, URL string, d *Download) error {
+	hdr := http.Header{}
+	var offset int64
+	if fi, err := os.Stat(d.Path + partSuffix); err == nil && fi.Size() > 0 {
+		offset = fi.Size()
+		hdr.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	ctx = context.WithValue(ctx, streamCallbackKey{}, ResponseStreamCallback(func(r *Response, body io.Reader) error {
+		return d.save(r, body, offset)
+	}))
+	return c.scrape(ctx, URL, "GET", 1, nil, nil, hdr, false)
+}
+
+// save writes body to the partial file of d, from the start of the range
+// of r, and moves it to d.Path once complete. offset is the size of the
+// partial file when the range was requested.
+func (d *Download) save(r *Response, body io.Reader, offset int64) error {
+	part := d.Path + partSuffix
+	var start, total int64 = 0, -1
+	switch r.StatusCode {
+	case http.StatusOK:
+		if r.Headers.Get("Content-Encoding") == "" {
+			if l, err := strconv.ParseInt(r.Headers.Get("Content-Length"), 10, 64); err == nil {
+				total = l
+			}
+		}
+	case http.StatusPartialContent:
+		var ok bool
+		start, total, ok = parseContentRange(r.Headers.Get("Content-Range"))
+		if !ok || start > offset {
+			return fmt.Errorf("Invalid Content-Range %q", r.Headers.Get("Content-Range"))
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file doesn't match the resource anymore.
+		return os.Remove(part)
+	default:
+		return nil
+	}
+
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// A retried request rewrites the range written by the failed attempt.
+	if err := f.Truncate(start); err != nil {
+		return err
+	}
+	var h hash.Hash
+	if d.Checksum != nil {
+		h = sha256.New()
+		if d.Hash != nil {
+			h = d.Hash()
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	w := &progressWriter{w: f, h: h, written: start, total: total, progress: d.Progress}
+	if _, err := io.Copy(w, body); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if r.Truncated {
+		return ErrBodyTruncated
+	}
+	if h != nil && !bytes.Equal(h.Sum(nil), d.Checksum) {
+		os.Remove(part)
+		return ErrChecksumMismatch
+	}
+	return os.Rename(part, d.Path)
+}
+
+// parseContentRange parses the value of a Content-Range header of a 206
+// response, "bytes start-end/total" where total may be "*".
+func parseContentRange(v string) (start, total int64, ok bool) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, false
+	}
+	rng, size, found := strings.Cut(v[len("bytes "):], "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// progressWriter writes to a file and a hash, reporting the progress of a
+// download.
+type progressWriter struct {
+	w        io.Writer
+	h        hash.Hash
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if p.h != nil {
+		p.h.Write(b[:n])
+	}
+	p.written += int64(n)
+	if p.progress != nil && n > 0 {
+		p.progress(p.written, p.total)
+	}
+	return n, err
+}
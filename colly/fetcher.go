@@ -0,0 +1,58 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"net/http"
+)
+
+// Fetcher retrieves the responses of a Collector's requests. The default
+// Fetcher sends them with the Collector's http.Client; others may, for
+// example, render pages in a browser. LimitRules are applied before Fetch is
+// called, and the returned Response goes through the usual OnResponse, OnHTML,
+// OnXML and OnScraped callbacks.
+type Fetcher interface {
+	// Fetch retrieves the response to request. The body must be limited to
+	// bodySize bytes unless bodySize is 0. checkHeadersFunc must be called
+	// once the status code and headers are known, and ErrAbortedAfterHeaders
+	// returned if it reports false. If the response comes from another URL
+	// because of redirects, *request should be updated to the last request.
+	Fetch(request *http.Request, bodySize int, checkHeadersFunc CheckHeadersFunc) (*Response, error)
+}
+
+// FetcherFunc is an adapter to use an ordinary function as a Fetcher.
+type FetcherFunc func(request *http.Request, bodySize int, checkHeadersFunc CheckHeadersFunc) (*Response, error)
+
+// Fetch calls f(request, bodySize, checkHeadersFunc).
+func (f FetcherFunc) Fetch(request *http.Request, bodySize int, checkHeadersFunc CheckHeadersFunc) (*Response, error) {
+	return f(request, bodySize, checkHeadersFunc)
+}
+
+// SetFetcher sets the Fetcher used by requests that don't choose one in
+// Request.Fetcher. A nil Fetcher restores the default HTTP fetcher.
+func (c *Collector) SetFetcher(f Fetcher) {
+	c.fetcher = f
+}
+
+// fetcherFor returns the Fetcher retrieving request.
+func (c *Collector) fetcherFor(request *Request) Fetcher {
+	if request.Fetcher != nil {
+		return request.Fetcher
+	}
+	if c.fetcher != nil {
+		return c.fetcher
+	}
+	return c.backend
+}
@@ -0,0 +1,268 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultTrackingParams are the query parameters of analytics and ad
+// click tracking, removed by DefaultCanonicalizer.
+var DefaultTrackingParams = []string{
+	"utm_*",
+	"gclid",
+	"dclid",
+	"fbclid",
+	"msclkid",
+	"yclid",
+	"mc_cid",
+	"mc_eid",
+	"_ga",
+	"_gl",
+}
+
+// DefaultCanonicalizer is the Canonicalizer of the default Fingerprinter.
+var DefaultCanonicalizer = &Canonicalizer{RemoveParams: DefaultTrackingParams}
+
+// Canonicalizer rewrites URLs so that URLs of the same resource are equal.
+// Schemes and hosts are lowercased, default ports are removed and empty
+// paths become "/". By default fragments are removed and query parameters
+// are sorted by name.
+type Canonicalizer struct {
+	// KeepFragment keeps the fragments of URLs
+	KeepFragment bool
+	// KeepQueryOrder keeps the order of query parameters
+	KeepQueryOrder bool
+	// RemoveParams lists the names of removed query parameters, names
+	// ending with "*" match all the parameters starting with them
+	RemoveParams []string
+}
+
+// Canonicalize returns the canonical form of u, which isn't modified.
+func (c *Canonicalizer) Canonicalize(u *url.URL) *url.URL {
+	cu := *u
+	cu.Scheme = strings.ToLower(cu.Scheme)
+	cu.Host = strings.ToLower(cu.Host)
+	if port := cu.Port(); (cu.Scheme == "http" && port == "80") || (cu.Scheme == "https" && port == "443") {
+		cu.Host = strings.TrimSuffix(cu.Host, ":"+port)
+	}
+	if cu.Path == "" && cu.Opaque == "" && cu.Host != "" {
+		cu.Path = "/"
+		cu.RawPath = ""
+	}
+	if !c.KeepFragment {
+		cu.Fragment = ""
+		cu.RawFragment = ""
+	}
+	cu.RawQuery = c.canonicalQuery(cu.RawQuery)
+	if cu.RawQuery == "" {
+		cu.ForceQuery = false
+	}
+	return &cu
+}
+
+// CanonicalizeString returns the canonical form of the URL u.
+func (c *Canonicalizer) CanonicalizeString(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	return c.Canonicalize(parsed).String(), nil
+}
+
+// canonicalQuery removes and sorts the parameters of a raw query. Their
+// encoding is left untouched.
+func (c *Canonicalizer) canonicalQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+	params := strings.Split(query, "&")
+	kept := params[:0]
+	for _, p := range params {
+		if p == "" || c.removed(paramName(p)) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if !c.KeepQueryOrder {
+		// Values of repeated parameters keep their order.
+		sort.SliceStable(kept, func(i, j int) bool {
+			return paramName(kept[i]) < paramName(kept[j])
+		})
+	}
+	return strings.Join(kept, "&")
+}
+
+func (c *Canonicalizer) removed(name string) bool {
+	for _, r := range c.RemoveParams {
+		if strings.HasSuffix(r, "*") {
+			if strings.HasPrefix(name, r[:len(r)-1]) {
+				return true
+			}
+		} else if name == r {
+			return true
+		}
+	}
+	return false
+}
+
+func paramName(param string) string {
+	name := param
+	if i := strings.IndexByte(param, '='); i >= 0 {
+		name = param[:i]
+	}
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// Fingerprinter computes the fingerprints identifying requests in the
+// storage of visited requests. The body is nil for GET requests.
+type Fingerprinter interface {
+	Fingerprint(method string, u *url.URL, header http.Header, body []byte) uint64
+}
+
+// FingerprinterFunc is an adapter to use ordinary functions as
+// Fingerprinters.
+type FingerprinterFunc func(method string, u *url.URL, header http.Header, body []byte) uint64
+
+// Fingerprint implements Fingerprinter by calling f.
+func (f FingerprinterFunc) Fingerprint(method string, u *url.URL, header http.Header, body []byte) uint64 {
+	return f(method, u, header, body)
+}
+
+// RequestFingerprinter is a Fingerprinter hashing the method, the
+// canonical URL, the selected headers and the body of requests with FNV-1a.
+type RequestFingerprinter struct {
+	// Canonicalizer rewrites the URLs before hashing, URLs are used
+	// unchanged if it's nil
+	Canonicalizer *Canonicalizer
+	// Headers lists the headers distinguishing requests, like
+	// "Accept-Language"
+	Headers []string
+}
+
+// DefaultFingerprinter is the Fingerprinter of Collectors created without
+// the Fingerprints option.
+//
+// Its fingerprints differ from the ones of earlier versions, which hashed
+// the URLs as given: requests recorded in a persistent Storage by those
+// versions aren't found anymore. Use LegacyFingerprinter to keep using
+// such a Storage.
+var DefaultFingerprinter = &RequestFingerprinter{Canonicalizer: DefaultCanonicalizer}
+
+// RawFingerprinter is implemented by Fingerprinters hashing the URLs as
+// passed to the Collector rather than parsed. The Collector calls
+// FingerprintRaw instead of Fingerprint when the raw URL is known.
+type RawFingerprinter interface {
+	Fingerprinter
+	FingerprintRaw(method, rawURL string, header http.Header, body []byte) uint64
+}
+
+// LegacyFingerprinter computes the fingerprints of earlier versions: the
+// FNV-1a hash of the URL as passed to Visit, followed by the body for
+// requests other than GET. URLs aren't canonicalized, and methods and
+// headers are ignored.
+var LegacyFingerprinter RawFingerprinter = legacyFingerprinter{}
+
+type legacyFingerprinter struct{}
+
+// Fingerprint implements Fingerprinter. It hashes u.String(), which differs
+// from the raw URL if parsing re-encoded it.
+func (f legacyFingerprinter) Fingerprint(method string, u *url.URL, header http.Header, body []byte) uint64 {
+	return f.FingerprintRaw(method, u.String(), header, body)
+}
+
+// FingerprintRaw implements RawFingerprinter.
+func (legacyFingerprinter) FingerprintRaw(method, rawURL string, header http.Header, body []byte) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, rawURL)
+	if method != "GET" {
+		h.Write(body)
+	}
+	return h.Sum64()
+}
+
+// Fingerprint implements Fingerprinter.Fingerprint() function
+func (f *RequestFingerprinter) Fingerprint(method string, u *url.URL, header http.Header, body []byte) uint64 {
+	if f.Canonicalizer != nil {
+		u = f.Canonicalizer.Canonicalize(u)
+	}
+	h := fnv.New64a()
+	io.WriteString(h, strings.ToUpper(method))
+	h.Write([]byte{0})
+	io.WriteString(h, u.String())
+	for _, name := range f.Headers {
+		h.Write([]byte{0})
+		io.WriteString(h, strings.Join(header.Values(name), ","))
+	}
+	h.Write([]byte{0})
+	h.Write(body)
+	return h.Sum64()
+}
+
+// Fingerprints sets the Fingerprinter of the Collector.
+func Fingerprints(f Fingerprinter) CollectorOption {
+	return func(c *Collector) {
+		c.Fingerprinter = f
+	}
+}
+
+func (c *Collector) fingerprinter() Fingerprinter {
+	if c.Fingerprinter != nil {
+		return c.Fingerprinter
+	}
+	return DefaultFingerprinter
+}
+
+// fingerprint returns the fingerprint of a request to u, parsed from
+// rawURL, with the Fingerprinter of the Collector.
+func (c *Collector) fingerprint(method, rawURL string, u *url.URL, header http.Header, body []byte) uint64 {
+	f := c.fingerprinter()
+	if rf, ok := f.(RawFingerprinter); ok {
+		return rf.FingerprintRaw(method, rawURL, header, body)
+	}
+	return f.Fingerprint(method, u, header, body)
+}
+
+// peekBody returns the content of body along with a reader of the same
+// content to send instead of body. In-memory readers aren't consumed and
+// are returned as is.
+func peekBody(body io.Reader) ([]byte, io.Reader, error) {
+	switch v := body.(type) {
+	case *bytes.Buffer:
+		return v.Bytes(), v, nil
+	case *bytes.Reader:
+		snapshot := *v
+		b, err := io.ReadAll(&snapshot)
+		return b, v, err
+	case *strings.Reader:
+		snapshot := *v
+		b, err := io.ReadAll(&snapshot)
+		return b, v, err
+	}
+	b, err := io.ReadAll(body)
+	if c, ok := body.(io.Closer); ok {
+		c.Close()
+	}
+	return b, bytes.NewReader(b), err
+}
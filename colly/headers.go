@@ -0,0 +1,120 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HeaderProfile is a set of default request headers, such as the ones a
+// given browser sends. Headers set by the caller, by OnRequest callbacks
+// or by Collector.UserAgent always take precedence over the profile.
+// Accept-Encoding is left to the HTTP backend, which decodes the
+// responses.
+type HeaderProfile struct {
+	// Name identifies the profile, e.g. in the HEADER_PROFILE environment variable
+	Name string
+	// UserAgent is sent by requests which set no User-Agent, unless
+	// Collector.UserAgent is set
+	UserAgent string
+	// Headers are added to requests which don't set them. Client hints
+	// (Sec-CH-UA*) describe UserAgent and are left out of requests sent
+	// with another User-Agent.
+	Headers http.Header
+}
+
+// Built-in header profiles of current desktop browsers.
+var (
+	// ChromeHeaders are the headers of Chrome on Windows navigating to a page
+	ChromeHeaders = &HeaderProfile{
+		Name:      "chrome",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36",
+		Headers: http.Header{
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+			"Accept-Language":           {"en-US,en;q=0.9"},
+			"Sec-Ch-Ua":                 {`"Google Chrome";v="135", "Not-A.Brand";v="8", "Chromium";v="135"`},
+			"Sec-Ch-Ua-Mobile":          {"?0"},
+			"Sec-Ch-Ua-Platform":        {`"Windows"`},
+			"Sec-Fetch-Dest":            {"document"},
+			"Sec-Fetch-Mode":            {"navigate"},
+			"Sec-Fetch-Site":            {"none"},
+			"Sec-Fetch-User":            {"?1"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+	}
+	// FirefoxHeaders are the headers of Firefox on Windows navigating to a page
+	FirefoxHeaders = &HeaderProfile{
+		Name:      "firefox",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:137.0) Gecko/20100101 Firefox/137.0",
+		Headers: http.Header{
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"Accept-Language":           {"en-US,en;q=0.5"},
+			"Sec-Fetch-Dest":            {"document"},
+			"Sec-Fetch-Mode":            {"navigate"},
+			"Sec-Fetch-Site":            {"none"},
+			"Sec-Fetch-User":            {"?1"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+	}
+	// SafariHeaders are the headers of Safari on macOS navigating to a page
+	SafariHeaders = &HeaderProfile{
+		Name:      "safari",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.4 Safari/605.1.15",
+		Headers: http.Header{
+			"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"Accept-Language": {"en-US,en;q=0.9"},
+			"Sec-Fetch-Dest":  {"document"},
+			"Sec-Fetch-Mode":  {"navigate"},
+			"Sec-Fetch-Site":  {"none"},
+		},
+	}
+)
+
+// HeaderProfiles are the built-in header profiles by name.
+var HeaderProfiles = map[string]*HeaderProfile{
+	ChromeHeaders.Name:  ChromeHeaders,
+	FirefoxHeaders.Name: FirefoxHeaders,
+	SafariHeaders.Name:  SafariHeaders,
+}
+
+// DefaultHeaders sets the header profile the Collector's requests default to.
+func DefaultHeaders(p *HeaderProfile) CollectorOption {
+	return func(c *Collector) {
+		c.HeaderProfile = p
+	}
+}
+
+// userAgent returns the User-Agent requests are sent with by default.
+func (c *Collector) userAgent() string {
+	if c.UserAgent != "" || c.HeaderProfile == nil {
+		return c.UserAgent
+	}
+	return c.HeaderProfile.UserAgent
+}
+
+// apply adds the headers of the profile which are missing from h.
+func (p *HeaderProfile) apply(h http.Header) {
+	matchingUA := h.Get("User-Agent") == p.UserAgent
+	for k, v := range p.Headers {
+		if _, ok := h[k]; ok {
+			continue
+		}
+		if !matchingUA && strings.HasPrefix(k, "Sec-Ch-Ua") {
+			continue
+		}
+		h[k] = append([]string(nil), v...)
+	}
+}
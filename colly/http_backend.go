@@ -15,23 +15,16 @@
 package colly
 
 import (
-	"crypto/sha1"
-	"encoding/gob"
-	"encoding/hex"
-	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
-	"os"
-	"path"
 	"regexp"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
-	"compress/gzip"
-
 	"github.com/gobwas/glob"
+	"xrmcp/colly/cache"
 )
 
 type httpBackend struct {
@@ -40,7 +33,9 @@ type httpBackend struct {
 	lock       *sync.RWMutex
 }
 
-type checkHeadersFunc func(statusCode int, header http.Header) bool
+// CheckHeadersFunc is called by a Fetcher once the status code and headers
+// of a response are known. The body must not be read if it returns false.
+type CheckHeadersFunc func(statusCode int, header http.Header) bool
 
 // LimitRule provides connection restrictions for domains.
 // Both DomainRegexp and DomainGlob can be used to specify
@@ -129,57 +124,111 @@ func (h *httpBackend) GetMatchingRule(domain string) *LimitRule {
 	return nil
 }
 
-func (h *httpBackend) Cache(request *http.Request, bodySize int, checkHeadersFunc checkHeadersFunc, cacheDir string) (*Response, error) {
-	if cacheDir == "" || request.Method != "GET" {
-		return h.Do(request, bodySize, checkHeadersFunc)
-	}
-	sum := sha1.Sum([]byte(request.URL.String()))
-	hash := hex.EncodeToString(sum[:])
-	dir := path.Join(cacheDir, hash[:2])
-	filename := path.Join(dir, hash)
-	if file, err := os.Open(filename); err == nil {
-		resp := new(Response)
-		err := gob.NewDecoder(file).Decode(resp)
-		file.Close()
-		if resp.StatusCode < 500 {
-			return resp, err
-		}
+// Cache fetches request with fetcher through httpCache, which may answer
+// it or have it revalidated. Streamed responses and responses of other
+// fetchers than the HTTP backend aren't cached.
+func (h *httpBackend) Cache(fetcher Fetcher, request *http.Request, bodySize int, checkHeadersFunc CheckHeadersFunc, httpCache *cache.Cache) (*Response, error) {
+	if fetcher == Fetcher(h) {
+		// before the lookup, as responses may vary by Accept-Encoding
+		setAcceptEncoding(request)
 	}
-	resp, err := h.Do(request, bodySize, checkHeadersFunc)
-	if err != nil || resp.StatusCode >= 500 {
-		return resp, err
+	if _, stream := request.Context().Value(bodyStreamKey{}).(bodyStream); httpCache == nil || fetcher != Fetcher(h) || stream {
+		return h.Do(fetcher, request, bodySize, checkHeadersFunc)
+	}
+	// Fetchers replace request by the last one of redirects, while
+	// responses are cached for the original one.
+	orig := *request
+	entry, err := httpCache.Lookup(&orig)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := os.Stat(dir); err != nil {
-		if err := os.MkdirAll(dir, 0750); err != nil {
-			return resp, err
+	if entry != nil && httpCache.Fresh(&orig, entry) {
+		return cachedResponse(httpCache, entry, checkHeadersFunc)
+	}
+	check := checkHeadersFunc
+	if entry != nil {
+		request.Header = request.Header.Clone()
+		cache.AddConditions(request, entry)
+		check = func(statusCode int, header http.Header) bool {
+			if statusCode == http.StatusNotModified {
+				return checkHeadersFunc(entry.StatusCode, cache.Merge(entry, header))
+			}
+			return checkHeadersFunc(statusCode, header)
 		}
 	}
-	file, err := os.Create(filename + "~")
+
+	requestTime := time.Now()
+	resp, err := h.Do(fetcher, request, bodySize, check)
 	if err != nil {
 		return resp, err
 	}
-	if err := gob.NewEncoder(file).Encode(resp); err != nil {
-		file.Close()
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		entry, err = httpCache.Revalidated(&orig, entry, *resp.Headers, requestTime, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return cachedResponse(httpCache, entry, nil)
+	}
+	if resp.Truncated {
+		// a body cut by MaxBodySize would be served as complete
+		return resp, nil
+	}
+	if err := httpCache.Invalidate(&orig, resp.StatusCode); err != nil {
 		return resp, err
 	}
-	file.Close()
-	return resp, os.Rename(filename+"~", filename)
+	return resp, httpCache.Put(&orig, resp.StatusCode, *resp.Headers, resp.Body, requestTime, time.Now())
 }
 
-func (h *httpBackend) Do(request *http.Request, bodySize int, checkHeadersFunc checkHeadersFunc) (*Response, error) {
+// cachedResponse returns the response held by entry. checkHeadersFunc is
+// called with its headers unless nil.
+func cachedResponse(httpCache *cache.Cache, entry *cache.Entry, checkHeadersFunc CheckHeadersFunc) (*Response, error) {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(httpCache.Age(entry).Seconds())))
+	if checkHeadersFunc != nil && !checkHeadersFunc(entry.StatusCode, header) {
+		return nil, ErrAbortedAfterHeaders
+	}
+	return &Response{
+		StatusCode: entry.StatusCode,
+		Body:       entry.Body,
+		Headers:    &header,
+	}, nil
+}
+
+// Do fetches request with fetcher once the LimitRule matching its host
+// allows it.
+func (h *httpBackend) Do(fetcher Fetcher, request *http.Request, bodySize int, checkHeadersFunc CheckHeadersFunc) (*Response, error) {
+	// Fetchers replace request by the last one of redirects, which may
+	// carry its own context.
+	ctx := request.Context()
 	r := h.GetMatchingRule(request.URL.Host)
 	if r != nil {
-		r.waitChan <- true
+		select {
+		case r.waitChan <- true:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 		defer func(r *LimitRule) {
 			randomDelay := time.Duration(0)
 			if r.RandomDelay != 0 {
 				randomDelay = time.Duration(rand.Int63n(int64(r.RandomDelay)))
 			}
-			time.Sleep(r.Delay + randomDelay)
+			t := time.NewTimer(r.Delay + randomDelay)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+			}
 			<-r.waitChan
 		}(r)
 	}
+	return fetcher.Fetch(request, bodySize, checkHeadersFunc)
+}
 
+// Fetch implements Fetcher with the http.Client of the backend.
+// Responses are decoded from the content codings advertised in the
+// Accept-Encoding header set on requests without one.
+func (h *httpBackend) Fetch(request *http.Request, bodySize int, checkHeadersFunc CheckHeadersFunc) (*Response, error) {
+	setAcceptEncoding(request)
 	res, err := h.Client.Do(request)
 	if err != nil {
 		return nil, err
@@ -194,27 +243,28 @@ func (h *httpBackend) Do(request *http.Request, bodySize int, checkHeadersFunc c
 		return nil, ErrAbortedAfterHeaders
 	}
 
-	var bodyReader io.Reader = res.Body
+	response := &Response{
+		StatusCode: res.StatusCode,
+		Headers:    &res.Header,
+	}
+	// MaxBodySize applies to the decoded body, so that it bounds the
+	// memory used by decompression bombs as well.
+	bodyReader, release, err := decodeBody(res)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	if bodySize > 0 {
-		bodyReader = io.LimitReader(bodyReader, int64(bodySize))
+		bodyReader = &limitedReader{r: bodyReader, n: int64(bodySize), response: response}
 	}
-	contentEncoding := strings.ToLower(res.Header.Get("Content-Encoding"))
-	if !res.Uncompressed && (strings.Contains(contentEncoding, "gzip") || (contentEncoding == "" && strings.Contains(strings.ToLower(res.Header.Get("Content-Type")), "gzip")) || strings.HasSuffix(strings.ToLower(request.URL.Path), ".xml.gz")) {
-		bodyReader, err = gzip.NewReader(bodyReader)
-		if err != nil {
-			return nil, err
-		}
-		defer bodyReader.(*gzip.Reader).Close()
+	if stream, ok := request.Context().Value(bodyStreamKey{}).(bodyStream); ok {
+		return response, stream(response, bodyReader)
 	}
-	body, err := ioutil.ReadAll(bodyReader)
+	response.Body, err = ioutil.ReadAll(bodyReader)
 	if err != nil {
 		return nil, err
 	}
-	return &Response{
-		StatusCode: res.StatusCode,
-		Body:       body,
-		Headers:    &res.Header,
-	}, nil
+	return response, nil
 }
 
 func (h *httpBackend) Limit(rule *LimitRule) error {
@@ -227,6 +277,27 @@ func (h *httpBackend) Limit(rule *LimitRule) error {
 	return rule.Init()
 }
 
+// replaceRule replaces the old rule with the initialized rule r. A nil old
+// rule adds r in front of the others so it takes precedence, a nil r
+// removes old.
+func (h *httpBackend) replaceRule(old, r *LimitRule) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, r2 := range h.LimitRules {
+		if old != nil && r2 == old {
+			if r == nil {
+				h.LimitRules = append(h.LimitRules[:i:i], h.LimitRules[i+1:]...)
+			} else {
+				h.LimitRules[i] = r
+			}
+			return
+		}
+	}
+	if r != nil {
+		h.LimitRules = append([]*LimitRule{r}, h.LimitRules...)
+	}
+}
+
 func (h *httpBackend) Limits(rules []*LimitRule) error {
 	for _, r := range rules {
 		if err := h.Limit(r); err != nil {
@@ -1,14 +1,23 @@
 package queue
 
 import (
+	"container/heap"
+	"context"
+	"errors"
 	"net/url"
+	"sort"
 	"sync"
+	"time"
 
 	"xrmcp/colly"
 )
 
 const stop = true
 
+// ErrHostStorageRequired is the error returned when a Queue uses
+// priorities or host scheduling with a Storage which can't honour them
+var ErrHostStorageRequired = errors.New("Queue storage doesn't implement HostStorage")
+
 // Storage is the interface of the queue's storage backend
 // Storage must be concurrently safe for multiple goroutines.
 type Storage interface {
@@ -23,31 +32,61 @@ type Storage interface {
 	QueueSize() (int, error)
 }
 
+// ResumableStorage is a Storage keeping the requests taken by a run until
+// they are done, so that a run stopped by a crash or a canceled context
+// can be resumed. Queue.Run takes its requests with TakeRequest instead of
+// GetRequest when the Storage implements it.
+type ResumableStorage interface {
+	Storage
+	// TakeRequest marks the next request as taken and returns it with its
+	// ID, or a nil request if the queue is empty. Taken requests aren't
+	// counted by QueueSize.
+	TakeRequest() (uint64, []byte, error)
+	// Ack removes the taken request with the given ID from the queue
+	Ack(id uint64) error
+	// Resume puts the taken requests which weren't acknowledged back in
+	// the queue, at their original position
+	Resume() error
+}
+
 // Queue is a request queue which uses a Collector to consume
 // requests in multiple threads
 type Queue struct {
 	// Threads defines the number of consumer threads
 	Threads int
-	storage Storage
-	wake    chan struct{}
-	mut     sync.Mutex // guards wake
+	// Priority computes the priorities of the requests added to a
+	// HostStorage, ContextPriority if nil. Setting it with another Storage
+	// makes AddRequest return ErrHostStorageRequired
+	Priority PriorityFunc
+	// RoundRobin serves the hosts of a HostStorage in turn, instead of
+	// taking the requests by priority then in the order they were added
+	RoundRobin bool
+	// HostDelay is the politeness delay between the end of a request of a
+	// host and the start of the next one, which implies RoundRobin.
+	// Requests of other hosts are run in the meantime.
+	HostDelay time.Duration
+	storage   Storage
+	wake      chan struct{}
+	mut       sync.Mutex // guards wake
 }
 
 // InMemoryQueueStorage is the default implementation of the Storage interface.
-// InMemoryQueueStorage holds the request queue in memory.
+// InMemoryQueueStorage holds the request queue in memory, in a priority
+// queue per host.
 type InMemoryQueueStorage struct {
 	// MaxSize defines the capacity of the queue.
 	// New requests are discarded if the queue size reaches MaxSize
 	MaxSize int
 	lock    *sync.RWMutex
 	size    int
-	first   *inMemoryQueueItem
-	last    *inMemoryQueueItem
+	seq     uint64
+	hosts   map[string]*inMemoryHostQueue
 }
 
 type inMemoryQueueItem struct {
-	Request []byte
-	Next    *inMemoryQueueItem
+	Request  []byte
+	priority int
+	seq      uint64
 }
 
 // New creates a new queue with a Storage specified in argument
@@ -81,26 +120,23 @@ func (q *Queue) AddURL(URL string) error {
 		URL:    u,
 		Method: "GET",
 	}
-	d, err := r.Marshal()
-	if err != nil {
-		return err
-	}
-	return q.storage.AddRequest(d)
+	return q.storeRequest(r)
 }
 
 // AddRequest adds a new Request to the queue
 func (q *Queue) AddRequest(r *colly.Request) error {
 	q.mut.Lock()
-	waken := q.wake != nil
+	wake := q.wake
 	q.mut.Unlock()
-	if !waken {
-		return q.storeRequest(r)
-	}
 	err := q.storeRequest(r)
-	if err != nil {
+	if err != nil || wake == nil {
 		return err
 	}
-	q.wake <- struct{}{}
+	select {
+	case wake <- struct{}{}:
+	default:
+		// the consumer loop has a pending wake-up already
+	}
 	return nil
 }
 
@@ -109,7 +145,18 @@ func (q *Queue) storeRequest(r *colly.Request) error {
 	if err != nil {
 		return err
 	}
-	return q.storage.AddRequest(d)
+	hs, ok := q.storage.(HostStorage)
+	if !ok {
+		if q.Priority != nil {
+			return ErrHostStorageRequired
+		}
+		return q.storage.AddRequest(d)
+	}
+	priority := q.Priority
+	if priority == nil {
+		priority = ContextPriority
+	}
+	return hs.AddHostRequest(r.URL.Host, priority(r), d)
 }
 
 // Size returns the size of the queue
@@ -121,27 +168,71 @@ func (q *Queue) Size() (int, error) {
 // to perform requests. Run blocks while the queue has active requests
 // The given Storage must not be used directly while Run blocks.
 func (q *Queue) Run(c *colly.Collector) error {
+	return q.RunContext(context.Background(), c)
+}
+
+// RunContext is like Run, but shuts down once ctx is done: no further
+// request is taken from the queue, the requests in flight are aborted
+// as described in colly.Collector.VisitContext and RunContext returns the
+// error of ctx once their callbacks have returned. The requests left in
+// the queue can be consumed by a later run.
+//
+// With a ResumableStorage, the requests which were taken but not done by
+// a previous run, because it crashed or was aborted, are run first.
+//
+// RoundRobin and HostDelay need a HostStorage, which must be a
+// ResumableHostStorage if it's a ResumableStorage: RunContext returns
+// ErrHostStorageRequired otherwise.
+func (q *Queue) RunContext(ctx context.Context, c *colly.Collector) error {
+	if q.roundRobin() {
+		_, host := q.storage.(HostStorage)
+		_, resumable := q.storage.(ResumableStorage)
+		_, resumableHost := q.storage.(ResumableHostStorage)
+		if !host || resumable && !resumableHost {
+			return ErrHostStorageRequired
+		}
+	}
 	q.mut.Lock()
 	if q.wake != nil {
 		q.mut.Unlock()
 		panic("cannot call duplicate Queue.Run")
 	}
-	q.wake = make(chan struct{})
+	q.wake = make(chan struct{}, 1)
 	q.mut.Unlock()
+	defer func() {
+		q.mut.Lock()
+		q.wake = nil
+		q.mut.Unlock()
+	}()
+	if rs, ok := q.storage.(ResumableStorage); ok {
+		if err := rs.Resume(); err != nil {
+			return err
+		}
+	}
 
-	requestc := make(chan *colly.Request)
-	complete, errc := make(chan struct{}), make(chan error, 1)
+	requestc := make(chan queuedRequest)
+	complete, errc := make(chan string), make(chan error, 1)
 	for i := 0; i < q.Threads; i++ {
-		go independentRunner(requestc, complete)
+		go q.independentRunner(ctx, requestc, complete)
 	}
-	go q.loop(c, requestc, complete, errc)
+	go q.loop(ctx, c, requestc, complete, errc)
 	defer close(requestc)
 	return <-errc
 }
 
-func (q *Queue) loop(c *colly.Collector, requestc chan<- *colly.Request, complete <-chan struct{}, errc chan<- error) {
+func (q *Queue) loop(ctx context.Context, c *colly.Collector, requestc chan<- queuedRequest, complete <-chan string, errc chan<- error) {
 	var active int
+	sched := newHostScheduler(q.HostDelay)
 	for {
+		if ctx.Err() != nil {
+			// Shut down gracefully: let the requests in flight
+			// finish or abort before returning.
+			for ; active > 0; active-- {
+				<-complete
+			}
+			errc <- ctx.Err()
+			break
+		}
 		size, err := q.storage.QueueSize()
 		if err != nil {
 			errc <- err
@@ -155,14 +246,25 @@ func (q *Queue) loop(c *colly.Collector, requestc chan<- *colly.Request, complet
 			break
 		}
 		sent := requestc
-		var req *colly.Request
+		var req queuedRequest
+		var timer *time.Timer
+		var timeout <-chan time.Time
 		if size > 0 {
-			req, err = q.loadRequest(c)
+			var wait time.Duration
+			req, wait, err = q.nextRequest(c, sched)
 			if err != nil {
 				// ignore an error returned by GetRequest() or
 				// UnmarshalRequest()
 				continue
 			}
+			if req.Request == nil {
+				// every host waits for its politeness delay
+				sent = nil
+				if wait > 0 {
+					timer = time.NewTimer(wait)
+					timeout = timer.C
+				}
+			}
 		} else {
 			sent = nil
 		}
@@ -171,74 +273,176 @@ func (q *Queue) loop(c *colly.Collector, requestc chan<- *colly.Request, complet
 			select {
 			case sent <- req:
 				active++
+				sched.started(req.host)
 				break Sent
 			case <-q.wake:
 				if sent == nil {
 					break Sent
 				}
-			case <-complete:
+			case host := <-complete:
 				active--
-				if sent == nil && active == 0 {
+				sched.done(host, time.Now())
+				if sent == nil && (active == 0 || size > 0) {
 					break Sent
 				}
+			case <-timeout:
+				break Sent
+			case <-ctx.Done():
+				if _, ok := q.storage.(ResumableStorage); sent != nil && !ok {
+					// put the request back for a later run
+					q.storeRequest(req.Request)
+				}
+				break Sent
 			}
 		}
+		if timer != nil {
+			timer.Stop()
+		}
 	}
 }
 
-func independentRunner(requestc <-chan *colly.Request, complete chan<- struct{}) {
+// queuedRequest is a request taken from the queue, with its ID in a
+// ResumableStorage and the sub-queue of a HostStorage it was taken from.
+type queuedRequest struct {
+	*colly.Request
+	id   uint64
+	host string
+}
+
+func (q *Queue) independentRunner(ctx context.Context, requestc <-chan queuedRequest, complete chan<- string) {
 	for req := range requestc {
-		req.Do()
-		complete <- struct{}{}
+		err := req.DoContext(ctx)
+		if rs, ok := q.storage.(ResumableStorage); ok && (err == nil || ctx.Err() == nil) {
+			// Requests aborted by ctx are resumed by the next run.
+			rs.Ack(req.id)
+		}
+		complete <- req.host
 	}
 }
 
-func (q *Queue) loadRequest(c *colly.Collector) (*colly.Request, error) {
-	buf, err := q.storage.GetRequest()
+func (q *Queue) roundRobin() bool {
+	return q.RoundRobin || q.HostDelay > 0
+}
+
+// nextRequest takes the next request to run from the queue. With
+// RoundRobin, it returns a nil request and the time to wait for if no
+// host is ready.
+func (q *Queue) nextRequest(c *colly.Collector, sched *hostScheduler) (queuedRequest, time.Duration, error) {
+	if !q.roundRobin() {
+		req, err := q.loadRequest(c, "", false)
+		return req, 0, err
+	}
+	hosts, err := q.storage.(HostStorage).Hosts()
 	if err != nil {
-		return nil, err
+		return queuedRequest{}, 0, err
+	}
+	host, ready, wait := sched.pick(hosts, time.Now())
+	if !ready {
+		return queuedRequest{}, wait, nil
+	}
+	req, err := q.loadRequest(c, host, true)
+	return req, 0, err
+}
+
+// loadRequest takes the next request of the queue, or of host if byHost
+// is set.
+func (q *Queue) loadRequest(c *colly.Collector, host string, byHost bool) (queuedRequest, error) {
+	var id uint64
+	var buf []byte
+	var err error
+	rs, resumable := q.storage.(ResumableStorage)
+	switch {
+	case byHost && resumable:
+		id, buf, err = q.storage.(ResumableHostStorage).TakeHostRequest(host)
+	case byHost:
+		buf, err = q.storage.(HostStorage).GetHostRequest(host)
+	case resumable:
+		id, buf, err = rs.TakeRequest()
+	default:
+		buf, err = q.storage.GetRequest()
+	}
+	if err != nil {
+		return queuedRequest{}, err
 	}
 	copied := make([]byte, len(buf))
 	copy(copied, buf)
-	return c.UnmarshalRequest(copied)
+	req, err := c.UnmarshalRequest(copied)
+	if err != nil && resumable && buf != nil {
+		// never resume requests which can't be decoded
+		rs.Ack(id)
+	}
+	return queuedRequest{Request: req, id: id, host: host}, err
 }
 
 // Init implements Storage.Init() function
 func (q *InMemoryQueueStorage) Init() error {
 	q.lock = &sync.RWMutex{}
+	q.hosts = make(map[string]*inMemoryHostQueue)
 	return nil
 }
 
 // AddRequest implements Storage.AddRequest() function
 func (q *InMemoryQueueStorage) AddRequest(r []byte) error {
+	return q.AddHostRequest("", 0, r)
+}
+
+// AddHostRequest implements HostStorage.AddHostRequest() function
+func (q *InMemoryQueueStorage) AddHostRequest(host string, priority int, r []byte) error {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 	// Discard URLs if size limit exceeded
 	if q.MaxSize > 0 && q.size >= q.MaxSize {
 		return colly.ErrQueueFull
 	}
-	i := &inMemoryQueueItem{Request: r}
-	if q.first == nil {
-		q.first = i
-	} else {
-		q.last.Next = i
+	hq := q.hosts[host]
+	if hq == nil {
+		hq = &inMemoryHostQueue{}
+		q.hosts[host] = hq
 	}
-	q.last = i
+	q.seq++
+	heap.Push(hq, &inMemoryQueueItem{Request: r, priority: priority, seq: q.seq})
 	q.size++
 	return nil
 }
 
-// GetRequest implements Storage.GetRequest() function
+// GetRequest implements Storage.GetRequest() function. It pops the
+// request of highest priority of all hosts, the oldest one first.
 func (q *InMemoryQueueStorage) GetRequest() ([]byte, error) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
-	if q.size == 0 {
+	var best string
+	var first *inMemoryQueueItem
+	for host, hq := range q.hosts {
+		if i := (*hq)[0]; first == nil || i.before(first) {
+			best, first = host, i
+		}
+	}
+	if first == nil {
 		return nil, nil
 	}
-	r := q.first.Request
-	q.first = q.first.Next
-	q.size--
-	return r, nil
+	return q.popLocked(best), nil
+}
+
+// GetHostRequest implements HostStorage.GetHostRequest() function
+func (q *InMemoryQueueStorage) GetHostRequest(host string) ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.hosts[host] == nil {
+		return nil, nil
+	}
+	return q.popLocked(host), nil
+}
+
+// Hosts implements HostStorage.Hosts() function
+func (q *InMemoryQueueStorage) Hosts() ([]string, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	hosts := make([]string, 0, len(q.hosts))
+	for host := range q.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
 }
 
 // QueueSize implements Storage.QueueSize() function
@@ -247,3 +451,39 @@ func (q *InMemoryQueueStorage) QueueSize() (int, error) {
 	defer q.lock.Unlock()
 	return q.size, nil
 }
+
+func (q *InMemoryQueueStorage) popLocked(host string) []byte {
+	hq := q.hosts[host]
+	i := heap.Pop(hq).(*inMemoryQueueItem)
+	if hq.Len() == 0 {
+		delete(q.hosts, host)
+	}
+	q.size--
+	return i.Request
+}
+
+func (i *inMemoryQueueItem) before(j *inMemoryQueueItem) bool {
+	if i.priority != j.priority {
+		return i.priority > j.priority
+	}
+	return i.seq < j.seq
+}
+
+// inMemoryHostQueue is a heap of the requests of a host.
+type inMemoryHostQueue []*inMemoryQueueItem
+
+func (h inMemoryHostQueue) Len() int           { return len(h) }
+func (h inMemoryHostQueue) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h inMemoryHostQueue) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *inMemoryHostQueue) Push(x interface{}) {
+	*h = append(*h, x.(*inMemoryQueueItem))
+}
+
+func (h *inMemoryHostQueue) Pop() interface{} {
+	old := *h
+	i := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return i
+}
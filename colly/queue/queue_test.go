@@ -1,9 +1,14 @@
 package queue
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -68,6 +73,177 @@ func TestQueue(t *testing.T) {
 	}
 }
 
+func TestQueueRunContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(serverHandler))
+	defer server.Close()
+
+	q, err := New(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		q.AddURL(fmt.Sprintf("%s/delay?t=%s&i=%d", server.URL, 50*time.Millisecond, i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var done uint32
+	c := colly.NewCollector()
+	c.OnScraped(func(resp *colly.Response) {
+		if atomic.AddUint32(&done, 1) == 3 {
+			cancel()
+		}
+	})
+	if err := q.RunContext(ctx, c); err != context.Canceled {
+		t.Fatalf("RunContext() returned %v, want %v", err, context.Canceled)
+	}
+	size, _ := q.Size()
+	if int(done)+size < 18 || size == 0 {
+		t.Errorf("%d requests done and %d left in the queue, want the rest of 20 left", done, size)
+	}
+
+	// The queue can be run again to consume what is left.
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+	if !q.IsEmpty() {
+		t.Error("Queue not consumed by the second run")
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	q, err := New(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL)
+	for i, priority := range []interface{}{nil, 5, "10", -1, 5} {
+		ctx := colly.NewContext()
+		if priority != nil {
+			ctx.Put(PriorityKey, priority)
+		}
+		r := &colly.Request{URL: u.ResolveReference(&url.URL{Path: fmt.Sprintf("/%d", i)}), Method: "GET", Ctx: ctx}
+		if err := q.AddRequest(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var paths []string
+	c := colly.NewCollector(colly.AllowURLRevisit())
+	c.OnRequest(func(r *colly.Request) {
+		paths = append(paths, r.URL.Path)
+	})
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/2", "/1", "/4", "/0", "/3"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Requests run in order %q, want %q", paths, want)
+	}
+
+	r := &colly.Request{Depth: 3, Ctx: colly.NewContext()}
+	if p := DepthPriority(r); p != -3 {
+		t.Errorf("DepthPriority() = %d, want -3", p)
+	}
+}
+
+func TestQueueHostScheduling(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	server1, server2 := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer server1.Close()
+	defer server2.Close()
+
+	const delay = 50 * time.Millisecond
+	q, err := New(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.HostDelay = delay
+	for i := 0; i < 3; i++ {
+		q.AddURL(fmt.Sprintf("%s/%d", server1.URL, i))
+	}
+	for i := 0; i < 3; i++ {
+		q.AddURL(fmt.Sprintf("%s/%d", server2.URL, i))
+	}
+
+	var lock sync.Mutex
+	var hosts []string
+	var last = map[string]time.Time{}
+	c := colly.NewCollector(colly.AllowURLRevisit())
+	c.OnRequest(func(r *colly.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if prev, ok := last[r.URL.Host]; ok && time.Since(prev) < delay {
+			t.Errorf("Politeness delay of %s not respected: %v", r.URL.Host, time.Since(prev))
+		}
+		hosts = append(hosts, r.URL.Host)
+	})
+	c.OnScraped(func(r *colly.Response) {
+		lock.Lock()
+		last[r.Request.URL.Host] = time.Now()
+		lock.Unlock()
+	})
+	start := time.Now()
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+	// Both hosts are crawled concurrently: 2 delays, not 5.
+	if elapsed := time.Since(start); elapsed > 4*delay {
+		t.Errorf("Hosts not crawled concurrently: %v", elapsed)
+	}
+	if len(hosts) != 6 || hosts[0] == hosts[1] {
+		t.Errorf("Hosts not served in turn: %q", hosts)
+	}
+}
+
+func TestQueueFIFO(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	server1, server2 := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer server1.Close()
+	defer server2.Close()
+
+	for _, roundRobin := range []bool{false, true} {
+		q, err := New(1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.RoundRobin = roundRobin
+		for _, u := range []string{server1.URL + "/0", server1.URL + "/1", server2.URL + "/0"} {
+			q.AddURL(u)
+		}
+		var hosts []string
+		c := colly.NewCollector(colly.AllowURLRevisit())
+		c.OnRequest(func(r *colly.Request) {
+			hosts = append(hosts, r.URL.Host)
+		})
+		if err := q.Run(c); err != nil {
+			t.Fatal(err)
+		}
+		if len(hosts) != 3 || (hosts[0] != hosts[1]) != roundRobin {
+			t.Errorf("Hosts run in order %q with RoundRobin %v", hosts, roundRobin)
+		}
+	}
+}
+
+func TestQueueHostStorageRequired(t *testing.T) {
+	q, err := New(1, struct{ Storage }{&InMemoryQueueStorage{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.AddURL("http://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	q.Priority = DepthPriority
+	if err := q.AddURL("http://example.com/"); err != ErrHostStorageRequired {
+		t.Errorf("AddURL() with Priority = %v", err)
+	}
+	q.Priority = nil
+	q.RoundRobin = true
+	if err := q.Run(colly.NewCollector()); err != ErrHostStorageRequired {
+		t.Errorf("Run() with RoundRobin = %v", err)
+	}
+}
+
 func serverHandler(w http.ResponseWriter, req *http.Request) {
 	if !serverRoute(w, req) {
 		shutdown(w)
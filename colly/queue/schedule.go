@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"xrmcp/colly"
+)
+
+// PriorityKey is the key of the priority of a request in its Ctx, read by
+// ContextPriority. It can be an int or a string holding one.
+const PriorityKey = "priority"
+
+// HostStorage is a Storage keeping a sub-queue of requests per host, so
+// that Queue can serve the hosts in turn. Queue only honours request
+// priorities, RoundRobin and HostDelay when its Storage implements it.
+// GetRequest, and TakeRequest for a ResumableStorage, must return the
+// request of highest priority of all hosts, then the oldest one.
+type HostStorage interface {
+	Storage
+	// AddHostRequest adds a serialized request of host to the queue. The
+	// requests of a host are taken by decreasing priority, then in the
+	// order they were added
+	AddHostRequest(host string, priority int, r []byte) error
+	// Hosts returns the hosts having queued requests, sorted
+	Hosts() ([]string, error)
+	// GetHostRequest pops the next request of host, or returns a nil
+	// request if host has none
+	GetHostRequest(host string) ([]byte, error)
+}
+
+// ResumableHostStorage is a HostStorage which is a ResumableStorage as
+// well, needed by RoundRobin to take requests by host.
+type ResumableHostStorage interface {
+	HostStorage
+	ResumableStorage
+	// TakeHostRequest marks the next request of host as taken and returns
+	// it with its ID, or a nil request if host has none
+	TakeHostRequest(host string) (uint64, []byte, error)
+}
+
+// PriorityFunc computes the priority of a request added to a Queue.
+// Requests of higher priority are run first.
+type PriorityFunc func(r *colly.Request) int
+
+// ContextPriority is the default PriorityFunc, reading the priority of
+// requests from their Ctx at PriorityKey. Requests without priority have
+// a priority of 0.
+func ContextPriority(r *colly.Request) int {
+	if r.Ctx == nil {
+		return 0
+	}
+	switch v := r.Ctx.GetAny(PriorityKey).(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	case string:
+		p, _ := strconv.Atoi(v)
+		return p
+	}
+	return 0
+}
+
+// DepthPriority is a PriorityFunc running shallow requests first, so that
+// sites are crawled breadth first. The priority set in the Ctx of a
+// request takes precedence.
+func DepthPriority(r *colly.Request) int {
+	if r.Ctx != nil && r.Ctx.GetAny(PriorityKey) != nil {
+		return ContextPriority(r)
+	}
+	return -r.Depth
+}
+
+// hostScheduler picks the hosts of the requests of a Queue in turn,
+// skipping the hosts which are waiting for their politeness delay.
+// It's only used by the consumer loop of the Queue.
+type hostScheduler struct {
+	delay time.Duration
+	hosts map[string]*hostState
+	last  string
+}
+
+type hostState struct {
+	active int
+	next   time.Time
+}
+
+func newHostScheduler(delay time.Duration) *hostScheduler {
+	return &hostScheduler{delay: delay, hosts: make(map[string]*hostState)}
+}
+
+// pick returns the first host of hosts after the last picked one which
+// is ready. Otherwise it returns the time to wait for the next ready host,
+// or 0 if the hosts wait for requests in flight.
+func (s *hostScheduler) pick(hosts []string, now time.Time) (string, bool, time.Duration) {
+	if len(hosts) == 0 {
+		return "", false, 0
+	}
+	start := sort.SearchStrings(hosts, s.last)
+	if start < len(hosts) && hosts[start] == s.last {
+		start++
+	}
+	var wait time.Duration
+	for i := 0; i < len(hosts); i++ {
+		host := hosts[(start+i)%len(hosts)]
+		st := s.hosts[host]
+		switch {
+		case st == nil || s.delay <= 0:
+			return host, true, 0
+		case st.active > 0:
+			continue
+		case st.next.After(now):
+			if d := st.next.Sub(now); wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		// idle hosts past their delay need no state
+		delete(s.hosts, host)
+		return host, true, 0
+	}
+	return "", false, wait
+}
+
+// started records a request of host being sent.
+func (s *hostScheduler) started(host string) {
+	s.last = host
+	st := s.hosts[host]
+	if st == nil {
+		st = &hostState{}
+		s.hosts[host] = st
+	}
+	st.active++
+}
+
+// done records the end of a request of host, which waits for the
+// politeness delay before its next request.
+func (s *hostScheduler) done(host string, now time.Time) {
+	st := s.hosts[host]
+	if st == nil {
+		return
+	}
+	st.active--
+	st.next = now.Add(s.delay)
+	if st.active == 0 && s.delay <= 0 {
+		delete(s.hosts, host)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package render implements a colly.Fetcher rendering pages in headless
+// Chrome, so OnHTML and OnXML callbacks see the DOM built by scripts.
+package render
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"xrmcp/colly"
+)
+
+const (
+	// DefaultIdleTime is how long the network must be quiet for a page to be rendered.
+	DefaultIdleTime = 500 * time.Millisecond
+
+	// DefaultTimeout bounds rendering a single page.
+	DefaultTimeout = 30 * time.Second
+)
+
+// ErrMethodNotAllowed is returned for requests other than GET, which a browser can't navigate with.
+var ErrMethodNotAllowed = errors.New("Only GET requests can be rendered")
+
+// skippedHeaders aren't forwarded to the browser, which sends its own.
+var skippedHeaders = map[string]bool{
+	"Accept":                    true,
+	"Accept-Encoding":           true,
+	"Accept-Language":           true,
+	"Connection":                true,
+	"Content-Length":            true,
+	"Host":                      true,
+	"Upgrade-Insecure-Requests": true,
+	"User-Agent":                true,
+}
+
+// Runner runs f with the chromedp context of a browser tab, e.g. a tab
+// pool. f must be done with the tab when it returns.
+type Runner interface {
+	Run(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+// Fetcher renders the pages of GET requests in Chrome and returns their
+// DOM serialized as HTML once the network has been idle. The status code
+// and headers are those of the main document. Request headers other than
+// the ones browsers set themselves are sent along; cookies of the
+// Collector aren't.
+type Fetcher struct {
+
+	// Browser provides the tabs pages are rendered in. When nil, a headless
+	// Chrome is started on first use and a tab opened for each page.
+	Browser Runner
+
+	// IdleTime is how long no request may be in flight for the page to be
+	// rendered.
+	// Default: DefaultIdleTime
+	IdleTime time.Duration
+
+	// Timeout bounds loading a page and waiting for WaitSelector. A page
+	// whose network isn't idle by then is taken as it is.
+	// Default: DefaultTimeout
+	Timeout time.Duration
+
+	// WaitSelector, if set, is a CSS selector that must match before the
+	// page is taken as rendered.
+	WaitSelector string
+
+	lock          sync.Mutex
+	allocCancel   context.CancelFunc
+	browserCtx    context.Context
+	browserCancel context.CancelFunc
+}
+
+// New creates a Fetcher rendering pages in tabs of browser, or in its own
+// headless Chrome if browser is nil.
+func New(browser Runner) *Fetcher {
+	return &Fetcher{Browser: browser}
+}
+
+// Fetch implements colly.Fetcher.
+func (f *Fetcher) Fetch(request *http.Request, bodySize int, checkHeadersFunc colly.CheckHeadersFunc) (*colly.Response, error) {
+	if request.Method != http.MethodGet {
+		return nil, ErrMethodNotAllowed
+	}
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	idle := f.IdleTime
+	if idle <= 0 {
+		idle = DefaultIdleTime
+	}
+
+	var resp *colly.Response
+	err := f.run(request.Context(), func(ctx context.Context) error {
+		var err error
+		resp, err = f.render(ctx, request, time.Now().Add(timeout), idle, bodySize, checkHeadersFunc)
+		return err
+	})
+	return resp, err
+}
+
+// Close stops the Chrome started by the Fetcher, if any.
+func (f *Fetcher) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.shutdownLocked()
+}
+
+func (f *Fetcher) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if f.Browser != nil {
+		return f.Browser.Run(ctx, fn)
+	}
+
+	f.lock.Lock()
+	if f.browserCtx != nil {
+		select {
+		case <-chromedp.FromContext(f.browserCtx).Browser.LostConnection:
+			f.shutdownLocked()
+		default:
+		}
+	}
+	if f.browserCtx == nil {
+		allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), chromedp.DefaultExecAllocatorOptions[:]...)
+		browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+		if err := chromedp.Run(browserCtx); err != nil {
+			browserCancel()
+			allocCancel()
+			f.lock.Unlock()
+			return err
+		}
+		f.allocCancel, f.browserCtx, f.browserCancel = allocCancel, browserCtx, browserCancel
+	}
+	browserCtx := f.browserCtx
+	f.lock.Unlock()
+
+	tabCtx, cancel := chromedp.NewContext(browserCtx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	return fn(tabCtx)
+}
+
+func (f *Fetcher) shutdownLocked() {
+	if f.browserCtx == nil {
+		return
+	}
+	f.browserCancel()
+	f.allocCancel()
+	f.allocCancel, f.browserCtx, f.browserCancel = nil, nil, nil
+}
+
+// render loads request in the tab of ctx and returns the page as rendered
+// once the network is idle or deadline has passed.
+func (f *Fetcher) render(ctx context.Context, request *http.Request, deadline time.Time, idle time.Duration, bodySize int, checkHeadersFunc colly.CheckHeadersFunc) (*colly.Response, error) {
+	var tree *page.FrameTree
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		tree, err = page.GetFrameTree().Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return nil, err
+	}
+	mainFrame := tree.Frame.ID
+
+	headers := network.Headers{}
+	for k, v := range request.Header {
+		if !skippedHeaders[http.CanonicalHeaderKey(k)] && len(v) > 0 {
+			headers[k] = strings.Join(v, ", ")
+		}
+	}
+	if len(headers) > 0 {
+		if err := chromedp.Run(ctx, network.SetExtraHTTPHeaders(headers)); err != nil {
+			return nil, err
+		}
+		// Tabs of a Runner are reused by others.
+		defer chromedp.Run(ctx, network.SetExtraHTTPHeaders(network.Headers{}))
+	}
+
+	var (
+		lock     sync.Mutex
+		inflight = map[network.RequestID]struct{}{}
+		last     = time.Now()
+		document *network.Response
+	)
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chromedp.ListenTarget(lctx, func(ev interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			inflight[e.RequestID] = struct{}{}
+		case *network.EventResponseReceived:
+			if e.Type == network.ResourceTypeDocument && e.FrameID == mainFrame {
+				document = e.Response
+			}
+			return
+		case *network.EventLoadingFinished:
+			delete(inflight, e.RequestID)
+		case *network.EventLoadingFailed:
+			delete(inflight, e.RequestID)
+		default:
+			return
+		}
+		last = time.Now()
+	})
+
+	nctx, ncancel := context.WithDeadline(ctx, deadline)
+	defer ncancel()
+	if err := chromedp.Run(nctx, chromedp.Navigate(request.URL.String())); err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	statusCode, header := http.StatusOK, http.Header{}
+	if document != nil {
+		statusCode = int(document.Status)
+		for k, v := range document.Headers {
+			if s, ok := v.(string); ok {
+				// Repeated headers are joined with newlines.
+				for _, line := range strings.Split(s, "\n") {
+					header.Add(k, line)
+				}
+			}
+		}
+	}
+	lock.Unlock()
+	if !checkHeadersFunc(statusCode, header) {
+		chromedp.Run(ctx, page.StopLoading())
+		return nil, colly.ErrAbortedAfterHeaders
+	}
+
+	if err := waitIdle(nctx, &lock, inflight, &last, idle); err != nil {
+		return nil, err
+	}
+	if f.WaitSelector != "" {
+		if err := chromedp.Run(nctx, chromedp.WaitReady(f.WaitSelector, chromedp.ByQuery)); err != nil {
+			return nil, err
+		}
+	}
+
+	var location, html string
+	err = chromedp.Run(ctx,
+		chromedp.Location(&location),
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if location != request.URL.String() {
+		if u, err := url.Parse(location); err == nil {
+			request.URL = u
+		}
+	}
+
+	// The body is the serialized DOM, not what the server sent.
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	resp := &colly.Response{
+		StatusCode: statusCode,
+		Body:       []byte(html),
+		Headers:    &header,
+	}
+	if bodySize > 0 && len(resp.Body) > bodySize {
+		resp.Body = resp.Body[:bodySize]
+		resp.Truncated = true
+	}
+	return resp, nil
+}
+
+// waitIdle waits until no request has been in flight for idle. It returns
+// without error when the deadline of ctx passes, so slow pages are taken as
+// they are.
+func waitIdle(ctx context.Context, lock *sync.Mutex, inflight map[network.RequestID]struct{}, last *time.Time, idle time.Duration) error {
+	ticker := time.NewTicker(idle / 5)
+	defer ticker.Stop()
+	for {
+		lock.Lock()
+		quiet := len(inflight) == 0 && time.Since(*last) >= idle
+		lock.Unlock()
+		if quiet {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"xrmcp/colly"
+)
+
+func TestFetcher(t *testing.T) {
+	requireChrome(t)
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	f := New(nil)
+	defer f.Close()
+	c := colly.NewCollector()
+	c.SetFetcher(f)
+
+	var items []string
+	c.OnHTML("#items li", func(e *colly.HTMLElement) {
+		items = append(items, e.Text)
+	})
+	var status int
+	c.OnResponse(func(r *colly.Response) {
+		status = r.StatusCode
+	})
+	if err := c.Visit(ts.URL + "/spa.html"); err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("status = %d, want %d", status, http.StatusOK)
+	}
+	if want := []string{"one", "two", "three"}; !reflect.DeepEqual(items, want) {
+		t.Errorf("items = %q, want %q", items, want)
+	}
+}
+
+func TestFetcherMaxBodySize(t *testing.T) {
+	requireChrome(t)
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	f := New(nil)
+	defer f.Close()
+	c := colly.NewCollector(colly.MaxBodySize(100))
+	c.SetFetcher(f)
+
+	var resp *colly.Response
+	c.OnResponse(func(r *colly.Response) {
+		resp = r
+	})
+	if err := c.Visit(ts.URL + "/spa.html"); err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || len(resp.Body) != 100 || !resp.Truncated {
+		t.Errorf("response = %+v, want a truncated body of 100 bytes", resp)
+	}
+}
+
+func TestFetcherMethod(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/", nil)
+	_, err := New(nil).Fetch(req, 0, func(int, http.Header) bool { return true })
+	if err != ErrMethodNotAllowed {
+		t.Errorf("got error %v, want %v", err, ErrMethodNotAllowed)
+	}
+}
+
+// requireChrome skips tests that need a Chrome binary when none is installed.
+func requireChrome(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"google-chrome", "chromium", "chromium-browser", "chrome"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return
+		}
+	}
+	t.Skip("Chrome is not installed")
+}
@@ -16,6 +16,7 @@ package colly
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -43,6 +44,10 @@ type Request struct {
 	// Leave it blank to allow automatic character encoding of the response body.
 	// It is empty by default and it can be set in OnRequest callback.
 	ResponseCharacterEncoding string
+	// Fetcher retrieves the response of the request, e.g. a browser
+	// rendering the page. It defaults to the Collector's Fetcher and
+	// can be set in OnRequest callback.
+	Fetcher Fetcher
 	// ID is the Unique identifier of the request
 	ID        uint32
 	collector *Collector
@@ -50,6 +55,7 @@ type Request struct {
 	baseURL   *url.URL
 	// ProxyURL is the proxy address that handles the request
 	ProxyURL string
+	goCtx    context.Context
 }
 
 type serializableRequest struct {
@@ -112,7 +118,7 @@ func (r *Request) AbsoluteURL(u string) string {
 // request and preserves the Context of the previous request.
 // Visit also calls the previously provided callbacks
 func (r *Request) Visit(URL string) error {
-	return r.collector.scrape(r.AbsoluteURL(URL), "GET", r.Depth+1, nil, r.Ctx, nil, true)
+	return r.collector.scrape(r.Context(), r.AbsoluteURL(URL), "GET", r.Depth+1, nil, r.Ctx, nil, true)
 }
 
 // HasVisited checks if the provided URL has been visited
@@ -124,14 +130,14 @@ func (r *Request) HasVisited(URL string) (bool, error) {
 // of the previous request.
 // Post also calls the previously provided callbacks
 func (r *Request) Post(URL string, requestData map[string]string) error {
-	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, createFormReader(requestData), r.Ctx, nil, true)
+	return r.collector.scrape(r.Context(), r.AbsoluteURL(URL), "POST", r.Depth+1, createFormReader(requestData), r.Ctx, nil, true)
 }
 
 // PostRaw starts a collector job by creating a POST request with raw binary data.
 // PostRaw preserves the Context of the previous request
 // and calls the previously provided callbacks
 func (r *Request) PostRaw(URL string, requestData []byte) error {
-	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, bytes.NewReader(requestData), r.Ctx, nil, true)
+	return r.collector.scrape(r.Context(), r.AbsoluteURL(URL), "POST", r.Depth+1, bytes.NewReader(requestData), r.Ctx, nil, true)
 }
 
 // PostMultipart starts a collector job by creating a Multipart POST request
@@ -141,19 +147,33 @@ func (r *Request) PostMultipart(URL string, requestData map[string][]byte) error
 	boundary := randomBoundary()
 	hdr := http.Header{}
 	hdr.Set("Content-Type", "multipart/form-data; boundary="+boundary)
-	hdr.Set("User-Agent", r.collector.UserAgent)
-	return r.collector.scrape(r.AbsoluteURL(URL), "POST", r.Depth+1, createMultipartReader(boundary, requestData), r.Ctx, hdr, true)
+	return r.collector.scrape(r.Context(), r.AbsoluteURL(URL), "POST", r.Depth+1, createMultipartReader(boundary, requestData), r.Ctx, hdr, true)
 }
 
 // Retry submits HTTP request again with the same parameters
 func (r *Request) Retry() error {
 	r.Headers.Del("Cookie")
-	return r.collector.scrape(r.URL.String(), r.Method, r.Depth, r.Body, r.Ctx, *r.Headers, false)
+	return r.collector.scrape(r.Context(), r.URL.String(), r.Method, r.Depth, r.Body, r.Ctx, *r.Headers, false)
+}
+
+// Context returns the context.Context the request is bound to, see
+// Collector.VisitContext. Requests made from its callbacks are bound to
+// it as well.
+func (r *Request) Context() context.Context {
+	if r.goCtx == nil {
+		return context.Background()
+	}
+	return r.goCtx
 }
 
 // Do submits the request
 func (r *Request) Do() error {
-	return r.collector.scrape(r.URL.String(), r.Method, r.Depth, r.Body, r.Ctx, *r.Headers, !r.collector.AllowURLRevisit)
+	return r.DoContext(r.Context())
+}
+
+// DoContext submits the request bound to ctx
+func (r *Request) DoContext(ctx context.Context) error {
+	return r.collector.scrape(ctx, r.URL.String(), r.Method, r.Depth, r.Body, r.Ctx, *r.Headers, !r.collector.AllowURLRevisit)
 }
 
 // Marshal serializes the Request
@@ -41,6 +41,13 @@ type Response struct {
 	// Trace contains the HTTPTrace for the request. Will only be set by the
 	// collector if Collector.TraceHTTP is set to true.
 	Trace *HTTPTrace
+	// Truncated is true if the body was cut at MaxBodySize bytes
+	Truncated bool
+	// streamed is true if the body was passed to a bodyStream
+	streamed bool
+	// unreplayable is true if the body was passed to OnResponseStream
+	// callbacks, so that the request can't be retried
+	unreplayable bool
 }
 
 // Save writes response body to disk
@@ -61,6 +68,9 @@ func (r *Response) FileName() string {
 	return SanitizeFileName(strings.TrimPrefix(r.Request.URL.Path, "/"))
 }
 
+// fixCharset converts the body to UTF-8 from the encoding found by
+// sniffCharset, or by chardet if detectCharset is set and none is found.
+// defaultEncoding overrides them.
 func (r *Response) fixCharset(detectCharset bool, defaultEncoding string) error {
 	if len(r.Body) == 0 {
 		return nil
@@ -70,7 +80,7 @@ func (r *Response) fixCharset(detectCharset bool, defaultEncoding string) error
 		if err != nil {
 			return err
 		}
-		r.Body = tmpBody
+		r.Body = utf8XMLDeclaration(tmpBody)
 		return nil
 	}
 	contentType := strings.ToLower(r.Headers.Get("Content-Type"))
@@ -84,7 +94,8 @@ func (r *Response) fixCharset(detectCharset bool, defaultEncoding string) error
 		return nil
 	}
 
-	if !strings.Contains(contentType, "charset") {
+	name, bomLen := sniffCharset(r.Body, contentType)
+	if name == "" {
 		if !detectCharset {
 			return nil
 		}
@@ -93,16 +104,19 @@ func (r *Response) fixCharset(detectCharset bool, defaultEncoding string) error
 		if err != nil {
 			return err
 		}
-		contentType = "text/plain; charset=" + r.Charset
+		if name = lookupCharset(r.Charset); name == "" {
+			return nil
+		}
 	}
-	if strings.Contains(contentType, "utf-8") || strings.Contains(contentType, "utf8") {
+	r.Body = r.Body[bomLen:]
+	if name == "utf-8" {
 		return nil
 	}
-	tmpBody, err := encodeBytes(r.Body, contentType)
+	tmpBody, err := encodeBytes(r.Body, "text/plain; charset="+name)
 	if err != nil {
 		return err
 	}
-	r.Body = tmpBody
+	r.Body = utf8XMLDeclaration(tmpBody)
 	return nil
 }
 
@@ -0,0 +1,222 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetriesKey is the Context key holding the number of times a request was
+// retried by the RetryPolicy of its Collector, as an int.
+const RetriesKey = "retries"
+
+// DefaultRetryStatusCodes are the status codes retried when
+// RetryPolicy.StatusCodes is empty.
+var DefaultRetryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy makes a Collector retry failed requests before reporting
+// them to OnError callbacks. Attempts are spaced by an exponential backoff
+// with jitter, or by the Retry-After header of the response. Requests with
+// a body that can't be read again are not retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts made, including the first one.
+	// Default: 3
+	MaxAttempts int
+	// StatusCodes are the response status codes retried.
+	// Default: DefaultRetryStatusCodes
+	StatusCodes []int
+	// RetryError reports whether a request failing with err is retried.
+	// Default: IsRetryableError
+	RetryError func(err error) bool
+	// BaseDelay is the delay before the first retry, doubled for each
+	// further retry.
+	// Default: 1 second
+	BaseDelay time.Duration
+	// MaxDelay bounds the delay before a retry, including delays asked for
+	// by Retry-After.
+	// Default: 30 seconds
+	MaxDelay time.Duration
+}
+
+// Retries sets the RetryPolicy of the Collector.
+func Retries(p *RetryPolicy) CollectorOption {
+	return func(c *Collector) {
+		c.RetryPolicy = p
+	}
+}
+
+// IsRetryableError reports whether err is a transient network error: a
+// timeout, a refused, reset or aborted connection, a connection closed
+// before the response was complete or a temporary DNS failure.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrAbortedAfterHeaders) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// next returns how long to wait before attempt+1 when attempt ended with
+// response and err, and whether to make it at all.
+func (p *RetryPolicy) next(attempt int, response *Response, err error) (time.Duration, bool) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 3
+	}
+	if attempt >= maxAttempts || !p.retryable(response, err) {
+		return 0, false
+	}
+
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+	if response != nil && response.Headers != nil {
+		if d, ok := retryAfter(response.Headers.Get("Retry-After")); ok {
+			if d > maxDelay {
+				d = maxDelay
+			}
+			return d, true
+		}
+	}
+	delay := p.BaseDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	// "Equal jitter": at least half of the delay, so retries stay spaced.
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1)), true
+}
+
+func (p *RetryPolicy) retryable(response *Response, err error) bool {
+	if err != nil {
+		if p.RetryError != nil {
+			return p.RetryError(err)
+		}
+		return IsRetryableError(err)
+	}
+	codes := p.StatusCodes
+	if len(codes) == 0 {
+		codes = DefaultRetryStatusCodes
+	}
+	for _, code := range codes {
+		if response.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter parses the value of a Retry-After header, either a number of
+// seconds or an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// do fetches req for request, retrying it as allowed by the RetryPolicy
+// of the Collector. It returns the last request sent.
+func (c *Collector) do(request *Request, req *http.Request, checkHeadersFunc CheckHeadersFunc) (*http.Request, *Response, error) {
+	fetcher := c.fetcherFor(request)
+	p := c.RetryPolicy
+	if p == nil {
+		response, err := c.backend.Cache(fetcher, req, c.MaxBodySize, checkHeadersFunc, c.responseCache())
+		return req, response, err
+	}
+
+	// Fetchers update req, including its context, when following redirects.
+	orig := req.Clone(req.Context())
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	for attempt := 1; ; attempt++ {
+		request.Ctx.Put(RetriesKey, attempt-1)
+		response, err := c.backend.Cache(fetcher, req, c.MaxBodySize, checkHeadersFunc, c.responseCache())
+		delay, retry := p.next(attempt, response, err)
+		if !retry || !rewindable || response != nil && response.unreplayable {
+			return req, response, err
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = http.StatusText(response.StatusCode)
+		}
+		if c.debugger != nil {
+			c.debugger.Event(createEvent("retry", request.ID, c.ID, map[string]string{
+				"url":     request.URL.String(),
+				"attempt": strconv.Itoa(attempt + 1),
+				"delay":   delay.String(),
+				"reason":  reason,
+			}))
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-orig.Context().Done():
+			t.Stop()
+			return req, nil, orig.Context().Err()
+		case <-t.C:
+		}
+
+		req = orig.Clone(orig.Context())
+		if orig.GetBody != nil {
+			if req.Body, err = orig.GetBody(); err != nil {
+				return req, nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+const (
+	// DefaultRobotsTTL is the time a fetched robots.txt is used before
+	// being fetched again. RFC 9309 recommends at most a day.
+	DefaultRobotsTTL = 24 * time.Hour
+	// DefaultRobotsErrorTTL is the time a host stays disallowed once its
+	// robots.txt was unreachable.
+	DefaultRobotsErrorTTL = time.Minute
+	// maxRobotsSize is the size of robots.txt files parsed, the minimum
+	// required by RFC 9309.
+	maxRobotsSize = 500 * 1024
+)
+
+// ErrRobotsTxtUnreachable is returned for URLs of a host whose robots.txt
+// couldn't be fetched because of a network error or a server error. The
+// host is disallowed until RobotsManager.ErrorTTL elapses.
+var ErrRobotsTxtUnreachable = errors.New("robots.txt unreachable, host temporarily disallowed")
+
+// RobotsManager fetches, caches and evaluates the robots.txt files of the
+// hosts visited by a Collector and its clones, following RFC 9309:
+//
+//   - 2xx responses are parsed
+//   - 4xx responses, except 429, allow everything
+//   - 429, 5xx responses and network errors disallow the host for ErrorTTL,
+//     unless a previously fetched copy can be used instead
+//
+// The Crawl-delay of the group matching the Collector's user agent is
+// enforced with a LimitRule of the host.
+type RobotsManager struct {
+	// TTL is the time a robots.txt is used before being fetched again,
+	// DefaultRobotsTTL if 0
+	TTL time.Duration
+	// ErrorTTL is the time an unreachable robots.txt disallows its host,
+	// DefaultRobotsErrorTTL if 0
+	ErrorTTL time.Duration
+	// MaxCrawlDelay caps Crawl-delay directives, 0 means no limit
+	MaxCrawlDelay time.Duration
+	// IgnoreCrawlDelay disables the LimitRules of Crawl-delay directives
+	IgnoreCrawlDelay bool
+	lock             sync.Mutex
+	hosts            map[string]*robotsEntry
+	now              func() time.Time
+}
+
+type robotsEntry struct {
+	// ready is closed once the fields below are set
+	ready   chan struct{}
+	data    *robotstxt.RobotsData
+	err     error
+	expires time.Time
+	rule    *LimitRule
+}
+
+// NewRobotsManager creates a RobotsManager with the default TTLs.
+func NewRobotsManager() *RobotsManager {
+	return &RobotsManager{}
+}
+
+// Sitemaps returns the sitemap URLs listed in the robots.txt files
+// fetched so far, by host.
+func (m *RobotsManager) Sitemaps() map[string][]string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sitemaps := make(map[string][]string)
+	for host, e := range m.hosts {
+		select {
+		case <-e.ready:
+		default:
+			continue
+		}
+		if e.data != nil && len(e.data.Sitemaps) > 0 {
+			sitemaps[host] = append([]string(nil), e.data.Sitemaps...)
+		}
+	}
+	return sitemaps
+}
+
+// Forget drops the cached robots.txt of host, which is fetched again on
+// the next request.
+func (m *RobotsManager) Forget(host string) {
+	m.lock.Lock()
+	delete(m.hosts, host)
+	m.lock.Unlock()
+}
+
+// get returns the robots.txt of u's host, fetching it with c if it isn't
+// cached or expired. Concurrent requests of a host share a single fetch.
+func (m *RobotsManager) get(ctx context.Context, c *Collector, u *url.URL) (*robotstxt.RobotsData, error) {
+	for {
+		m.lock.Lock()
+		if m.hosts == nil {
+			m.hosts = make(map[string]*robotsEntry)
+		}
+		prev := m.hosts[u.Host]
+		if prev == nil {
+			return m.load(ctx, c, u, nil)
+		}
+		select {
+		case <-prev.ready:
+			if m.clock().Before(prev.expires) {
+				m.lock.Unlock()
+				return prev.data, prev.err
+			}
+			return m.load(ctx, c, u, prev)
+		default:
+		}
+		m.lock.Unlock()
+		select {
+		case <-prev.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// The entry is used on the next iteration, unless its fetch was
+		// canceled and it got removed.
+	}
+}
+
+// load fetches the robots.txt of u's host in place of prev. It must be
+// called with m.lock held, which it releases.
+func (m *RobotsManager) load(ctx context.Context, c *Collector, u *url.URL, prev *robotsEntry) (*robotstxt.RobotsData, error) {
+	e := &robotsEntry{ready: make(chan struct{})}
+	m.hosts[u.Host] = e
+	m.lock.Unlock()
+	defer close(e.ready)
+
+	data, err := m.fetch(ctx, c, u)
+	now := m.clock()
+	switch {
+	case errors.Is(err, ErrRobotsTxtUnreachable) && prev != nil && prev.data != nil:
+		// RFC 9309 allows using the last copy while unreachable.
+		e.data, e.rule = prev.data, prev.rule
+		e.expires = now.Add(m.errorTTL())
+	case errors.Is(err, ErrRobotsTxtUnreachable):
+		e.err = err
+		e.expires = now.Add(m.errorTTL())
+	case err != nil:
+		// The fetch was canceled: fetch again on the next request.
+		m.lock.Lock()
+		if prev != nil {
+			m.hosts[u.Host] = prev
+		} else {
+			delete(m.hosts, u.Host)
+		}
+		m.lock.Unlock()
+		return nil, err
+	default:
+		e.data = data
+		e.expires = now.Add(m.ttl())
+		var old *LimitRule
+		if prev != nil {
+			old = prev.rule
+		}
+		e.rule = m.applyCrawlDelay(c, u.Host, data, old)
+	}
+	return e.data, e.err
+}
+
+func (m *RobotsManager) fetch(ctx context.Context, c *Collector, u *url.URL) (*robotstxt.RobotsData, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.Scheme+"://"+u.Host+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	// The client of the backend uses the proxies of the Collector.
+	resp, err := c.backend.Client.Do(req)
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case errors.Is(err, ErrForbiddenDomain):
+		// Redirects which can't be followed count as a missing file.
+		return robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrRobotsTxtUnreachable, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, fmt.Errorf("%w: status %d", ErrRobotsTxtUnreachable, resp.StatusCode)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		// 4xx and redirects which weren't followed mean there are no
+		// restrictions.
+		return robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrRobotsTxtUnreachable, err)
+	}
+	data, err := robotstxt.FromBytes(body)
+	if err != nil {
+		// Files which can't be parsed don't restrict anything.
+		return robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+	}
+	return data, nil
+}
+
+// applyCrawlDelay installs a LimitRule of host enforcing the Crawl-delay
+// of the robots.txt group matching the user agent of c, in place of old.
+// Delays and random delays of the LimitRule already matching host are
+// kept if longer.
+func (m *RobotsManager) applyCrawlDelay(c *Collector, host string, data *robotstxt.RobotsData, old *LimitRule) *LimitRule {
+	var delay time.Duration
+	if group := data.FindGroup(c.userAgent()); group != nil && !m.IgnoreCrawlDelay {
+		delay = group.CrawlDelay
+	}
+	if m.MaxCrawlDelay > 0 && delay > m.MaxCrawlDelay {
+		delay = m.MaxCrawlDelay
+	}
+	if delay <= 0 {
+		if old != nil {
+			c.backend.replaceRule(old, nil)
+		}
+		return nil
+	}
+	if old != nil && old.Delay == delay {
+		return old
+	}
+	rule := &LimitRule{
+		DomainRegexp: "^" + regexp.QuoteMeta(host) + "$",
+		Delay:        delay,
+		Parallelism:  1,
+	}
+	if r := c.backend.GetMatchingRule(host); r != nil && r != old {
+		if r.Delay > rule.Delay {
+			rule.Delay = r.Delay
+		}
+		rule.RandomDelay = r.RandomDelay
+	}
+	if err := rule.Init(); err != nil {
+		return nil
+	}
+	c.backend.replaceRule(old, rule)
+	return rule
+}
+
+func (m *RobotsManager) ttl() time.Duration {
+	if m.TTL > 0 {
+		return m.TTL
+	}
+	return DefaultRobotsTTL
+}
+
+func (m *RobotsManager) errorTTL() time.Duration {
+	if m.ErrorTTL > 0 {
+		return m.ErrorTTL
+	}
+	return DefaultRobotsErrorTTL
+}
+
+func (m *RobotsManager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"xrmcp/colly/cache"
+)
+
+// cacheRow is the SQLite representation of a cache entry.
+type cacheRow struct {
+	Key       string    `gorm:"column:cache_key;primarykey"`
+	Entry     []byte    `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"index"`
+}
+
+func (cacheRow) TableName() string {
+	return "colly_http_cache"
+}
+
+// CacheStore is a cache.Store keeping entries in the "colly_http_cache"
+// table of a database.
+type CacheStore struct {
+	db *gorm.DB
+}
+
+// NewCacheStore creates a CacheStore in db, creating its table if needed.
+func NewCacheStore(db *gorm.DB) (*CacheStore, error) {
+	if err := db.AutoMigrate(&cacheRow{}); err != nil {
+		return nil, err
+	}
+	return &CacheStore{db: db}, nil
+}
+
+// Get implements cache.Store.Get() function
+func (s *CacheStore) Get(key string) (*cache.Entry, error) {
+	var row cacheRow
+	err := s.db.Where("cache_key = ?", key).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := &cache.Entry{}
+	if err := gob.NewDecoder(bytes.NewReader(row.Entry)).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Set implements cache.Store.Set() function
+func (s *CacheStore) Set(key string, e *cache.Entry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return err
+	}
+	row := cacheRow{Key: key, Entry: buf.Bytes()}
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error
+}
+
+// Delete implements cache.Store.Delete() function
+func (s *CacheStore) Delete(key string) error {
+	return s.db.Where("cache_key = ?", key).Delete(&cacheRow{}).Error
+}
+
+// Purge removes the entries not updated since before.
+func (s *CacheStore) Purge(before time.Time) error {
+	return s.db.Where("updated_at < ?", before).Delete(&cacheRow{}).Error
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"xrmcp/colly/cache"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "colly.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestCacheStore(t *testing.T) {
+	s, err := NewCacheStore(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := s.Get("a"); e != nil || err != nil {
+		t.Fatalf("Get() of a missing key = %v, %v", e, err)
+	}
+	for _, body := range []string{"first", "second"} {
+		if err := s.Set("a", &cache.Entry{StatusCode: 200, Header: http.Header{"A": {"b"}}, Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := s.Get("a")
+	if err != nil || e == nil || string(e.Body) != "second" || e.Header.Get("A") != "b" {
+		t.Fatalf("Get() = %v, %v", e, err)
+	}
+	if err := s.Purge(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := s.Get("a"); e != nil {
+		t.Error("Entry not purged")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"xrmcp/colly"
+)
+
+type queueRow struct {
+	ID       uint64 `gorm:"primarykey"`
+	Request  []byte `gorm:"not null"`
+	Taken    bool   `gorm:"index;not null;default:false"`
+	Host     string `gorm:"index;not null;default:''"`
+	Priority int    `gorm:"not null;default:0"`
+}
+
+func (queueRow) TableName() string {
+	return "colly_queue"
+}
+
+// QueueStorage is a queue.ResumableHostStorage keeping the requests of a
+// queue in the "colly_queue" table of a database. Requests taken by a run
+// stay in the table until they are done, so Queue.Run resumes a crashed run
+// where it stopped.
+//
+// Added requests are written in batches: the last ones are lost if the
+// process crashes before they are flushed. Close and Ack flush them, so the
+// requests added while a request was processed survive its removal.
+type QueueStorage struct {
+	// MaxSize defines the capacity of the queue, 0 means no limit.
+	// New requests are discarded if the queue size reaches MaxSize
+	MaxSize int
+	// BatchSize is the number of added requests written in a single
+	// transaction, DefaultBatchSize if 0
+	BatchSize int
+	// FlushInterval is the longest time added requests stay buffered,
+	// DefaultFlushInterval if 0
+	FlushInterval time.Duration
+	db            *gorm.DB
+	batch         batcher
+	pending       []queueRow
+	started       bool
+}
+
+// NewQueueStorage creates a QueueStorage in db.
+func NewQueueStorage(db *gorm.DB) *QueueStorage {
+	return &QueueStorage{db: db}
+}
+
+// Init implements queue.Storage.Init() function
+func (q *QueueStorage) Init() error {
+	if q.db == nil {
+		return errors.New("sqlite: QueueStorage created without database")
+	}
+	q.batch.lock.Lock()
+	defer q.batch.lock.Unlock()
+	if q.started {
+		return nil
+	}
+	if err := q.db.AutoMigrate(&queueRow{}); err != nil {
+		return err
+	}
+	q.started = true
+	q.batch.start(q.BatchSize, q.FlushInterval, q.flush)
+	return nil
+}
+
+// AddRequest implements queue.Storage.AddRequest() function
+func (q *QueueStorage) AddRequest(r []byte) error {
+	return q.AddHostRequest("", 0, r)
+}
+
+// AddHostRequest implements queue.HostStorage.AddHostRequest() function
+func (q *QueueStorage) AddHostRequest(host string, priority int, r []byte) error {
+	q.batch.lock.Lock()
+	defer q.batch.lock.Unlock()
+	if q.MaxSize > 0 {
+		size, err := q.sizeLocked()
+		if err != nil {
+			return err
+		}
+		if size >= q.MaxSize {
+			return colly.ErrQueueFull
+		}
+	}
+	q.pending = append(q.pending, queueRow{Request: r, Host: host, Priority: priority})
+	return q.batch.added()
+}
+
+// GetRequest implements queue.Storage.GetRequest() function
+func (q *QueueStorage) GetRequest() ([]byte, error) {
+	return q.get(anyHost)
+}
+
+// GetHostRequest implements queue.HostStorage.GetHostRequest() function
+func (q *QueueStorage) GetHostRequest(host string) ([]byte, error) {
+	return q.get(byHost(host))
+}
+
+// TakeRequest implements queue.ResumableStorage.TakeRequest() function
+func (q *QueueStorage) TakeRequest() (uint64, []byte, error) {
+	return q.take(anyHost)
+}
+
+// TakeHostRequest implements queue.ResumableHostStorage.TakeHostRequest()
+// function
+func (q *QueueStorage) TakeHostRequest(host string) (uint64, []byte, error) {
+	return q.take(byHost(host))
+}
+
+// Hosts implements queue.HostStorage.Hosts() function
+func (q *QueueStorage) Hosts() ([]string, error) {
+	q.batch.lock.Lock()
+	defer q.batch.lock.Unlock()
+	if err := q.batch.flushLocked(); err != nil {
+		return nil, err
+	}
+	var hosts []string
+	err := q.db.Model(&queueRow{}).Where("taken = ?", false).Distinct("host").Order("host").Pluck("host", &hosts).Error
+	return hosts, err
+}
+
+// Ack implements queue.ResumableStorage.Ack() function. The buffered
+// requests, which may have been added by the acknowledged one, are flushed
+// first, so that a crash can't lose them once it's done.
+func (q *QueueStorage) Ack(id uint64) error {
+	q.batch.lock.Lock()
+	defer q.batch.lock.Unlock()
+	if err := q.batch.flushLocked(); err != nil {
+		return err
+	}
+	return q.db.Delete(&queueRow{}, id).Error
+}
+
+// Resume implements queue.ResumableStorage.Resume() function
+func (q *QueueStorage) Resume() error {
+	return q.db.Model(&queueRow{}).Where("taken = ?", true).Update("taken", false).Error
+}
+
+// QueueSize implements queue.Storage.QueueSize() function
+func (q *QueueStorage) QueueSize() (int, error) {
+	q.batch.lock.Lock()
+	defer q.batch.lock.Unlock()
+	return q.sizeLocked()
+}
+
+// Close flushes the buffered requests. The database isn't closed.
+func (q *QueueStorage) Close() error {
+	return q.batch.close()
+}
+
+func (q *QueueStorage) sizeLocked() (int, error) {
+	var count int64
+	err := q.db.Model(&queueRow{}).Where("taken = ?", false).Count(&count).Error
+	return int(count) + len(q.pending), err
+}
+
+func (q *QueueStorage) get(scope func(*gorm.DB) *gorm.DB) ([]byte, error) {
+	var r []byte
+	err := q.next(scope, func(tx *gorm.DB, row *queueRow) error {
+		r = row.Request
+		return tx.Delete(row).Error
+	})
+	return r, err
+}
+
+func (q *QueueStorage) take(scope func(*gorm.DB) *gorm.DB) (uint64, []byte, error) {
+	var id uint64
+	var r []byte
+	err := q.next(scope, func(tx *gorm.DB, row *queueRow) error {
+		id, r = row.ID, row.Request
+		return tx.Model(row).Update("taken", true).Error
+	})
+	return id, r, err
+}
+
+func anyHost(tx *gorm.DB) *gorm.DB {
+	return tx
+}
+
+func byHost(host string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("host = ?", host)
+	}
+}
+
+// next calls f in a transaction with the request of highest priority, then
+// the oldest one, of the requests matching scope which aren't taken. The
+// buffered requests are flushed first to keep the queue order.
+func (q *QueueStorage) next(scope func(*gorm.DB) *gorm.DB, f func(tx *gorm.DB, row *queueRow) error) error {
+	q.batch.lock.Lock()
+	defer q.batch.lock.Unlock()
+	if err := q.batch.flushLocked(); err != nil {
+		return err
+	}
+	return q.db.Transaction(func(tx *gorm.DB) error {
+		var row queueRow
+		err := tx.Scopes(scope).Where("taken = ?", false).Order("priority desc, id").Take(&row).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return f(tx, &row)
+	})
+}
+
+// flush writes the buffered requests, with q.batch.lock held.
+func (q *QueueStorage) flush() error {
+	if len(q.pending) == 0 {
+		return nil
+	}
+	if err := q.db.CreateInBatches(q.pending, 500).Error; err != nil {
+		return err
+	}
+	q.pending = nil
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sqlite implements colly storage backends in SQLite databases
+// opened with gorm: the visited requests and cookies of a Collector, the
+// requests of a queue.Queue and the entries of an HTTP cache.
+package sqlite
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	// DefaultBatchSize is the number of writes buffered before being
+	// committed in a single transaction.
+	DefaultBatchSize = 100
+	// DefaultFlushInterval is the longest time writes stay buffered.
+	DefaultFlushInterval = time.Second
+)
+
+// Open opens the SQLite database at path in WAL mode, so that readers
+// don't block writers, with a busy timeout for concurrent processes.
+func Open(path string) (*gorm.DB, error) {
+	dsn := path
+	if !strings.Contains(dsn, "?") {
+		dsn += "?"
+	} else {
+		dsn += "&"
+	}
+	dsn += "_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serializes writes anyway, a single connection avoids
+	// SQLITE_BUSY errors between the connections of the pool.
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// batcher buffers writes and hands them to flush once BatchSize of them
+// are pending or FlushInterval elapsed.
+type batcher struct {
+	size     int
+	interval time.Duration
+	flush    func() error
+	lock     sync.Mutex
+	pending  int
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (b *batcher) start(size int, interval time.Duration, flush func() error) {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	b.size, b.interval, b.flush = size, interval, flush
+	b.stop, b.done = make(chan struct{}), make(chan struct{})
+	go b.run()
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			b.lock.Lock()
+			b.flushLocked()
+			b.lock.Unlock()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// added records a buffered write, which must be done with b.lock held.
+func (b *batcher) added() error {
+	b.pending++
+	if b.pending < b.size {
+		return nil
+	}
+	return b.flushLocked()
+}
+
+func (b *batcher) flushLocked() error {
+	if b.pending == 0 {
+		return nil
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	b.pending = 0
+	return nil
+}
+
+// close stops the periodic flushes and flushes the pending writes.
+func (b *batcher) close() error {
+	if b.stop == nil {
+		return nil
+	}
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	<-b.done
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.flushLocked()
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type visitedRow struct {
+	// RequestID is the uint64 request ID stored as is in an INTEGER
+	RequestID int64 `gorm:"primarykey;autoIncrement:false"`
+}
+
+func (visitedRow) TableName() string {
+	return "colly_visited"
+}
+
+type cookieRow struct {
+	Host    string `gorm:"primarykey"`
+	Cookies string `gorm:"not null"`
+}
+
+func (cookieRow) TableName() string {
+	return "colly_cookies"
+}
+
+// Storage is a storage.Storage keeping the visited requests and the
+// cookies of a Collector in the "colly_visited" and "colly_cookies" tables
+// of a database, so that a crawl can be resumed by another process.
+//
+// Visited requests are written in batches: the last ones are lost if the
+// process crashes before they are flushed. Close flushes them.
+type Storage struct {
+	// BatchSize is the number of visited requests written in a single
+	// transaction, DefaultBatchSize if 0
+	BatchSize int
+	// FlushInterval is the longest time visited requests stay buffered,
+	// DefaultFlushInterval if 0
+	FlushInterval time.Duration
+	db            *gorm.DB
+	batch         batcher
+	visited       map[uint64]struct{}
+}
+
+// NewStorage creates a Storage in db.
+func NewStorage(db *gorm.DB) *Storage {
+	return &Storage{db: db}
+}
+
+// Init implements storage.Storage.Init() function
+func (s *Storage) Init() error {
+	if s.db == nil {
+		return errors.New("sqlite: Storage created without database")
+	}
+	s.batch.lock.Lock()
+	defer s.batch.lock.Unlock()
+	if s.visited != nil {
+		return nil
+	}
+	if err := s.db.AutoMigrate(&visitedRow{}, &cookieRow{}); err != nil {
+		return err
+	}
+	s.visited = make(map[uint64]struct{})
+	s.batch.start(s.BatchSize, s.FlushInterval, s.flush)
+	return nil
+}
+
+// Visited implements storage.Storage.Visited() function
+func (s *Storage) Visited(requestID uint64) error {
+	s.batch.lock.Lock()
+	defer s.batch.lock.Unlock()
+	s.visited[requestID] = struct{}{}
+	return s.batch.added()
+}
+
+// IsVisited implements storage.Storage.IsVisited() function
+func (s *Storage) IsVisited(requestID uint64) (bool, error) {
+	s.batch.lock.Lock()
+	_, ok := s.visited[requestID]
+	s.batch.lock.Unlock()
+	if ok {
+		return true, nil
+	}
+	var count int64
+	err := s.db.Model(&visitedRow{}).Where("request_id = ?", int64(requestID)).Count(&count).Error
+	return count > 0, err
+}
+
+// Cookies implements storage.Storage.Cookies() function
+func (s *Storage) Cookies(u *url.URL) string {
+	var row cookieRow
+	if err := s.db.Where("host = ?", u.Host).Take(&row).Error; err != nil {
+		return ""
+	}
+	return row.Cookies
+}
+
+// SetCookies implements storage.Storage.SetCookies() function
+func (s *Storage) SetCookies(u *url.URL, cookies string) {
+	s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cookieRow{Host: u.Host, Cookies: cookies})
+}
+
+// Close flushes the buffered visited requests. The database isn't closed.
+func (s *Storage) Close() error {
+	return s.batch.close()
+}
+
+// flush writes the buffered visited requests, with s.batch.lock held.
+func (s *Storage) flush() error {
+	rows := make([]visitedRow, 0, len(s.visited))
+	for id := range s.visited {
+		rows = append(rows, visitedRow{RequestID: int64(id)})
+	}
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500).Error
+	if err != nil {
+		return err
+	}
+	s.visited = make(map[uint64]struct{})
+	return nil
+}
@@ -0,0 +1,285 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"xrmcp/colly"
+	"xrmcp/colly/queue"
+)
+
+func TestStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "colly.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Storage{db: db, BatchSize: 2, FlushInterval: time.Hour}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint64{1, math.MaxUint64, 1} {
+		if err := s.Visited(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if visited, err := s.IsVisited(math.MaxUint64); !visited || err != nil {
+		t.Errorf("IsVisited() = %v, %v", visited, err)
+	}
+	u, _ := url.Parse("http://example.com/")
+	s.SetCookies(u, "a=b")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process resumes with the same database.
+	s = NewStorage(reopen(t, db, path))
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for id, want := range map[uint64]bool{1: true, math.MaxUint64: true, 2: false} {
+		if visited, err := s.IsVisited(id); visited != want || err != nil {
+			t.Errorf("IsVisited(%d) = %v, %v", id, visited, err)
+		}
+	}
+	if cookies := s.Cookies(u); cookies != "a=b" {
+		t.Errorf("Cookies() = %q", cookies)
+	}
+}
+
+func TestStorageInitConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "colly.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clones of a Collector share its storage and initialize it concurrently.
+	s := NewStorage(db)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Init()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Visited(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewStorage(reopen(t, db, path))
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if visited, err := s.IsVisited(1); !visited || err != nil {
+		t.Errorf("IsVisited() after concurrent Init() = %v, %v", visited, err)
+	}
+}
+
+func TestQueueStorageResume(t *testing.T) {
+	var visits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&visits, 1)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "colly.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewQueueStorage(db)
+	q, err := queue.New(2, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := q.AddURL(ts.URL + "/" + string(rune('a'+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Crash while the first request is in flight.
+	if _, r, err := s.TakeRequest(); r == nil || err != nil {
+		t.Fatalf("TakeRequest() = %q, %v", r, err)
+	}
+	if size, _ := q.Size(); size != 4 {
+		t.Fatalf("Size() = %d, want 4", size)
+	}
+	s.Close()
+
+	s = NewQueueStorage(reopen(t, db, path))
+	defer s.Close()
+	q, err = queue.New(1, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	c := colly.NewCollector()
+	c.OnRequest(func(r *colly.Request) {
+		paths = append(paths, r.URL.Path)
+	})
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+	if visits != 5 || len(paths) != 5 || paths[0] != "/a" {
+		t.Errorf("Run not resumed in order: %d requests sent, %q", visits, paths)
+	}
+	var left int64
+	s.db.Model(&queueRow{}).Count(&left)
+	if left != 0 {
+		t.Errorf("%d requests left in the queue", left)
+	}
+}
+
+func TestQueueStorageAckFlushes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "colly.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &QueueStorage{db: db, BatchSize: 100, FlushInterval: time.Hour}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRequest([]byte("parent")); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := s.TakeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{"child1", "child2"} {
+		if err := s.AddRequest([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Ack(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash without closing s.
+	s = NewQueueStorage(reopen(t, db, path))
+	defer s.Close()
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		r, err := s.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == nil {
+			break
+		}
+		got = append(got, string(r))
+	}
+	if len(got) != 2 || got[0] != "child1" || got[1] != "child2" {
+		t.Errorf("Requests after the crash = %q, want the children", got)
+	}
+}
+
+func TestQueueStorageHosts(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	server1, server2 := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer server1.Close()
+	defer server2.Close()
+
+	path := filepath.Join(t.TempDir(), "colly.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewQueueStorage(db)
+	q, err := queue.New(1, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Priority = func(r *colly.Request) int {
+		return len(r.URL.Path)
+	}
+	for _, u := range []string{server1.URL + "/a", server1.URL + "/bb", server2.URL + "/c", server2.URL + "/dd"} {
+		if err := q.AddURL(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Crash while the first request is in flight.
+	if _, r, err := s.TakeRequest(); r == nil || err != nil {
+		t.Fatalf("TakeRequest() = %q, %v", r, err)
+	}
+	s.Close()
+
+	s = NewQueueStorage(reopen(t, db, path))
+	defer s.Close()
+	q, err = queue.New(1, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.RoundRobin = true
+	var got []string
+	c := colly.NewCollector()
+	c.OnRequest(func(r *colly.Request) {
+		got = append(got, r.URL.Path)
+	})
+	if err := q.Run(c); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/bb", "/dd", "/a", "/c"}
+	if server2.Listener.Addr().String() < server1.Listener.Addr().String() {
+		want = []string{"/dd", "/bb", "/c", "/a"}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Requests run in order %q, want %q", got, want)
+	}
+}
+
+// reopen closes db and opens the database at path again.
+func reopen(t *testing.T, db *gorm.DB, path string) *gorm.DB {
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	return db
+}
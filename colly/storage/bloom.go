@@ -0,0 +1,338 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultFalsePositiveRate is the false positive rate of BloomStorage
+	// if FalsePositiveRate isn't set.
+	DefaultFalsePositiveRate = 0.001
+	// DefaultInitialCapacity is the number of requests of the first filter
+	// of BloomStorage if InitialCapacity isn't set.
+	DefaultInitialCapacity = 1 << 16
+	// tighteningRatio is the ratio between the false positive rates of
+	// consecutive filters, so that their sum stays below the target rate.
+	tighteningRatio = 0.5
+	// maxBloomBits and maxBloomHashes bound the filters read by Restore, so
+	// that a corrupt snapshot can't exhaust the memory or the CPU.
+	maxBloomBits   = 1 << 36
+	maxBloomHashes = 256
+	// restoreChunk is the number of words of a filter read at once by
+	// Restore, which grows the filter as its words are actually read.
+	restoreChunk = 1 << 16
+)
+
+var bloomMagic = [4]byte{'C', 'B', 'L', 'M'}
+
+// ErrInvalidSnapshot is returned when restoring a BloomStorage from data
+// which isn't one of its snapshots.
+var ErrInvalidSnapshot = errors.New("Invalid bloom filter snapshot")
+
+// BloomStorage is a Storage keeping visited requests in a scalable Bloom
+// filter, which uses a few bytes per request whatever the URL lengths.
+// IsVisited may report a request that wasn't visited as visited, with a
+// probability bounded by FalsePositiveRate, but never the opposite.
+// The filter grows with the number of visited requests, adding filters of
+// doubling capacities and halving false positive rates.
+// Cookies are kept in memory like in InMemoryStorage.
+type BloomStorage struct {
+	// FalsePositiveRate is the probability that an unvisited request is
+	// reported as visited, DefaultFalsePositiveRate if 0
+	FalsePositiveRate float64
+	// InitialCapacity is the number of requests of the first filter,
+	// DefaultInitialCapacity if 0
+	InitialCapacity int
+	lock            sync.RWMutex
+	filters         []*bloomFilter
+	jar             *cookiejar.Jar
+}
+
+type bloomFilter struct {
+	words    []uint64
+	m        uint64
+	k        uint64
+	capacity uint64
+	count    uint64
+}
+
+// NewBloomStorage creates a BloomStorage with the given false positive
+// rate.
+func NewBloomStorage(falsePositiveRate float64) *BloomStorage {
+	return &BloomStorage{FalsePositiveRate: falsePositiveRate}
+}
+
+// Init initializes BloomStorage
+func (s *BloomStorage) Init() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.FalsePositiveRate <= 0 || s.FalsePositiveRate >= 1 {
+		s.FalsePositiveRate = DefaultFalsePositiveRate
+	}
+	if s.InitialCapacity <= 0 {
+		s.InitialCapacity = DefaultInitialCapacity
+	}
+	if s.jar == nil {
+		var err error
+		s.jar, err = cookiejar.New(nil)
+		return err
+	}
+	return nil
+}
+
+// Visited implements Storage.Visited()
+func (s *BloomStorage) Visited(requestID uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.containsLocked(requestID) {
+		return nil
+	}
+	n := len(s.filters)
+	if n == 0 || s.filters[n-1].count >= s.filters[n-1].capacity {
+		capacity := uint64(s.InitialCapacity) << uint(n)
+		p := s.FalsePositiveRate * (1 - tighteningRatio) * math.Pow(tighteningRatio, float64(n))
+		s.filters = append(s.filters, newBloomFilter(capacity, p))
+	}
+	s.filters[len(s.filters)-1].add(requestID)
+	return nil
+}
+
+// IsVisited implements Storage.IsVisited()
+func (s *BloomStorage) IsVisited(requestID uint64) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.containsLocked(requestID), nil
+}
+
+// Cookies implements Storage.Cookies()
+func (s *BloomStorage) Cookies(u *url.URL) string {
+	return StringifyCookies(s.jar.Cookies(u))
+}
+
+// SetCookies implements Storage.SetCookies()
+func (s *BloomStorage) SetCookies(u *url.URL, cookies string) {
+	s.jar.SetCookies(u, UnstringifyCookies(cookies))
+}
+
+// Close implements Storage.Close()
+func (s *BloomStorage) Close() error {
+	return nil
+}
+
+// Len returns the approximate number of visited requests. Requests
+// mistaken for visited ones aren't counted.
+func (s *BloomStorage) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var n uint64
+	for _, f := range s.filters {
+		n += f.count
+	}
+	return int(n)
+}
+
+// MemoryUsage returns the size of the filters in bytes.
+func (s *BloomStorage) MemoryUsage() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	size := 0
+	for _, f := range s.filters {
+		size += 8 * len(f.words)
+	}
+	return size
+}
+
+// EstimatedFalsePositiveRate returns the probability that IsVisited
+// reports an unvisited request as visited, estimated from the ratio of
+// bits set in each filter.
+func (s *BloomStorage) EstimatedFalsePositiveRate() float64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	missed := 1.0
+	for _, f := range s.filters {
+		set := 0
+		for _, w := range f.words {
+			set += bits.OnesCount64(w)
+		}
+		missed *= 1 - math.Pow(float64(set)/float64(f.m), float64(f.k))
+	}
+	return 1 - missed
+}
+
+// Snapshot writes the visited requests to w, in a binary format read by
+// Restore.
+func (s *BloomStorage) Snapshot(w io.Writer) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	bw := bufio.NewWriter(w)
+	header := []interface{}{bloomMagic, uint8(1), math.Float64bits(s.FalsePositiveRate), uint64(s.InitialCapacity), uint32(len(s.filters))}
+	for _, v := range header {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.filters {
+		for _, v := range []uint64{f.m, f.k, f.capacity, f.count} {
+			if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(bw, binary.LittleEndian, f.words); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Restore replaces the visited requests by the ones of a snapshot written
+// by Snapshot, along with the settings of the filters.
+func (s *BloomStorage) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	var version uint8
+	var rate, capacity uint64
+	var n uint32
+	for _, v := range []interface{}{&magic, &version, &rate, &capacity, &n} {
+		if err := binary.Read(br, binary.LittleEndian, v); err != nil {
+			return ErrInvalidSnapshot
+		}
+	}
+	falsePositiveRate := math.Float64frombits(rate)
+	if magic != bloomMagic || version != 1 || !(falsePositiveRate > 0 && falsePositiveRate < 1) || capacity == 0 || capacity > math.MaxInt32 {
+		return ErrInvalidSnapshot
+	}
+	var filters []*bloomFilter
+	for i := uint32(0); i < n; i++ {
+		f := &bloomFilter{}
+		for _, v := range []*uint64{&f.m, &f.k, &f.capacity, &f.count} {
+			if err := binary.Read(br, binary.LittleEndian, v); err != nil {
+				return ErrInvalidSnapshot
+			}
+		}
+		if f.m == 0 || f.m%64 != 0 || f.m > maxBloomBits || f.k == 0 || f.k > maxBloomHashes || f.capacity == 0 || f.count > f.capacity {
+			return ErrInvalidSnapshot
+		}
+		// The words are read in chunks rather than allocated at once,
+		// so that the memory used is bounded by the size of the input.
+		for left := f.m / 64; left > 0; {
+			size := left
+			if size > restoreChunk {
+				size = restoreChunk
+			}
+			chunk := make([]uint64, size)
+			if err := binary.Read(br, binary.LittleEndian, chunk); err != nil {
+				return ErrInvalidSnapshot
+			}
+			f.words = append(f.words, chunk...)
+			left -= size
+		}
+		filters = append(filters, f)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.FalsePositiveRate = falsePositiveRate
+	s.InitialCapacity = int(capacity)
+	s.filters = filters
+	return nil
+}
+
+// SnapshotFile writes a snapshot to the file at path, replacing it once
+// the snapshot is complete.
+func (s *BloomStorage) SnapshotFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := s.Snapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// RestoreFile restores the snapshot of the file at path.
+func (s *BloomStorage) RestoreFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Restore(f)
+}
+
+func (s *BloomStorage) containsLocked(requestID uint64) bool {
+	for _, f := range s.filters {
+		if f.contains(requestID) {
+			return true
+		}
+	}
+	return false
+}
+
+// newBloomFilter creates a filter of the optimal size and number of
+// hashes for capacity elements and the false positive rate p.
+func newBloomFilter(capacity uint64, p float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint64(math.Ceil(math.Log2(1 / p)))
+	return &bloomFilter{words: make([]uint64, m/64), m: m, k: k, capacity: capacity}
+}
+
+func (f *bloomFilter) add(id uint64) {
+	h1, h2 := bloomHashes(id)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.words[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+func (f *bloomFilter) contains(id uint64) bool {
+	h1, h2 := bloomHashes(id)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes derives the two hashes of double hashing from a request ID,
+// which is a hash already, with the SplitMix64 finalizer.
+func bloomHashes(id uint64) (uint64, uint64) {
+	z := id + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	// A zero step would test a single bit.
+	return id, z | 1
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func requestID(i int) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte("http://example.com/page/" + strconv.Itoa(i)))
+	return h.Sum64()
+}
+
+func TestBloomStorage(t *testing.T) {
+	const n, rate = 50000, 0.01
+	s := &BloomStorage{FalsePositiveRate: rate, InitialCapacity: 1000}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		s.Visited(requestID(i))
+	}
+	for i := 0; i < n; i++ {
+		if visited, _ := s.IsVisited(requestID(i)); !visited {
+			t.Fatalf("Request %d not visited", i)
+		}
+	}
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if visited, _ := s.IsVisited(requestID(i)); visited {
+			falsePositives++
+		}
+	}
+	if got := float64(falsePositives) / n; got > rate {
+		t.Errorf("False positive rate %f above %f", got, rate)
+	}
+	if est := s.EstimatedFalsePositiveRate(); est <= 0 || est > rate {
+		t.Errorf("Wrong estimated false positive rate %f", est)
+	}
+	if s.Len() > n || s.Len() < n-falsePositives {
+		t.Errorf("Wrong length %d", s.Len())
+	}
+	// A map uses tens of bytes per request.
+	if size := s.MemoryUsage(); size == 0 || size > 4*n {
+		t.Errorf("Memory usage of %d bytes", size)
+	}
+}
+
+func TestBloomStorageSnapshot(t *testing.T) {
+	s := NewBloomStorage(0.001)
+	s.InitialCapacity = 10
+	s.Init()
+	for i := 0; i < 100; i++ {
+		s.Visited(requestID(i))
+	}
+	path := filepath.Join(t.TempDir(), "visited.bloom")
+	if err := s.SnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &BloomStorage{}
+	if err := restored.RestoreFile(path); err != nil {
+		t.Fatal(err)
+	}
+	restored.Init()
+	for i := 0; i < 100; i++ {
+		if visited, _ := restored.IsVisited(requestID(i)); !visited {
+			t.Fatalf("Request %d not restored", i)
+		}
+	}
+	if restored.FalsePositiveRate != 0.001 || restored.InitialCapacity != 10 || restored.Len() != s.Len() {
+		t.Errorf("Settings not restored: %v, %d, %d", restored.FalsePositiveRate, restored.InitialCapacity, restored.Len())
+	}
+
+	if err := restored.Restore(bytes.NewReader([]byte("not a snapshot"))); err != ErrInvalidSnapshot {
+		t.Errorf("Restore() of invalid data = %v", err)
+	}
+}
+
+func TestBloomStorageRestoreCorrupt(t *testing.T) {
+	snapshot := func(rate float64, filters ...[4]uint64) []byte {
+		var b bytes.Buffer
+		for _, v := range []interface{}{bloomMagic, uint8(1), math.Float64bits(rate), uint64(10), uint32(len(filters) + 1<<30)} {
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+		for _, f := range filters {
+			binary.Write(&b, binary.LittleEndian, f)
+			// a single word of the filter
+			binary.Write(&b, binary.LittleEndian, uint64(0))
+		}
+		return b.Bytes()
+	}
+	tests := map[string][]byte{
+		"huge filter":       snapshot(0.001, [4]uint64{1 << 62, 10, 10, 0}),
+		"truncated filter":  snapshot(0.001, [4]uint64{1 << 30, 10, 10, 0}),
+		"too many hashes":   snapshot(0.001, [4]uint64{64, 1 << 40, 10, 0}),
+		"count > capacity":  snapshot(0.001, [4]uint64{64, 10, 10, 11}),
+		"zero rate":         snapshot(0),
+		"rate of 1":         snapshot(1),
+		"NaN rate":          snapshot(math.NaN()),
+		"truncated filters": snapshot(0.001, [4]uint64{64, 10, 10, 1}),
+	}
+	for name, data := range tests {
+		s := &BloomStorage{}
+		if err := s.Restore(bytes.NewReader(data)); err != ErrInvalidSnapshot {
+			t.Errorf("Restore() of %s = %v", name, err)
+		}
+	}
+}
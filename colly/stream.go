@@ -0,0 +1,145 @@
+// Copyright 2018 Adam Tauber
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colly
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// ResponseStreamCallback is a type alias for OnResponseStream callback functions
+type ResponseStreamCallback func(r *Response, body io.Reader) error
+
+// bodyStreamKey is the key of the bodyStream of a request in its context.
+type bodyStreamKey struct{}
+
+// streamCallbackKey is the key of the ResponseStreamCallback replacing the
+// OnResponseStream callbacks for a request, in its context.
+type streamCallbackKey struct{}
+
+// bodyStream is called by the HTTP backend with a reader of the body of a
+// response, in place of reading it into Response.Body.
+type bodyStream func(resp *Response, body io.Reader) error
+
+// OnResponseStream registers a function. Function will be executed on every
+// response with a reader of its body, which isn't read into Response.Body
+// then, so that bodies of any size can be processed, or saved to disk, as
+// they're received.
+//
+// Callbacks are called in the order they were registered, and share the same
+// reader: each one reads what the previous ones left. An error returned by a
+// callback aborts the transfer and is passed to OnError callbacks. Once the
+// callbacks were called, the request isn't retried by the RetryPolicy, as
+// they can't be passed the body again: the RetriesKey of the Ctx of the
+// response tells how many attempts failed before.
+// MaxBodySize still limits the body read: Response.Truncated tells whether
+// the body was cut once the reader is consumed.
+//
+// Like OnResponse callbacks, they aren't called for error statuses unless
+// ParseHTTPErrorResponse is set. OnResponse and OnScraped callbacks are
+// called afterwards with an empty body. Responses of Fetchers other than the
+// default HTTP one are passed with a reader of their body once fetched, and
+// streamed responses aren't cached.
+func (c *Collector) OnResponseStream(f ResponseStreamCallback) {
+	c.lock.Lock()
+	c.responseStreamCallbacks = append(c.responseStreamCallbacks, f)
+	c.lock.Unlock()
+}
+
+// streamRequest returns the context of a request bound to goCtx, whose
+// response is streamed to the OnResponseStream callbacks or to the
+// callback of a download, along with the context of the requests made
+// from its callbacks. It returns goCtx twice if the response isn't
+// streamed.
+func (c *Collector) streamRequest(goCtx context.Context, request *Request) (context.Context, context.Context) {
+	callbacks := c.responseStreamCallbacks
+	var download bool
+	if f, ok := goCtx.Value(streamCallbackKey{}).(ResponseStreamCallback); ok {
+		callbacks = []ResponseStreamCallback{f}
+		download = true
+		// requests made from the callbacks of a download aren't
+		// downloads
+		goCtx = context.WithValue(goCtx, streamCallbackKey{}, nil)
+	}
+	if len(callbacks) == 0 {
+		return goCtx, goCtx
+	}
+	stream := bodyStream(func(resp *Response, body io.Reader) error {
+		resp.Ctx = request.Ctx
+		resp.Request = request
+		resp.streamed = true
+		if !download && !c.ParseHTTPErrorResponse && !isSuccess(resp.StatusCode, request) {
+			return nil
+		}
+		// Downloads rewrite what failed attempts wrote, callbacks
+		// can't.
+		resp.unreplayable = !download
+		for _, f := range callbacks {
+			if err := f(resp, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return context.WithValue(goCtx, bodyStreamKey{}, stream), goCtx
+}
+
+// streamBody streams the body of a response which was read in memory by
+// its Fetcher, if its request asked for a stream.
+func streamBody(req *http.Request, resp *Response) error {
+	stream, ok := req.Context().Value(bodyStreamKey{}).(bodyStream)
+	if !ok || resp.streamed {
+		return nil
+	}
+	return stream(resp, bytes.NewReader(resp.Body))
+}
+
+// isSuccess reports whether a response status is reported to OnResponse
+// callbacks rather than OnError ones. Partial contents are a success for
+// Range requests.
+func isSuccess(statusCode int, request *Request) bool {
+	if statusCode < 203 {
+		return true
+	}
+	return statusCode == http.StatusPartialContent && request.Headers != nil && request.Headers.Get("Range") != ""
+}
+
+// limitedReader reads at most n bytes of r, like an io.LimitedReader, and
+// sets the Truncated flag of response if r has more.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	response *Response
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		if !l.response.Truncated {
+			var b [1]byte
+			if n, _ := io.ReadFull(l.r, b[:]); n > 0 {
+				l.response.Truncated = true
+			}
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -1,6 +1,12 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {browser} from '../models';
 import {main} from '../models';
+import {googlesearch} from '../models';
+
+export function BrowserProfiles():Promise<main.BrowserProfileList>;
+
+export function BrowserStatus():Promise<browser.Status>;
 
 export function DeleteDialog(arg1:number):Promise<void>;
 
@@ -8,4 +14,12 @@ export function GetDialogs():Promise<Array<main.Dialog>>;
 
 export function GetMessages(arg1:number):Promise<Array<main.Message>>;
 
+export function OpenLoginWindow(arg1:string):Promise<void>;
+
+export function SearchCacheStats():Promise<googlesearch.CacheStats>;
+
+export function SearchEngineStatus():Promise<Array<googlesearch.EngineStatus>>;
+
 export function SendMessage(arg1:number,arg2:string):Promise<main.SendResp>;
+
+export function SwitchBrowserProfile(arg1:string):Promise<void>;
@@ -2,6 +2,14 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function BrowserProfiles() {
+  return window['go']['main']['App']['BrowserProfiles']();
+}
+
+export function BrowserStatus() {
+  return window['go']['main']['App']['BrowserStatus']();
+}
+
 export function DeleteDialog(arg1) {
   return window['go']['main']['App']['DeleteDialog'](arg1);
 }
@@ -14,6 +22,22 @@ export function GetMessages(arg1) {
   return window['go']['main']['App']['GetMessages'](arg1);
 }
 
+export function OpenLoginWindow(arg1) {
+  return window['go']['main']['App']['OpenLoginWindow'](arg1);
+}
+
+export function SearchCacheStats() {
+  return window['go']['main']['App']['SearchCacheStats']();
+}
+
+export function SearchEngineStatus() {
+  return window['go']['main']['App']['SearchEngineStatus']();
+}
+
 export function SendMessage(arg1, arg2) {
   return window['go']['main']['App']['SendMessage'](arg1, arg2);
 }
+
+export function SwitchBrowserProfile(arg1) {
+  return window['go']['main']['App']['SwitchBrowserProfile'](arg1);
+}
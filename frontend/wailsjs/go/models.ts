@@ -1,5 +1,112 @@
+export namespace browser {
+	
+	export class Status {
+	    running: boolean;
+	    pid: number;
+	    size: number;
+	    busy: number;
+	    idle: number;
+	    starts: number;
+	    recycled: number;
+	    replaced: number;
+	    lastError: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Status(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.running = source["running"];
+	        this.pid = source["pid"];
+	        this.size = source["size"];
+	        this.busy = source["busy"];
+	        this.idle = source["idle"];
+	        this.starts = source["starts"];
+	        this.recycled = source["recycled"];
+	        this.replaced = source["replaced"];
+	        this.lastError = source["lastError"];
+	    }
+	}
+
+}
+
+export namespace googlesearch {
+	
+	export class CacheStats {
+	    hits: number;
+	    misses: number;
+	    entries: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new CacheStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.hits = source["hits"];
+	        this.misses = source["misses"];
+	        this.entries = source["entries"];
+	    }
+	}
+	export class EngineStatus {
+	    engine: string;
+	    limit: number;
+	    baseLimit: number;
+	    blocks: number;
+	    // Go type: time
+	    cooldownUntil: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new EngineStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.engine = source["engine"];
+	        this.limit = source["limit"];
+	        this.baseLimit = source["baseLimit"];
+	        this.blocks = source["blocks"];
+	        this.cooldownUntil = this.convertValues(source["cooldownUntil"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
 export namespace main {
 	
+	export class BrowserProfileList {
+	    current: string;
+	    names: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new BrowserProfileList(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.current = source["current"];
+	        this.names = source["names"];
+	    }
+	}
 	export class Dialog {
 	    ID: number;
 	    Title: string;
@@ -53,6 +160,32 @@ export namespace main {
 	        this.Content = source["Content"];
 	    }
 	}
+	export class MessageViewItem {
+	    id?: number;
+	    dialog_id?: number;
+	    role?: string;
+	    content?: string;
+	    tool_calls?: number[];
+	    tool_call_id?: string;
+	    name?: string;
+	    screenshot?: number[];
+	
+	    static createFrom(source: any = {}) {
+	        return new MessageViewItem(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.dialog_id = source["dialog_id"];
+	        this.role = source["role"];
+	        this.content = source["content"];
+	        this.tool_calls = source["tool_calls"];
+	        this.tool_call_id = source["tool_call_id"];
+	        this.name = source["name"];
+	        this.screenshot = source["screenshot"];
+	    }
+	}
 	export class SendResp {
 	    newDid: number;
 	    reply: string;
@@ -6,6 +6,7 @@ toolchain go1.24.5
 
 require (
 	github.com/PuerkitoBio/goquery v1.10.3
+	github.com/andybalholm/brotli v1.2.0
 	github.com/antchfx/htmlquery v1.3.4
 	github.com/antchfx/xmlquery v1.4.4
 	github.com/chromedp/cdproto v0.0.0-20250724212937-08a3db8b4327
@@ -15,6 +16,7 @@ require (
 	github.com/jawher/mow.cli v1.2.0
 	github.com/joho/godotenv v1.5.1
 	github.com/kennygrant/sanitize v1.2.4
+	github.com/klauspost/compress v1.18.0
 	github.com/labstack/gommon v0.4.2
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d
 	github.com/temoto/robotstxt v1.1.2
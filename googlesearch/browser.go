@@ -0,0 +1,116 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"context"
+	"errors"
+	"kimi-chat/browser"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// ErrNoBrowser is returned when the context passed to Search doesn't carry a chromedp browser.
+var ErrNoBrowser = errors.New("ctx is not a chromedp context")
+
+// newSearchContext returns a chromedp context for a single search. The search runs in a new
+// incognito browser context, so cookies don't leak between searches, which uses the proxy
+// and fingerprint requested in opt. The returned cancel function disposes of the browser
+// context and must always be called.
+func newSearchContext(parent context.Context, opt SearchOptions) (context.Context, context.CancelFunc, error) {
+	c := chromedp.FromContext(parent)
+	if c == nil {
+		return nil, nil, ErrNoBrowser
+	}
+	if c.Browser == nil {
+		// Start the browser, WithNewBrowserContext requires it.
+		if err := chromedp.Run(parent); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var (
+		bp    *browserProxy
+		relay *socksRelay
+		err   error
+	)
+	if opt.ProxyAddr != "" {
+		if bp, err = parseProxy(opt.ProxyAddr); err != nil {
+			return nil, nil, err
+		}
+		if bp.socks && bp.hasAuth() {
+			if relay, err = newSocksRelay(bp); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	ctx, cancel := chromedp.NewContext(parent, chromedp.WithNewBrowserContext(
+		func(p *target.CreateBrowserContextParams) *target.CreateBrowserContextParams {
+			switch {
+			case relay != nil:
+				return p.WithProxyServer(relay.Addr())
+			case bp != nil:
+				return p.WithProxyServer(bp.server)
+			}
+			return p
+		},
+	))
+	release := func() {
+		cancel()
+		if relay != nil {
+			relay.Close()
+		}
+	}
+
+	actions := []chromedp.Action{searchProfile(opt).Apply()}
+	switch {
+	case relay != nil:
+		handleProxyAuth(ctx, relay.username, relay.password)
+		actions = append(actions, fetch.Enable().WithHandleAuthRequests(true))
+	case bp != nil && bp.hasAuth():
+		handleProxyAuth(ctx, bp.username, bp.password)
+		actions = append(actions, fetch.Enable().WithHandleAuthRequests(true))
+	}
+	if err := chromedp.Run(ctx, actions...); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return ctx, release, nil
+}
+
+// handleProxyAuth answers proxy authentication challenges in ctx with the given
+// credentials. With auth handling enabled, the Fetch domain pauses every request, so
+// paused requests are continued unchanged.
+func handleProxyAuth(ctx context.Context, username, password string) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *fetch.EventAuthRequired:
+			resp := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+			if e.AuthChallenge.Source == fetch.AuthChallengeSourceProxy {
+				resp = &fetch.AuthChallengeResponse{
+					Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+					Username: username,
+					Password: password,
+				}
+			}
+			go chromedp.Run(ctx, fetch.ContinueWithAuth(e.RequestID, resp))
+		case *fetch.EventRequestPaused:
+			go chromedp.Run(ctx, fetch.ContinueRequest(e.RequestID))
+		}
+	})
+}
+
+// searchProfile returns the fingerprint of a search with opt.
+func searchProfile(opt SearchOptions) browser.Profile {
+	profile := browser.DefaultProfile
+	if opt.Profile != nil {
+		profile = *opt.Profile
+	}
+	if opt.UserAgent != "" {
+		profile.UserAgent = opt.UserAgent
+	}
+	return profile.WithLanguage(opt.LanguageCode)
+}
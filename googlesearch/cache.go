@@ -0,0 +1,206 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultCacheTTL is how long search results are kept when a Cache is created with a zero TTL.
+const DefaultCacheTTL = 10 * time.Minute
+
+// Cache stores search results for a limited time, keyed on the normalised query and the
+// options that influence the result page. Set SearchOptions.BypassCache to skip it for a
+// single query.
+//
+// Entries are always kept in memory. When a database is supplied to NewCache, entries are
+// also written through to it so that they survive a restart.
+type Cache struct {
+
+	// TTL sets how long an entry stays fresh.
+	TTL time.Duration
+
+	db      *gorm.DB
+	now     func() time.Time
+	lock    sync.RWMutex
+	entries map[string]cacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+// CacheStats reports the effectiveness of a Cache.
+type CacheStats struct {
+
+	// Hits is the number of lookups answered from the cache.
+	Hits uint64 `json:"hits"`
+
+	// Misses is the number of lookups that required a fresh search.
+	Misses uint64 `json:"misses"`
+
+	// Entries is the number of results sets currently held in memory.
+	Entries int `json:"entries"`
+}
+
+type cacheEntry struct {
+	results   []Result
+	expiresAt time.Time
+}
+
+// searchCacheRow is the SQLite representation of a cache entry.
+type searchCacheRow struct {
+	Key       string    `gorm:"column:cache_key;primarykey"`
+	Results   []byte    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (searchCacheRow) TableName() string {
+	return "search_cache"
+}
+
+// NewCache creates a Cache with the given TTL. If db is not nil, entries are persisted
+// in a "search_cache" table of that database.
+func NewCache(ttl time.Duration, db *gorm.DB) (*Cache, error) {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	if db != nil {
+		if err := db.AutoMigrate(&searchCacheRow{}); err != nil {
+			return nil, err
+		}
+		db.Where("expires_at < ?", time.Now()).Delete(&searchCacheRow{})
+	}
+	return &Cache{TTL: ttl, db: db, now: time.Now}, nil
+}
+
+// Get returns the cached results for key. Expired entries are treated as missing.
+func (c *Cache) Get(key string) ([]Result, bool) {
+	now := c.clock()
+	c.lock.RLock()
+	e, ok := c.entries[key]
+	c.lock.RUnlock()
+
+	if !ok && c.db != nil {
+		var row searchCacheRow
+		if err := c.db.Where("cache_key = ? AND expires_at > ?", key, now).Take(&row).Error; err == nil {
+			if json.Unmarshal(row.Results, &e.results) == nil {
+				e.expiresAt = row.ExpiresAt
+				ok = true
+				c.store(key, e)
+			}
+		}
+	}
+
+	if !ok || now.After(e.expiresAt) {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+
+	// Hand out a copy so callers can't modify the cached slice.
+	results := make([]Result, len(e.results))
+	copy(results, e.results)
+	return results, true
+}
+
+// Set stores results under key for the cache's TTL.
+func (c *Cache) Set(key string, results []Result) error {
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	e := cacheEntry{results: make([]Result, len(results)), expiresAt: c.clock().Add(ttl)}
+	copy(e.results, results)
+	c.store(key, e)
+
+	if c.db == nil {
+		return nil
+	}
+	buf, err := json.Marshal(e.results)
+	if err != nil {
+		return err
+	}
+	row := searchCacheRow{Key: key, Results: buf, ExpiresAt: e.expiresAt}
+	return c.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error
+}
+
+// Purge removes every entry from the cache, including persisted ones.
+func (c *Cache) Purge() error {
+	c.lock.Lock()
+	c.entries = nil
+	c.lock.Unlock()
+
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Where("1 = 1").Delete(&searchCacheRow{}).Error
+}
+
+// Stats returns the hit and miss counters of the cache.
+func (c *Cache) Stats() CacheStats {
+	c.lock.RLock()
+	n := len(c.entries)
+	c.lock.RUnlock()
+	return CacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: n,
+	}
+}
+
+func (c *Cache) store(key string, e cacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	// Drop stale entries while we hold the lock anyway.
+	now := c.clock()
+	for k, v := range c.entries {
+		if now.After(v.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+}
+
+// clock returns the current time, which tests can fake.
+func (c *Cache) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// CacheKey returns the key under which the results of searchTerm are cached.
+// The query is normalised so that differences in case and whitespace share an entry.
+// Searches with filters are keyed on them too.
+func CacheKey(searchTerm string, opts SearchOptions) string {
+	query := strings.Join(strings.Fields(strings.ToLower(searchTerm)), " ")
+	engine := opts.Engine
+	if engine == "" {
+		engine = Yandex
+	}
+	lc := opts.LanguageCode
+	if lc == "" {
+		lc = "en"
+	}
+	key := fmt.Sprintf("%s|%s|%s|%d|%s",
+		engine,
+		strings.ToLower(opts.CountryCode),
+		strings.ToLower(lc),
+		opts.Start,
+		query,
+	)
+	if filters := filterKey(opts); filters != "" {
+		key += "|" + filters
+	}
+	return key
+}
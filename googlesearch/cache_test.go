@@ -0,0 +1,125 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		a, b SearchOptions
+		qa   string
+		qb   string
+		same bool
+	}{
+		{SearchOptions{}, SearchOptions{Engine: Yandex, LanguageCode: "en"}, "golang", "golang", true},
+		{SearchOptions{}, SearchOptions{}, " Golang  Generics ", "golang generics", true},
+		{SearchOptions{CountryCode: "HK"}, SearchOptions{CountryCode: "hk"}, "go", "go", true},
+		{SearchOptions{Sites: []string{"Go.dev"}}, SearchOptions{Sites: []string{"go.dev"}}, "go", "go", true},
+		{SearchOptions{}, SearchOptions{Engine: Google}, "go", "go", false},
+		{SearchOptions{}, SearchOptions{Start: 10}, "go", "go", false},
+		{SearchOptions{}, SearchOptions{LanguageCode: "fr"}, "go", "go", false},
+		{SearchOptions{}, SearchOptions{FileType: "pdf"}, "go", "go", false},
+		{SearchOptions{}, SearchOptions{}, "go lang", "golang", false},
+	}
+	for _, tt := range tests {
+		ka, kb := CacheKey(tt.qa, tt.a), CacheKey(tt.qb, tt.b)
+		if (ka == kb) != tt.same {
+			t.Errorf("CacheKey(%q, %+v) = %q, CacheKey(%q, %+v) = %q, want same %v", tt.qa, tt.a, ka, tt.qb, tt.b, kb, tt.same)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c, err := NewCache(time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("Get() of an empty cache hit")
+	}
+	results := []Result{{Rank: 1, URL: "https://go.dev/", Title: "Go"}}
+	if err := c.Set("k", results); err != nil {
+		t.Fatal(err)
+	}
+	results[0].Title = "changed"
+	got, ok := c.Get("k")
+	if !ok || len(got) != 1 || got[0].Title != "Go" {
+		t.Fatalf("Get() = %v, %v", got, ok)
+	}
+	got[0].Title = "changed"
+	if got, _ := c.Get("k"); got[0].Title != "Go" {
+		t.Error("Cached results modified by the caller")
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if _, ok := c.Get("k"); ok {
+		t.Error("Get() of an expired entry hit")
+	}
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	// Stale entries are dropped by the next Set.
+	c.Set("other", nil)
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Errorf("Stats() after Set() = %+v, want the expired entry dropped", stats)
+	}
+	if err := c.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("Stats() after Purge() = %+v", stats)
+	}
+}
+
+func TestCachePersistence(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "cache.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	c, err := NewCache(time.Minute, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("fresh", []Result{{Rank: 1, URL: "https://go.dev/"}}); err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := c.Set("expired", []Result{{Rank: 1, URL: "https://go.dev/"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted cache reads the entries written by the previous one.
+	c, err = NewCache(time.Minute, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get("fresh"); !ok || len(got) != 1 || got[0].URL != "https://go.dev/" {
+		t.Errorf("Get() of a persisted entry = %v, %v", got, ok)
+	}
+	if _, ok := c.Get("expired"); ok {
+		t.Error("Get() of an expired persisted entry hit")
+	}
+	if err := c.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("fresh"); ok {
+		t.Error("Get() after Purge() hit")
+	}
+}
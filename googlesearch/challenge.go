@@ -0,0 +1,304 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kimi-chat/browser"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// PageKind classifies a page loaded while searching.
+type PageKind string
+
+// Kinds of pages an engine may answer a search with.
+const (
+	// PageUnknown is a page that hasn't been recognised (yet), e.g. while it is loading.
+	PageUnknown PageKind = "unknown"
+
+	// PageResults is a result page, possibly without any result.
+	PageResults PageKind = "results"
+
+	// PageCheckboxCaptcha asks to tick an "I'm not a robot" box.
+	PageCheckboxCaptcha PageKind = "checkbox_captcha"
+
+	// PageImageCaptcha asks to type a text or pick images.
+	PageImageCaptcha PageKind = "image_captcha"
+
+	// PageConsent asks to accept cookies before searching.
+	PageConsent PageKind = "consent"
+
+	// PageBlocked refuses to serve the search at all.
+	PageBlocked PageKind = "blocked"
+)
+
+// ErrUnrecognizedPage is returned when a page shows neither results nor a known challenge
+// within Client.PageTimeout, e.g. a half-loaded challenge or an unknown block page.
+var ErrUnrecognizedPage = errors.New("unrecognized search page")
+
+const (
+	// DefaultPageTimeout is how long Client waits for a page to show results or a challenge.
+	DefaultPageTimeout = 15 * time.Second
+
+	// DefaultManualTimeout is how long Manual waits for the user to solve a challenge.
+	DefaultManualTimeout = 5 * time.Minute
+
+	// pollInterval is how often a loading page is classified again.
+	pollInterval = 250 * time.Millisecond
+
+	// maxChallenges bounds how many challenges are resolved for a single search.
+	maxChallenges = 3
+)
+
+// Markers of challenge pages, common to all engines.
+var (
+	checkboxCaptchaSelectors = []string{
+		".CheckboxCaptcha",
+		"iframe[src*='recaptcha/api2/anchor']",
+		"iframe[src*='recaptcha/enterprise/anchor']",
+		"iframe[src*='hcaptcha.com']",
+		"iframe[src*='challenges.cloudflare.com']",
+	}
+	imageCaptchaSelectors = []string{
+		".AdvancedCaptcha",
+		".anomaly-modal__modal",
+		"img[src*='captcha']",
+	}
+	consentSelectors = []string{
+		"form[action*='consent.google.']",
+		"form[action*='consent.yandex.']",
+		".gdpr-popup-v3-main",
+	}
+	blockedPhrases = []string{
+		"our systems have detected unusual traffic",
+		"access denied",
+		"too many requests",
+		"you have been blocked",
+		"request blocked",
+	}
+)
+
+// autoClickSelectors are the buttons AutoClick tries, in order, for each kind of challenge.
+var autoClickSelectors = map[PageKind][]string{
+	PageCheckboxCaptcha: {".CheckboxCaptcha-Button", ".CheckboxCaptcha-Anchor input"},
+	PageConsent: {
+		"button#L2AGLb",
+		"form[action*='consent.google.'] button",
+		".gdpr-popup-v3-button_id_all",
+		"form[action*='consent.yandex.'] button",
+	},
+}
+
+// Challenge describes a page shown instead of search results.
+type Challenge struct {
+	Engine Engine
+	Kind   PageKind
+
+	// URL is the address of the challenge page.
+	URL string
+
+	// SearchURL is the address of the result page that was requested.
+	SearchURL string
+}
+
+// ChallengeStrategy gets past challenges shown instead of search results.
+type ChallengeStrategy interface {
+	// Resolve deals with challenge c on the page loaded in ctx. After it returns nil the
+	// page is classified again; an error aborts the search. Errors wrapping ErrBlocked
+	// make the Client back off from the engine.
+	Resolve(ctx context.Context, c Challenge) error
+}
+
+// Abort gives up on every challenge with an error wrapping ErrBlocked.
+type Abort struct{}
+
+// Resolve implements ChallengeStrategy.
+func (Abort) Resolve(ctx context.Context, c Challenge) error {
+	return fmt.Errorf("%w: %s shows %s", ErrBlocked, c.Engine, c.Kind)
+}
+
+// AutoClick clicks through checkbox captchas and consent walls. Other challenges are
+// aborted with an error wrapping ErrBlocked.
+type AutoClick struct{}
+
+// Resolve implements ChallengeStrategy.
+func (AutoClick) Resolve(ctx context.Context, c Challenge) error {
+	for _, sel := range autoClickSelectors[c.Kind] {
+		var nodes []*cdp.Node
+		if err := chromedp.Run(ctx, chromedp.Nodes(sel, &nodes, chromedp.ByQuery, chromedp.AtLeast(0))); err != nil {
+			return err
+		}
+		if len(nodes) == 0 {
+			continue
+		}
+		return chromedp.Run(ctx, chromedp.MouseClickNode(nodes[0]))
+	}
+	return Abort{}.Resolve(ctx, c)
+}
+
+// Manual surfaces challenges to the user in a visible browser window and waits for
+// them to be solved there. The cookies earned are then copied into the search and
+// its result page is loaded again. Challenges tied to the IP address of a proxy can't
+// be solved this way, as the window doesn't use the proxy.
+type Manual struct {
+
+	// Browser opens the window shown to the user. It should be created with
+	// browser.Options.Visible set.
+	Browser *browser.Manager
+
+	// Timeout bounds how long the user has to solve a challenge.
+	// Default: DefaultManualTimeout
+	Timeout time.Duration
+
+	// Notify, if set, is called when a window is surfaced, e.g. to tell the user.
+	Notify func(Challenge)
+}
+
+// Resolve implements ChallengeStrategy.
+func (m *Manual) Resolve(ctx context.Context, c Challenge) error {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultManualTimeout
+	}
+	if m.Notify != nil {
+		m.Notify(c)
+	}
+	log.Printf("googlesearch: waiting up to %s for a %s on %s to be solved", timeout, c.Kind, c.Engine)
+
+	var cookies []*network.Cookie
+	err := m.Browser.Run(ctx, func(wctx context.Context) error {
+		wctx, cancel := context.WithTimeout(wctx, timeout)
+		defer cancel()
+		if err := chromedp.Run(wctx, chromedp.Navigate(c.URL)); err != nil {
+			return err
+		}
+		for {
+			kind, _, _, err := waitForPage(wctx, c.Engine, pollInterval)
+			if err != nil {
+				return err
+			}
+			if kind == PageResults {
+				break
+			}
+			select {
+			case <-wctx.Done():
+				return wctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+		return chromedp.Run(wctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			cookies, err = network.GetCookies().WithURLs([]string{c.URL, c.SearchURL}).Do(ctx)
+			return err
+		}))
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s not solved: %v", ErrBlocked, c.Kind, err)
+	}
+
+	return chromedp.Run(ctx,
+		network.SetCookies(browser.CookieParams(cookies)),
+		chromedp.Navigate(c.SearchURL),
+	)
+}
+
+// Chain tries each strategy in turn until one resolves the challenge.
+func Chain(strategies ...ChallengeStrategy) ChallengeStrategy {
+	return chain(strategies)
+}
+
+type chain []ChallengeStrategy
+
+// Resolve implements ChallengeStrategy.
+func (ch chain) Resolve(ctx context.Context, c Challenge) error {
+	err := Abort{}.Resolve(ctx, c)
+	for _, s := range ch {
+		if err = s.Resolve(ctx, c); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// detectPage classifies the page at pageURL with content doc, loaded while searching engine.
+func detectPage(engine Engine, pageURL string, doc *goquery.Document) PageKind {
+	spec := engines[engine]
+	if doc.Find(spec.results).Length() > 0 {
+		return PageResults
+	}
+	if matchAny(doc, imageCaptchaSelectors) {
+		return PageImageCaptcha
+	}
+	if matchAny(doc, checkboxCaptchaSelectors) {
+		return PageCheckboxCaptcha
+	}
+
+	u, _ := url.Parse(pageURL)
+	if u != nil && strings.HasPrefix(u.Host, "consent.") || matchAny(doc, consentSelectors) {
+		return PageConsent
+	}
+	if u != nil && strings.HasPrefix(u.Path, "/sorry/") {
+		return PageBlocked
+	}
+	if spec.empty != "" && doc.Find(spec.empty).Length() > 0 {
+		return PageResults
+	}
+	text := strings.ToLower(doc.Find("body").Text())
+	for _, phrase := range blockedPhrases {
+		if strings.Contains(text, phrase) {
+			return PageBlocked
+		}
+	}
+	return PageUnknown
+}
+
+// waitForPage classifies the page loaded in ctx until it is recognised or timeout elapses,
+// in which case PageUnknown is returned with the last content.
+func waitForPage(ctx context.Context, engine Engine, timeout time.Duration) (PageKind, *goquery.Document, string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var location, html string
+		err := chromedp.Run(ctx,
+			chromedp.Location(&location),
+			chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+		)
+		if err != nil {
+			return PageUnknown, nil, "", err
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+		if err != nil {
+			return PageUnknown, nil, "", err
+		}
+		kind := detectPage(engine, location, doc)
+		if kind != PageUnknown || !time.Now().Before(deadline) {
+			return kind, doc, location, nil
+		}
+		select {
+		case <-ctx.Done():
+			return PageUnknown, nil, "", ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func matchAny(doc *goquery.Document, selectors []string) bool {
+	for _, sel := range selectors {
+		if doc.Find(sel).Length() > 0 {
+			return true
+		}
+	}
+	return false
+}
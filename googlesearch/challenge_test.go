@@ -0,0 +1,168 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestDetectPage(t *testing.T) {
+	tests := []struct {
+		engine Engine
+		url    string
+		body   string
+		want   PageKind
+	}{
+		{
+			Yandex, "https://yandex.com/search/?text=go",
+			`<ul id="search-result"><li><a href="https://go.dev/"><span class="OrganicTitle-LinkText">Go</span></a></li></ul>`,
+			PageResults,
+		},
+		{
+			Yandex, "https://yandex.com/showcaptcha?retpath=x",
+			`<form><div class="CheckboxCaptcha"><input class="CheckboxCaptcha-Button" type="submit"></div></form>`,
+			PageCheckboxCaptcha,
+		},
+		{
+			Yandex, "https://yandex.com/showcaptcha?retpath=x",
+			`<form><div class="AdvancedCaptcha"><img src="https://yandex.com/captchaimg?x"></div></form>`,
+			PageImageCaptcha,
+		},
+		{
+			Google, "https://www.google.com/sorry/index?continue=x",
+			`<form id="captcha-form"><iframe src="https://www.google.com/recaptcha/api2/anchor?k=x"></iframe></form>`,
+			PageCheckboxCaptcha,
+		},
+		{
+			Google, "https://www.google.com/sorry/index?continue=x",
+			`<p>Our systems have detected unusual traffic from your computer network.</p>`,
+			PageBlocked,
+		},
+		{
+			Google, "https://consent.google.com/ml?continue=x",
+			`<form action="https://consent.google.com/save"><button>Accept all</button></form>`,
+			PageConsent,
+		},
+		{
+			Bing, "https://www.bing.com/search?q=x",
+			`<ol id="b_results"><li class="b_no"><h1>There are no results for x</h1></li></ol>`,
+			PageResults,
+		},
+		{
+			DuckDuckGo, "https://html.duckduckgo.com/html/?q=x",
+			`<div class="anomaly-modal__modal"><p>Select all squares containing a duck</p></div>`,
+			PageImageCaptcha,
+		},
+		{
+			Bing, "https://www.bing.com/search?q=x",
+			`<h1>Access Denied</h1>`,
+			PageBlocked,
+		},
+		{
+			Google, "https://www.google.com/search?q=x",
+			`<div id="search"></div>`,
+			PageUnknown,
+		},
+	}
+	for _, tt := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>" + tt.body + "</body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := detectPage(tt.engine, tt.url, doc); got != tt.want {
+			t.Errorf("detectPage(%s, %q) = %s, want %s", tt.engine, tt.url, got, tt.want)
+		}
+	}
+}
+
+type resolveFunc func(ctx context.Context, c Challenge) error
+
+func (f resolveFunc) Resolve(ctx context.Context, c Challenge) error {
+	return f(ctx, c)
+}
+
+func TestChain(t *testing.T) {
+	var tried []string
+	failing := resolveFunc(func(ctx context.Context, c Challenge) error {
+		tried = append(tried, "failing")
+		return Abort{}.Resolve(ctx, c)
+	})
+	solving := resolveFunc(func(ctx context.Context, c Challenge) error {
+		tried = append(tried, "solving")
+		return nil
+	})
+	c := Challenge{Engine: Google, Kind: PageImageCaptcha}
+
+	if err := Chain(failing, solving).Resolve(context.Background(), c); err != nil {
+		t.Errorf("Chain(failing, solving): unexpected error %v", err)
+	}
+	if strings.Join(tried, ",") != "failing,solving" {
+		t.Errorf("tried %q, want failing then solving", tried)
+	}
+	if err := Chain(failing).Resolve(context.Background(), c); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Chain(failing): got error %v, want %v", err, ErrBlocked)
+	}
+}
+
+// pageSequence returns a next function for resolvePages serving the given pages in turn,
+// the last one repeatedly.
+func pageSequence(t *testing.T, engine Engine, pages ...PageKind) func() (PageKind, *goquery.Document, string, error) {
+	bodies := map[PageKind]string{
+		PageResults:         `<ul id="search-result"><li><a href="https://go.dev/"><span class="OrganicTitle-LinkText">Go</span></a></li></ul>`,
+		PageCheckboxCaptcha: `<div class="CheckboxCaptcha"></div>`,
+		PageUnknown:         `<div class="spinner"></div>`,
+	}
+	return func() (PageKind, *goquery.Document, string, error) {
+		kind := pages[0]
+		if len(pages) > 1 {
+			pages = pages[1:]
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>" + bodies[kind] + "</body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := detectPage(engine, "https://yandex.com/search/?text=go", doc); got != kind {
+			t.Fatalf("test page %s detected as %s", kind, got)
+		}
+		return kind, doc, "https://yandex.com/search/?text=go", nil
+	}
+}
+
+func TestResolvePages(t *testing.T) {
+	tests := []struct {
+		name     string
+		pages    []PageKind
+		results  int
+		resolved int
+		kind     PageKind
+		err      error
+	}{
+		{"results", []PageKind{PageResults}, 1, 0, "", nil},
+		{"captcha resolved", []PageKind{PageCheckboxCaptcha, PageResults}, 1, 1, PageCheckboxCaptcha, nil},
+		{"captcha repeated", []PageKind{PageCheckboxCaptcha}, 0, maxChallenges, PageCheckboxCaptcha, ErrBlocked},
+		{"unrecognized", []PageKind{PageUnknown}, 0, 0, "", ErrUnrecognizedPage},
+		{"unrecognized after captcha", []PageKind{PageCheckboxCaptcha, PageUnknown}, 0, 1, PageCheckboxCaptcha, ErrUnrecognizedPage},
+	}
+	for _, tt := range tests {
+		resolved := 0
+		strategy := resolveFunc(func(ctx context.Context, c Challenge) error {
+			resolved++
+			return nil
+		})
+		results, kind, err := resolvePages(context.Background(), Yandex, "https://yandex.com/search/?text=go", strategy, pageSequence(t, Yandex, tt.pages...))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+		if kind != tt.kind {
+			t.Errorf("%s: got challenge %q, want %q", tt.name, kind, tt.kind)
+		}
+		if len(results) != tt.results || resolved != tt.resolved {
+			t.Errorf("%s: got %d results after %d challenges, want %d after %d", tt.name, len(results), resolved, tt.results, tt.resolved)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultClient is the Client used by the package level Search function.
+var DefaultClient = NewClient(&Cache{TTL: DefaultCacheTTL})
+
+// Client performs searches with its own result cache, per-engine rate limiters and
+// adaptive backoff. A Client is safe for concurrent use and should be reused rather
+// than created per search, as its limiters only work across calls.
+type Client struct {
+
+	// Cache is consulted before every search. Leave it nil to disable caching.
+	Cache *Cache
+
+	// RateLimits sets how many requests per second may be sent to each engine.
+	// Engines without an entry use DefaultRateLimit.
+	// Changes only apply to engines that haven't been searched yet.
+	RateLimits map[Engine]rate.Limit
+
+	// MinBackoff is the cool-down after an engine first blocks the client.
+	// Default: DefaultMinBackoff
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the cool-down after repeated blocks.
+	// Default: DefaultMaxBackoff
+	MaxBackoff time.Duration
+
+	// Challenges gets past captchas and consent walls shown instead of results.
+	// Default: AutoClick
+	Challenges ChallengeStrategy
+
+	// PageTimeout bounds how long a page may take to show results or a challenge.
+	// Default: DefaultPageTimeout
+	PageTimeout time.Duration
+
+	lock    sync.Mutex
+	engines map[Engine]*engineThrottle
+	now     func() time.Time
+}
+
+// NewClient creates a Client using cache for results. Pass nil to disable caching.
+func NewClient(cache *Cache) *Client {
+	return &Client{
+		Cache:      cache,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// Search returns a list of search results.
+//
+// ctx must be a chromedp context. Searches are throttled per engine; while an engine
+// is cooling down after a block, Search returns an error wrapping ErrCoolingDown
+// without contacting it. Pages that are neither results nor a known challenge yield an
+// error wrapping ErrUnrecognizedPage and are not cached. Challenges slow the engine
+// down, even when they are resolved.
+func (c *Client) Search(ctx context.Context, searchTerm string, opts ...SearchOptions) ([]Result, error) {
+	if ctx == nil {
+		panic("ctx is nil")
+	}
+	var opt SearchOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Engine == "" {
+		opt.Engine = Yandex
+	}
+	pageURL, err := buildUrl(searchTerm, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := CacheKey(searchTerm, opt)
+	if c.Cache != nil && !opt.BypassCache {
+		if results, found := c.Cache.Get(key); found {
+			return limitResults(results, opt.Limit), nil
+		}
+	}
+
+	limiter, err := c.acquire(opt.Engine)
+	if err != nil {
+		return nil, err
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	results, challenge, err := c.search(ctx, pageURL, opt)
+	results, err = c.finish(opt.Engine, key, results, challenge, err)
+	if err != nil {
+		return nil, err
+	}
+	return limitResults(results, opt.Limit), nil
+}
+
+// finish records the outcome of a search of engine for the throttle and the cache.
+// A challenge met on the way slows the engine down even if it was resolved.
+func (c *Client) finish(engine Engine, key string, results []Result, challenge PageKind, err error) ([]Result, error) {
+	if errors.Is(err, ErrBlocked) {
+		c.report(engine, true)
+		return nil, err
+	}
+	if challenge != "" {
+		c.challenged(engine, challenge)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if challenge == "" {
+		c.report(engine, false)
+	}
+
+	if c.Cache != nil {
+		if err := c.Cache.Set(key, results); err != nil {
+			log.Println("googlesearch: caching results:", err)
+		}
+	}
+	return results, nil
+}
+
+// Status returns the throttling state of every engine the client has searched.
+func (c *Client) Status() []EngineStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.clock()
+	statuses := make([]EngineStatus, 0, len(c.engines))
+	for engine, t := range c.engines {
+		statuses = append(statuses, t.status(engine, now))
+	}
+	sortStatuses(statuses)
+	return statuses
+}
+
+// acquire returns the limiter of engine, or an error if the engine is cooling down.
+func (c *Client) acquire(engine Engine) (*rate.Limiter, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.throttle(engine)
+	if wait := t.cooldownUntil.Sub(c.clock()); wait > 0 {
+		return nil, fmt.Errorf("%w: %s, retry in %s", ErrCoolingDown, engine, wait.Round(time.Second))
+	}
+	return t.limiter, nil
+}
+
+func (c *Client) report(engine Engine, blocked bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.throttle(engine)
+	if blocked {
+		minBackoff, maxBackoff := c.MinBackoff, c.MaxBackoff
+		if minBackoff <= 0 {
+			minBackoff = DefaultMinBackoff
+		}
+		if maxBackoff < minBackoff {
+			maxBackoff = DefaultMaxBackoff
+		}
+		t.blocked(c.clock(), minBackoff, maxBackoff)
+		log.Printf("googlesearch: %s blocked %d time(s), cooling down until %s", engine, t.blocks, t.cooldownUntil.Format(time.TimeOnly))
+		return
+	}
+	t.succeeded(c.clock())
+}
+
+// challenged records that engine showed a challenge of kind, which was resolved or not.
+// The engine is slowed down without cooling down, as the search went on.
+func (c *Client) challenged(engine Engine, kind PageKind) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.throttle(engine)
+	t.slowDown(c.clock())
+	log.Printf("googlesearch: %s showed %s, slowing down to %v requests per second", engine, kind, limitValue(t.limiter.Limit()))
+}
+
+// clock returns the current time, which tests can fake.
+func (c *Client) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// throttle returns the state of engine, creating it on first use. c.lock must be held.
+func (c *Client) throttle(engine Engine) *engineThrottle {
+	if c.engines == nil {
+		c.engines = make(map[Engine]*engineThrottle)
+	}
+	t, ok := c.engines[engine]
+	if !ok {
+		base, ok := c.RateLimits[engine]
+		if !ok {
+			base = DefaultRateLimit
+		}
+		t = newEngineThrottle(base)
+		c.engines[engine] = t
+	}
+	return t
+}
@@ -0,0 +1,306 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported search engines.
+const (
+	Google     Engine = "google"
+	Bing       Engine = "bing"
+	DuckDuckGo Engine = "duckduckgo"
+)
+
+var (
+	// ErrUnsupportedEngine is returned when SearchOptions.Engine names an unknown engine.
+	ErrUnsupportedEngine = errors.New("unsupported search engine")
+
+	// ErrUnsupportedLocale is returned when an engine can't be localised to the requested
+	// SearchOptions.CountryCode or SearchOptions.LanguageCode.
+	ErrUnsupportedLocale = errors.New("unsupported locale")
+)
+
+// engineSpec describes how to query an engine and where to find organic results on its result page.
+type engineSpec struct {
+	// url builds the result page address. It returns an error wrapping ErrUnsupportedLocale
+	// if the engine can't be localised as requested.
+	url func(q string, cc string, lc string, limit int, start int) (string, error)
+	// results selects a single organic result.
+	results string
+	// link, title and description select parts of a result.
+	link        string
+	title       string
+	description string
+	// empty marks a result page without any result.
+	empty string
+}
+
+var engines = map[Engine]engineSpec{
+	Google: {
+		url:         googleURL,
+		results:     "#search div.g",
+		link:        "a[href]",
+		title:       "h3",
+		description: "div.VwiC3b",
+		empty:       "#topstuff .card-section",
+	},
+	Bing: {
+		url:         bingURL,
+		results:     "#b_results > li.b_algo",
+		link:        "h2 a",
+		title:       "h2",
+		description: ".b_caption p",
+		empty:       "#b_results .b_no",
+	},
+	Yandex: {
+		url:         yandexURL,
+		results:     "#search-result > li",
+		link:        "a",
+		title:       ".OrganicTitle-LinkText",
+		description: ".OrganicText",
+		empty:       ".EmptySearchResults",
+	},
+	DuckDuckGo: {
+		url:         duckDuckGoURL,
+		results:     "#links .result",
+		link:        "a.result__a",
+		title:       "a.result__a",
+		description: ".result__snippet",
+		empty:       ".no-results",
+	},
+}
+
+var (
+	countryCodeRe  = regexp.MustCompile(`^[a-z]{2}$`)
+	languageCodeRe = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]{2,4})?$`)
+)
+
+// buildUrl returns the result page address of searchTerm for the engine, country,
+// language and filters set in opt. An empty country lets the engine pick its default
+// region; an empty language means "en".
+func buildUrl(searchTerm string, opt SearchOptions) (string, error) {
+	engine := opt.Engine
+	if engine == "" {
+		engine = Yandex
+	}
+	spec, ok := engines[engine]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedEngine, engine)
+	}
+
+	cc := strings.ToLower(strings.TrimSpace(opt.CountryCode))
+	if cc != "" && !countryCodeRe.MatchString(cc) {
+		return "", fmt.Errorf("%w: country code %q is not ISO 3166-1 alpha-2", ErrUnsupportedLocale, opt.CountryCode)
+	}
+	lc := strings.ToLower(strings.TrimSpace(opt.LanguageCode))
+	if lc == "" {
+		lc = "en"
+	}
+	if !languageCodeRe.MatchString(lc) {
+		return "", fmt.Errorf("%w: language code %q", ErrUnsupportedLocale, opt.LanguageCode)
+	}
+
+	if err := validateFilters(opt); err != nil {
+		return "", err
+	}
+
+	limit := opt.Limit
+	if opt.OverLimit {
+		limit = int(float64(opt.Limit) * 1.5)
+	}
+	query, params := applyFilters(engine, searchTerm, opt, time.Now())
+	u, err := spec.url(url.QueryEscape(query), cc, lc, limit, opt.Start)
+	if err != nil {
+		return "", err
+	}
+	if len(params) > 0 {
+		u += "&" + params.Encode()
+	}
+	return u, nil
+}
+
+func googleURL(q string, cc string, lc string, limit int, start int) (string, error) {
+	if cc == "" {
+		cc = "us"
+	}
+	googleBase, found := GoogleDomains[cc]
+	if !found {
+		return "", fmt.Errorf("%w: no Google domain for country %q", ErrUnsupportedLocale, cc)
+	}
+	u := fmt.Sprintf("%s%s&hl=%s&gl=%s", base(googleBase), q, lc, cc)
+	if start != 0 {
+		u += "&start=" + strconv.Itoa(start)
+	}
+	if limit != 0 {
+		u += "&num=" + strconv.Itoa(limit)
+	}
+	return u, nil
+}
+
+// bingMarkets lists the market codes accepted by Bing's mkt parameter.
+//
+// See: https://learn.microsoft.com/en-us/bing/search-apis/bing-web-search/reference/market-codes
+var bingMarkets = []string{
+	"es-AR", "en-AU", "de-AT", "nl-BE", "fr-BE", "pt-BR", "en-CA", "fr-CA", "es-CL", "da-DK",
+	"fi-FI", "fr-FR", "de-DE", "zh-HK", "en-IN", "en-ID", "it-IT", "ja-JP", "ko-KR", "en-MY",
+	"es-MX", "nl-NL", "en-NZ", "no-NO", "zh-CN", "pl-PL", "en-PH", "ru-RU", "en-ZA", "es-ES",
+	"sv-SE", "fr-CH", "de-CH", "zh-TW", "tr-TR", "en-GB", "en-US", "es-US",
+}
+
+func bingURL(q string, cc string, lc string, limit int, start int) (string, error) {
+	if cc == "uk" {
+		cc = "gb"
+	}
+	u := "https://www.bing.com/search?q=" + q + "&setlang=" + lc
+	if cc != "" {
+		mkt, err := bingMarket(cc, lc)
+		if err != nil {
+			return "", err
+		}
+		u += "&mkt=" + mkt
+	}
+	if start != 0 {
+		u += "&first=" + strconv.Itoa(start+1)
+	}
+	if limit != 0 {
+		u += "&count=" + strconv.Itoa(limit)
+	}
+	return u, nil
+}
+
+// bingMarket returns the market for language lc in country cc, falling back to any market
+// of the country when Bing doesn't offer that language there.
+func bingMarket(cc string, lc string) (string, error) {
+	lang := strings.SplitN(lc, "-", 2)[0]
+	fallback := ""
+	for _, m := range bingMarkets {
+		mLang, mCountry := strings.ToLower(m[:2]), strings.ToLower(m[3:])
+		if mCountry != cc {
+			continue
+		}
+		if mLang == lang {
+			return m, nil
+		}
+		if fallback == "" {
+			fallback = m
+		}
+	}
+	if fallback == "" {
+		return "", fmt.Errorf("%w: Bing has no market for country %q", ErrUnsupportedLocale, cc)
+	}
+	return fallback, nil
+}
+
+// yandexDefaultRegion is the lr region used when no country is requested, or
+// the requested one has no Yandex region.
+const yandexDefaultRegion = 109371
+
+// yandexRegions maps countries to the region IDs of Yandex's lr parameter.
+//
+// See: https://yandex.com/dev/xml/doc/en/reference/regions
+var yandexRegions = map[string]int{
+	"am": 168,
+	"az": 167,
+	"by": 149,
+	"cn": 134,
+	"de": 96,
+	"fr": 124,
+	"gb": 102,
+	"ge": 169,
+	"il": 181,
+	"jp": 137,
+	"kz": 159,
+	"ru": 225,
+	"tr": 983,
+	"ua": 187,
+	"uk": 102,
+	"us": 84,
+	"uz": 171,
+}
+
+func yandexURL(q string, cc string, lc string, limit int, start int) (string, error) {
+	// Yandex has regions for few countries: search the others worldwide
+	// rather than failing.
+	region := yandexDefaultRegion
+	if r, found := yandexRegions[cc]; found {
+		region = r
+	}
+	u := fmt.Sprintf("https://yandex.com/search/?text=%s&lr=%d&lang=%s", q, region, strings.SplitN(lc, "-", 2)[0])
+	if limit != 0 && start != 0 {
+		// Yandex pages are zero-based and always hold the same number of results.
+		u += "&p=" + strconv.Itoa(start/limit)
+	}
+	return u, nil
+}
+
+// duckDuckGoRegions lists the region codes accepted by DuckDuckGo's kl parameter.
+// They are "<country>-<language>", except for "wt-wt" which means no region.
+var duckDuckGoRegions = []string{
+	"ar-es", "au-en", "at-de", "be-fr", "be-nl", "br-pt", "bg-bg", "ca-en", "ca-fr", "ct-ca",
+	"cl-es", "cn-zh", "co-es", "hr-hr", "cz-cs", "dk-da", "ee-et", "fi-fi", "fr-fr", "de-de",
+	"gr-el", "hk-tzh", "hu-hu", "in-en", "id-id", "id-en", "ie-en", "il-he", "it-it", "jp-jp",
+	"kr-kr", "lv-lv", "lt-lt", "xl-es", "my-ms", "my-en", "mx-es", "nl-nl", "nz-en", "no-no",
+	"pe-es", "ph-en", "ph-tl", "pl-pl", "pt-pt", "ro-ro", "ru-ru", "sg-en", "sk-sk", "sl-sl",
+	"za-en", "es-es", "se-sv", "ch-de", "ch-fr", "ch-it", "tw-tzh", "th-th", "tr-tr", "ua-uk",
+	"uk-en", "us-en", "ue-es", "ve-es", "vn-vi",
+}
+
+func duckDuckGoURL(q string, cc string, lc string, limit int, start int) (string, error) {
+	region := "wt-wt"
+	if cc != "" {
+		if cc == "gb" {
+			cc = "uk"
+		}
+		lang := strings.SplitN(lc, "-", 2)[0]
+		region = ""
+		for _, r := range duckDuckGoRegions {
+			if !strings.HasPrefix(r, cc+"-") {
+				continue
+			}
+			// Traditional Chinese regions use "tzh".
+			if l := r[3:]; l == lang || l == "t"+lang {
+				region = r
+				break
+			}
+			if region == "" {
+				region = r
+			}
+		}
+		if region == "" {
+			return "", fmt.Errorf("%w: DuckDuckGo has no region for country %q", ErrUnsupportedLocale, cc)
+		}
+	}
+	u := "https://html.duckduckgo.com/html/?q=" + q + "&kl=" + region
+	if start != 0 {
+		u += "&s=" + strconv.Itoa(start)
+	}
+	return u, nil
+}
+
+// resultLink returns the target of a result link, unwrapping the redirect links some
+// engines use for click tracking.
+func resultLink(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	switch {
+	case strings.HasSuffix(u.Host, "duckduckgo.com") && u.Path == "/l/":
+		if target := u.Query().Get("uddg"); target != "" {
+			return target
+		}
+	case u.Host == "" && u.Path == "/url":
+		if target := u.Query().Get("q"); target != "" {
+			return target
+		}
+	}
+	return href
+}
@@ -0,0 +1,166 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBuildUrl(t *testing.T) {
+	tests := []struct {
+		opt  SearchOptions
+		want string
+	}{
+		{
+			SearchOptions{},
+			"https://yandex.com/search/?text=golang+generics&lr=109371&lang=en",
+		},
+		{
+			SearchOptions{Engine: Yandex, CountryCode: "RU", LanguageCode: "ru"},
+			"https://yandex.com/search/?text=golang+generics&lr=225&lang=ru",
+		},
+		{
+			// Yandex has no region for Hong Kong, search worldwide.
+			SearchOptions{CountryCode: "hk"},
+			"https://yandex.com/search/?text=golang+generics&lr=109371&lang=en",
+		},
+		{
+			SearchOptions{Engine: Google, CountryCode: "hk", LanguageCode: "zh-TW", Limit: 10, Start: 20},
+			"https://www.google.com.hk/search?q=golang+generics&hl=zh-tw&gl=hk&start=20&num=10",
+		},
+		{
+			SearchOptions{Engine: Google},
+			"https://www.google.com/search?q=golang+generics&hl=en&gl=us",
+		},
+		{
+			SearchOptions{Engine: Bing, CountryCode: "de", LanguageCode: "de", Start: 10},
+			"https://www.bing.com/search?q=golang+generics&setlang=de&mkt=de-DE&first=11",
+		},
+		{
+			// Bing has no English market in Hong Kong, fall back to the local one.
+			SearchOptions{Engine: Bing, CountryCode: "hk", LanguageCode: "en"},
+			"https://www.bing.com/search?q=golang+generics&setlang=en&mkt=zh-HK",
+		},
+		{
+			SearchOptions{Engine: DuckDuckGo, CountryCode: "gb"},
+			"https://html.duckduckgo.com/html/?q=golang+generics&kl=uk-en",
+		},
+		{
+			SearchOptions{Engine: DuckDuckGo, CountryCode: "tw", LanguageCode: "zh"},
+			"https://html.duckduckgo.com/html/?q=golang+generics&kl=tw-tzh",
+		},
+		{
+			SearchOptions{Engine: DuckDuckGo},
+			"https://html.duckduckgo.com/html/?q=golang+generics&kl=wt-wt",
+		},
+	}
+	for _, tt := range tests {
+		got, err := buildUrl(" golang generics ", tt.opt)
+		if err != nil {
+			t.Errorf("buildUrl(%+v): unexpected error: %v", tt.opt, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("buildUrl(%+v) = %q, want %q", tt.opt, got, tt.want)
+		}
+	}
+}
+
+func TestBuildUrlUnsupported(t *testing.T) {
+	tests := []struct {
+		opt  SearchOptions
+		want error
+	}{
+		{SearchOptions{Engine: "altavista"}, ErrUnsupportedEngine},
+		{SearchOptions{Engine: Google, CountryCode: "usa"}, ErrUnsupportedLocale},
+		{SearchOptions{Engine: Google, CountryCode: "zz"}, ErrUnsupportedLocale},
+		{SearchOptions{Engine: Google, LanguageCode: "english"}, ErrUnsupportedLocale},
+		{SearchOptions{Engine: Bing, CountryCode: "is"}, ErrUnsupportedLocale},
+		{SearchOptions{Engine: Yandex, CountryCode: "hkg"}, ErrUnsupportedLocale},
+		{SearchOptions{Engine: DuckDuckGo, CountryCode: "zz"}, ErrUnsupportedLocale},
+	}
+	for _, tt := range tests {
+		if _, err := buildUrl("golang", tt.opt); !errors.Is(err, tt.want) {
+			t.Errorf("buildUrl(%+v): got error %v, want %v", tt.opt, err, tt.want)
+		}
+	}
+}
+
+func TestResultLink(t *testing.T) {
+	tests := map[string]string{
+		"//duckduckgo.com/l/?uddg=https%3A%2F%2Fgo.dev%2F&rut=abc": "https://go.dev/",
+		"/url?q=https://go.dev/doc/&sa=U":                          "https://go.dev/doc/",
+		"https://go.dev/blog/":                                     "https://go.dev/blog/",
+	}
+	for href, want := range tests {
+		if got := resultLink(href); got != want {
+			t.Errorf("resultLink(%q) = %q, want %q", href, got, want)
+		}
+	}
+}
+
+func TestApplyFilters(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	opt := SearchOptions{
+		Sites:        []string{"go.dev", "https://pkg.go.dev/"},
+		ExcludeSites: []string{"example.com"},
+		FileType:     ".PDF",
+		ExactPhrase:  "type parameters",
+		Recency:      PastMonth,
+		SafeSearch:   SafeSearchStrict,
+	}
+	tests := []struct {
+		engine Engine
+		query  string
+		params string
+	}{
+		{
+			Google,
+			`generics "type parameters" (site:go.dev OR site:pkg.go.dev) -site:example.com filetype:pdf`,
+			"safe=active&tbs=qdr%3Am",
+		},
+		{
+			Bing,
+			`generics "type parameters" (site:go.dev OR site:pkg.go.dev) -site:example.com filetype:pdf`,
+			"adlt=strict&filters=ex1%3A%22ez3%22",
+		},
+		{
+			Yandex,
+			`generics "type parameters" (site:go.dev | site:pkg.go.dev) -site:example.com mime:pdf date:>20240213`,
+			"fyandex=1",
+		},
+		{
+			DuckDuckGo,
+			`generics "type parameters" (site:go.dev OR site:pkg.go.dev) -site:example.com filetype:pdf`,
+			"df=m&kp=1",
+		},
+	}
+	for _, tt := range tests {
+		query, params := applyFilters(tt.engine, "generics", opt, now)
+		if query != tt.query {
+			t.Errorf("%s: query = %q, want %q", tt.engine, query, tt.query)
+		}
+		if params.Encode() != tt.params {
+			t.Errorf("%s: params = %q, want %q", tt.engine, params.Encode(), tt.params)
+		}
+	}
+
+	_, params := applyFilters(Bing, "generics", SearchOptions{Recency: PastYear}, now)
+	if got, want := params.Get("filters"), `ex1:"ez5_19432_19797"`; got != want {
+		t.Errorf("bing past year: filters = %q, want %q", got, want)
+	}
+}
+
+func TestInvalidFilters(t *testing.T) {
+	for _, opt := range []SearchOptions{
+		{Recency: "decade"},
+		{SafeSearch: "paranoid"},
+		{FileType: "pdf OR doc"},
+	} {
+		if _, err := buildUrl("golang", opt); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("buildUrl(%+v): got error %v, want %v", opt, err, ErrInvalidFilter)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFilter is returned when SearchOptions contains an unknown Recency or SafeSearch value.
+var ErrInvalidFilter = errors.New("invalid search filter")
+
+// Recency restricts results to pages published or updated within a time window.
+type Recency string
+
+// Supported recency windows.
+const (
+	AnyTime   Recency = ""
+	PastDay   Recency = "day"
+	PastWeek  Recency = "week"
+	PastMonth Recency = "month"
+	PastYear  Recency = "year"
+)
+
+// duration returns the length of the window.
+func (r Recency) duration() time.Duration {
+	switch r {
+	case PastDay:
+		return 24 * time.Hour
+	case PastWeek:
+		return 7 * 24 * time.Hour
+	case PastMonth:
+		return 31 * 24 * time.Hour
+	case PastYear:
+		return 365 * 24 * time.Hour
+	}
+	return 0
+}
+
+// SafeSearch sets how strictly explicit results are filtered.
+type SafeSearch string
+
+// Supported safe search levels.
+const (
+	SafeSearchDefault  SafeSearch = ""
+	SafeSearchOff      SafeSearch = "off"
+	SafeSearchModerate SafeSearch = "moderate"
+	SafeSearchStrict   SafeSearch = "strict"
+)
+
+// validateFilters checks the enumerated filter values of opt.
+func validateFilters(opt SearchOptions) error {
+	switch opt.Recency {
+	case AnyTime, PastDay, PastWeek, PastMonth, PastYear:
+	default:
+		return fmt.Errorf("%w: recency %q", ErrInvalidFilter, opt.Recency)
+	}
+	switch opt.SafeSearch {
+	case SafeSearchDefault, SafeSearchOff, SafeSearchModerate, SafeSearchStrict:
+	default:
+		return fmt.Errorf("%w: safe search %q", ErrInvalidFilter, opt.SafeSearch)
+	}
+	if strings.ContainsAny(opt.FileType, " \"") {
+		return fmt.Errorf("%w: file type %q", ErrInvalidFilter, opt.FileType)
+	}
+	return nil
+}
+
+// applyFilters translates the filters of opt into the query syntax and URL parameters
+// of engine. now is the time recency windows are measured from.
+func applyFilters(engine Engine, searchTerm string, opt SearchOptions, now time.Time) (string, url.Values) {
+	terms := []string{strings.TrimSpace(searchTerm)}
+	params := url.Values{}
+
+	if phrase := strings.TrimSpace(strings.ReplaceAll(opt.ExactPhrase, `"`, "")); phrase != "" {
+		terms = append(terms, `"`+phrase+`"`)
+	}
+
+	or := " OR "
+	if engine == Yandex {
+		or = " | "
+	}
+	var sites []string
+	for _, s := range opt.Sites {
+		if s = cleanSite(s); s != "" {
+			sites = append(sites, "site:"+s)
+		}
+	}
+	switch len(sites) {
+	case 0:
+	case 1:
+		terms = append(terms, sites[0])
+	default:
+		terms = append(terms, "("+strings.Join(sites, or)+")")
+	}
+	for _, s := range opt.ExcludeSites {
+		if s = cleanSite(s); s != "" {
+			terms = append(terms, "-site:"+s)
+		}
+	}
+
+	if ft := strings.TrimPrefix(strings.ToLower(opt.FileType), "."); ft != "" {
+		if engine == Yandex {
+			terms = append(terms, "mime:"+ft)
+		} else {
+			terms = append(terms, "filetype:"+ft)
+		}
+	}
+
+	if opt.Recency != AnyTime {
+		switch engine {
+		case Google:
+			params.Set("tbs", "qdr:"+opt.Recency.String()[:1])
+		case Bing:
+			switch opt.Recency {
+			case PastDay:
+				params.Set("filters", `ex1:"ez1"`)
+			case PastWeek:
+				params.Set("filters", `ex1:"ez2"`)
+			case PastMonth:
+				params.Set("filters", `ex1:"ez3"`)
+			default:
+				// Custom range, in days since the Unix epoch.
+				to := now.Unix() / 86400
+				from := now.Add(-opt.Recency.duration()).Unix() / 86400
+				params.Set("filters", fmt.Sprintf(`ex1:"ez5_%d_%d"`, from, to))
+			}
+		case Yandex:
+			terms = append(terms, "date:>"+now.Add(-opt.Recency.duration()).Format("20060102"))
+		case DuckDuckGo:
+			params.Set("df", opt.Recency.String()[:1])
+		}
+	}
+
+	if opt.SafeSearch != SafeSearchDefault {
+		switch engine {
+		case Google:
+			// Google has no moderate level any more; it behaves like the default.
+			switch opt.SafeSearch {
+			case SafeSearchOff:
+				params.Set("safe", "off")
+			case SafeSearchStrict:
+				params.Set("safe", "active")
+			}
+		case Bing:
+			params.Set("adlt", opt.SafeSearch.String())
+		case Yandex:
+			// Yandex only offers the family filter; other levels use the region default.
+			if opt.SafeSearch == SafeSearchStrict {
+				params.Set("fyandex", "1")
+			}
+		case DuckDuckGo:
+			params.Set("kp", map[SafeSearch]string{
+				SafeSearchOff:      "-2",
+				SafeSearchModerate: "-1",
+				SafeSearchStrict:   "1",
+			}[opt.SafeSearch])
+		}
+	}
+
+	return strings.Join(terms, " "), params
+}
+
+// filterKey returns a stable representation of the filters of opt for cache keys.
+func filterKey(opt SearchOptions) string {
+	if !hasFilters(opt) {
+		return ""
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s",
+		strings.ToLower(strings.Join(opt.Sites, ",")),
+		strings.ToLower(strings.Join(opt.ExcludeSites, ",")),
+		strings.ToLower(opt.FileType),
+		opt.Recency,
+		strings.ToLower(strings.TrimSpace(opt.ExactPhrase)),
+		opt.SafeSearch,
+	)
+}
+
+func hasFilters(opt SearchOptions) bool {
+	return len(opt.Sites) > 0 || len(opt.ExcludeSites) > 0 || opt.FileType != "" ||
+		opt.Recency != AnyTime || opt.ExactPhrase != "" || opt.SafeSearch != SafeSearchDefault
+}
+
+// cleanSite reduces a site given as a URL or domain to what the site: operator expects.
+func cleanSite(s string) string {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	return strings.TrimSuffix(s, "/")
+}
+
+func (r Recency) String() string {
+	return string(r)
+}
+
+func (s SafeSearch) String() string {
+	return string(s)
+}
@@ -4,6 +4,10 @@ package googlesearch
 
 import (
 	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -14,9 +18,120 @@ import (
 // See: https://github.com/rocketlaunchr/google-search#warning-warning
 var ErrBlocked = errors.New("google block")
 
-// RateLimit sets a global limit to how many requests to Google Search can be made in a given time interval.
-// The default is unlimited (but obviously Google Search will block you temporarily if you do too many
-// calls too quickly).
-//
-// See: https://godoc.org/golang.org/x/time/rate#NewLimiter
-var RateLimit = rate.NewLimiter(rate.Inf, 0)
+// ErrCoolingDown is returned by Client.Search while an engine is backing off after a block.
+var ErrCoolingDown = errors.New("search engine cooling down")
+
+const (
+	// DefaultRateLimit is the steady-state number of requests per second sent to an engine
+	// that has no entry in Client.RateLimits.
+	DefaultRateLimit rate.Limit = 1
+
+	// DefaultMinBackoff is the cool-down applied after the first block.
+	DefaultMinBackoff = 30 * time.Second
+
+	// DefaultMaxBackoff caps the cool-down after repeated blocks.
+	DefaultMaxBackoff = 30 * time.Minute
+
+	// minLimitFactor bounds how far the request rate is slowed down after blocks.
+	minLimitFactor = 1.0 / 16
+)
+
+// EngineStatus describes the throttling state of a single engine.
+type EngineStatus struct {
+
+	// Engine is the engine the status belongs to.
+	Engine Engine `json:"engine"`
+
+	// Limit is the current number of requests per second allowed. Zero means unlimited.
+	Limit float64 `json:"limit"`
+
+	// BaseLimit is the rate Limit recovers to after successful searches.
+	BaseLimit float64 `json:"baseLimit"`
+
+	// Blocks is the number of consecutive searches that were blocked.
+	Blocks int `json:"blocks"`
+
+	// CooldownUntil is the time until which searches are refused. It is zero if
+	// the engine is not cooling down.
+	CooldownUntil time.Time `json:"cooldownUntil"`
+}
+
+// engineThrottle holds the limiter and backoff state of one engine. It is guarded by
+// the lock of the owning Client.
+type engineThrottle struct {
+	limiter       *rate.Limiter
+	base          rate.Limit
+	blocks        int
+	cooldownUntil time.Time
+}
+
+func newEngineThrottle(base rate.Limit) *engineThrottle {
+	burst := 1
+	if base == rate.Inf {
+		burst = 0
+	}
+	return &engineThrottle{limiter: rate.NewLimiter(base, burst), base: base}
+}
+
+// blocked records a block, slows the limiter down and starts a cool-down whose length
+// grows exponentially with the number of consecutive blocks.
+func (t *engineThrottle) blocked(now time.Time, minBackoff, maxBackoff time.Duration) {
+	t.blocks++
+	backoff := float64(minBackoff) * math.Pow(2, float64(t.blocks-1))
+	if backoff > float64(maxBackoff) {
+		backoff = float64(maxBackoff)
+	}
+	// Add up to 20% jitter so that several clients don't retry in lockstep.
+	backoff += backoff * 0.2 * rand.Float64()
+	t.cooldownUntil = now.Add(time.Duration(backoff))
+	t.slowDown(now)
+}
+
+// slowDown halves the rate of the limiter, down to a floor relative to its base.
+func (t *engineThrottle) slowDown(now time.Time) {
+	if t.base != rate.Inf {
+		limit := t.limiter.Limit() / 2
+		if floor := t.base * minLimitFactor; limit < floor {
+			limit = floor
+		}
+		t.limiter.SetLimitAt(now, limit)
+	}
+}
+
+// succeeded resets the block counter and lets the rate recover towards its base.
+func (t *engineThrottle) succeeded(now time.Time) {
+	t.blocks = 0
+	t.cooldownUntil = time.Time{}
+	if t.base != rate.Inf && t.limiter.Limit() < t.base {
+		limit := t.limiter.Limit() * 2
+		if limit > t.base {
+			limit = t.base
+		}
+		t.limiter.SetLimitAt(now, limit)
+	}
+}
+
+func (t *engineThrottle) status(engine Engine, now time.Time) EngineStatus {
+	s := EngineStatus{
+		Engine:    engine,
+		Limit:     limitValue(t.limiter.Limit()),
+		BaseLimit: limitValue(t.base),
+		Blocks:    t.blocks,
+	}
+	if now.Before(t.cooldownUntil) {
+		s.CooldownUntil = t.cooldownUntil
+	}
+	return s
+}
+
+// limitValue converts l for reporting, mapping rate.Inf (which JSON can't encode) to zero.
+func limitValue(l rate.Limit) float64 {
+	if l == rate.Inf {
+		return 0
+	}
+	return float64(l)
+}
+
+func sortStatuses(s []EngineStatus) {
+	sort.Slice(s, func(i, j int) bool { return s[i].Engine < s[j].Engine })
+}
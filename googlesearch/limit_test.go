@@ -0,0 +1,123 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestClientBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     rate.Limit
+		blocked  []bool
+		blocks   int
+		limit    float64
+		cooldown time.Duration // without jitter, 0 if not cooling down
+	}{
+		{"first block", 1, []bool{true}, 1, 0.5, 30 * time.Second},
+		{"exponential", 1, []bool{true, true}, 2, 0.25, time.Minute},
+		{"capped", 1, []bool{true, true, true, true}, 4, 0.0625, 2 * time.Minute},
+		{"rate floor", 1, []bool{true, true, true, true, true, true}, 6, 0.0625, 2 * time.Minute},
+		{"success resets", 1, []bool{true, true, false}, 0, 0.5, 0},
+		{"rate recovers", 1, []bool{true, true, true, false, false, false, false}, 0, 1, 0},
+		{"blocks after success", 1, []bool{true, true, false, true}, 1, 0.25, 30 * time.Second},
+		{"unlimited", rate.Inf, []bool{true, true}, 2, 0, time.Minute},
+	}
+	for _, tt := range tests {
+		now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		c := NewClient(nil)
+		c.MaxBackoff = 2 * time.Minute
+		c.RateLimits = map[Engine]rate.Limit{Bing: tt.base}
+		c.now = func() time.Time { return now }
+		for _, blocked := range tt.blocked {
+			c.report(Bing, blocked)
+		}
+
+		status := c.Status()
+		if len(status) != 1 {
+			t.Fatalf("%s: Status() = %v", tt.name, status)
+		}
+		s := status[0]
+		if s.Blocks != tt.blocks || s.Limit != tt.limit || s.BaseLimit != limitValue(tt.base) {
+			t.Errorf("%s: Status() = %+v, want %d blocks and a limit of %v", tt.name, s, tt.blocks, tt.limit)
+		}
+		// Cool-downs have up to 20% of jitter.
+		if tt.cooldown == 0 {
+			if !s.CooldownUntil.IsZero() {
+				t.Errorf("%s: cooling down until %v", tt.name, s.CooldownUntil)
+			}
+		} else if cooldown := s.CooldownUntil.Sub(now); cooldown < tt.cooldown || cooldown > tt.cooldown*6/5 {
+			t.Errorf("%s: cooling down for %v, want %v", tt.name, cooldown, tt.cooldown)
+		}
+
+		_, err := c.acquire(Bing)
+		if cooling := errors.Is(err, ErrCoolingDown); cooling != (tt.cooldown > 0) {
+			t.Errorf("%s: acquire() = %v", tt.name, err)
+		}
+		now = now.Add(tt.cooldown*6/5 + time.Second)
+		if _, err := c.acquire(Bing); err != nil {
+			t.Errorf("%s: acquire() after the cool-down = %v", tt.name, err)
+		}
+		if s := c.Status()[0]; !s.CooldownUntil.IsZero() {
+			t.Errorf("%s: Status() after the cool-down = %+v", tt.name, s)
+		}
+	}
+}
+
+func TestClientSearchCoolingDown(t *testing.T) {
+	c := NewClient(nil)
+	c.report(Bing, true)
+	_, err := c.Search(context.Background(), "golang", SearchOptions{Engine: Bing})
+	if !errors.Is(err, ErrCoolingDown) {
+		t.Errorf("Search() while cooling down = %v", err)
+	}
+	// Other engines keep their own state.
+	if _, err := c.acquire(Google); err != nil {
+		t.Errorf("acquire() of another engine = %v", err)
+	}
+}
+
+func TestClientResolvedChallenge(t *testing.T) {
+	c := NewClient(&Cache{TTL: time.Minute})
+	c.RateLimits = map[Engine]rate.Limit{Bing: 1}
+	results := []Result{{Rank: 1, URL: "https://go.dev/"}}
+
+	// A resolved captcha still yields results, but slows the engine down.
+	got, err := c.finish(Bing, "k", results, PageCheckboxCaptcha, nil)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("finish() after a resolved captcha = %v, %v", got, err)
+	}
+	if _, ok := c.Cache.Get("k"); !ok {
+		t.Error("Results after a resolved captcha not cached")
+	}
+	s := c.Status()[0]
+	if s.Limit != 0.5 || s.Blocks != 0 || !s.CooldownUntil.IsZero() {
+		t.Errorf("Status() after a resolved captcha = %+v, want a limit of 0.5 without cool-down", s)
+	}
+	c.finish(Bing, "k", results, PageConsent, nil)
+	if s := c.Status()[0]; s.Limit != 0.25 {
+		t.Errorf("Status() after a second challenge = %+v, want a limit of 0.25", s)
+	}
+
+	// Unrecognized pages neither speed the engine up nor get cached.
+	if _, err := c.finish(Bing, "unknown", nil, "", ErrUnrecognizedPage); !errors.Is(err, ErrUnrecognizedPage) {
+		t.Errorf("finish() of an unrecognized page = %v", err)
+	}
+	if _, ok := c.Cache.Get("unknown"); ok {
+		t.Error("Unrecognized page cached")
+	}
+	if s := c.Status()[0]; s.Limit != 0.25 {
+		t.Errorf("Status() after an unrecognized page = %+v, want a limit of 0.25", s)
+	}
+
+	c.finish(Bing, "k", results, "", nil)
+	if s := c.Status()[0]; s.Limit != 0.5 {
+		t.Errorf("Status() after a clean search = %+v, want a limit of 0.5", s)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2020-22 PJ Engineering and Business Solutions Pty. Ltd. All rights reserved.
+
+package googlesearch
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/proxy"
+)
+
+// ErrUnsupportedProxy is returned when SearchOptions.ProxyAddr uses a scheme the browser can't use.
+var ErrUnsupportedProxy = errors.New("unsupported proxy scheme")
+
+// browserProxy is a proxy address split into what Chrome accepts as a proxy server and
+// the credentials that have to be supplied separately.
+type browserProxy struct {
+	server   string
+	username string
+	password string
+	socks    bool
+}
+
+// parseProxy parses addr into a browserProxy. The proxy type is determined by the URL
+// scheme. "http", "https" and "socks5" are supported. If the scheme is empty, "http" is
+// assumed.
+func parseProxy(addr string) (*browserProxy, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address %q", addr)
+	}
+	p := &browserProxy{}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "socks5", "socks5h":
+		p.socks = true
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedProxy, u.Scheme)
+	}
+	if u.User != nil {
+		p.username = u.User.Username()
+		p.password, _ = u.User.Password()
+	}
+	p.server = strings.ToLower(u.Scheme) + "://" + u.Host
+	if p.socks {
+		p.server = "socks5://" + u.Host
+	}
+	return p, nil
+}
+
+// hasAuth reports whether the proxy requires credentials.
+func (p *browserProxy) hasAuth() bool {
+	return p.username != "" || p.password != ""
+}
+
+// socksRelay is a local HTTP proxy which forwards every connection through an
+// authenticated SOCKS5 proxy. Chrome can't authenticate against SOCKS proxies by
+// itself, so it is pointed at the relay instead. The relay holds the credentials of
+// the SOCKS proxy, so it requires credentials of its own, random for every relay, so
+// that other local processes can't tunnel through it.
+type socksRelay struct {
+	listener net.Listener
+	dialer   proxy.ContextDialer
+	username string
+	password string
+	wg       sync.WaitGroup
+}
+
+// newSocksRelay starts a relay on a random loopback port.
+func newSocksRelay(p *browserProxy) (*socksRelay, error) {
+	d, err := proxy.SOCKS5("tcp", strings.TrimPrefix(p.server, "socks5://"),
+		&proxy.Auth{User: p.username, Password: p.password}, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	r := &socksRelay{dialer: d.(proxy.ContextDialer)}
+	if r.username, err = randomToken(); err != nil {
+		return nil, err
+	}
+	if r.password, err = randomToken(); err != nil {
+		return nil, err
+	}
+	if r.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	r.wg.Add(1)
+	go r.serve()
+	return r, nil
+}
+
+// randomToken returns 128 random bits, hex encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Addr returns the proxy server address to hand to the browser.
+func (r *socksRelay) Addr() string {
+	return "http://" + r.listener.Addr().String()
+}
+
+// Close stops accepting connections and waits for the accept loop to exit.
+// Connections already relayed are closed when either side hangs up.
+func (r *socksRelay) Close() error {
+	err := r.listener.Close()
+	r.wg.Wait()
+	return err
+}
+
+func (r *socksRelay) serve() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *socksRelay) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	if !r.authorized(req.Header.Get("Proxy-Authorization")) {
+		fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"googlesearch\"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		return
+	}
+	req.Header.Del("Proxy-Authorization")
+	host := req.Host
+	if req.Method != http.MethodConnect && req.URL.Port() == "" {
+		host = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	upstream, err := r.dialer.DialContext(context.Background(), "tcp", host)
+	if err != nil {
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	defer upstream.Close()
+
+	if req.Method != http.MethodConnect {
+		relayRequest(conn, upstream, req)
+		return
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, br)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// relayRequest forwards a plain HTTP request in origin form and its response. The
+// response asks the client to close the connection, as the next request may go to a
+// different host, and must be authorized again.
+func relayRequest(conn, upstream net.Conn, req *http.Request) {
+	req.RequestURI = ""
+	req.Close = true
+	req.Header.Del("Proxy-Connection")
+	if err := req.Write(upstream); err != nil {
+		return
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(upstream), req)
+	if err != nil {
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		return
+	}
+	defer resp.Body.Close()
+	resp.Close = true
+	resp.Write(conn)
+}
+
+// authorized reports whether the Proxy-Authorization header value holds the
+// credentials of the relay.
+func (r *socksRelay) authorized(auth string) bool {
+	scheme, credentials, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+	want := r.username + ":" + r.password
+	return subtle.ConstantTimeCompare(decoded, []byte(want)) == 1
+}
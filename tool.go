@@ -3,8 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
-	"golang.org/x/time/rate"
+	"kimi-chat/browser"
 	"kimi-chat/googlesearch"
+	"net/url"
 	"strings"
 )
 
@@ -33,17 +34,38 @@ var tools = []map[string]any{
 	},
 }
 
+// searchArgs 是模型调用 search 工具时传入的参数
+type searchArgs struct {
+	Query        string   `json:"query"`
+	Sites        []string `json:"sites"`
+	ExcludeSites []string `json:"exclude_sites"`
+	FileType     string   `json:"filetype"`
+	Recency      string   `json:"recency"`
+	ExactPhrase  string   `json:"exact_phrase"`
+	SafeSearch   string   `json:"safe_search"`
+}
+
 /*
 实际执行搜索的地方。这里为了演示简单，直接调 Bing 的公开接口，不需要 key。
 你可以换成自己的内部搜索、数据库查询等。
 */
-func searchTool(ctx context.Context, query string) (string, error) {
-	googlesearch.RateLimit = rate.NewLimiter(1, 1)
+func searchTool(ctx context.Context, pool *browser.Manager, client *googlesearch.Client, args searchArgs) (string, error) {
 	opt := googlesearch.SearchOptions{CountryCode: "hk", LanguageCode: "en", Limit: 10, Start: 0, OverLimit: false, FollowNextPage: false,
-		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36"}
-	serp, err := googlesearch.Search(ctx, query, opt)
+		UserAgent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36",
+		Sites:        args.Sites,
+		ExcludeSites: args.ExcludeSites,
+		FileType:     args.FileType,
+		Recency:      googlesearch.Recency(args.Recency),
+		ExactPhrase:  args.ExactPhrase,
+		SafeSearch:   googlesearch.SafeSearch(args.SafeSearch),
+	}
+	var serp []googlesearch.Result
+	err := pool.Run(ctx, func(ctx context.Context) (err error) {
+		serp, err = client.Search(ctx, args.Query, opt)
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	var lines []string
 	for _, result := range serp {
@@ -54,3 +76,44 @@ func searchTool(ctx context.Context, query string) (string, error) {
 	}
 	return strings.Join(lines, "\n"), nil
 }
+
+// maxBrowseRunes 限制返回给模型的正文长度，避免超出上下文
+const maxBrowseRunes = 6000
+
+// browseArgs 是模型调用 browse 工具时传入的参数
+type browseArgs struct {
+	URL        string `json:"url"`
+	Format     string `json:"format"`
+	Screenshot bool   `json:"screenshot"`
+}
+
+/*
+用浏览器标签页打开网页，等待网络空闲后提取渲染后的正文。
+返回给模型的正文和可选的整页截图。
+*/
+func browseTool(ctx context.Context, pool *browser.Manager, args browseArgs) (string, []byte, error) {
+	u, err := url.Parse(strings.TrimSpace(args.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", nil, fmt.Errorf("无效的网址：%q", args.URL)
+	}
+	opts := browser.RenderOptions{Format: browser.Format(args.Format), Screenshot: args.Screenshot}
+	if opts.Format != browser.PlainText {
+		opts.Format = browser.Markdown
+	}
+	var page *browser.Page
+	err = pool.Run(ctx, func(ctx context.Context) (err error) {
+		page, err = browser.Render(ctx, u.String(), opts)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	content := page.Content
+	if r := []rune(content); len(r) > maxBrowseRunes {
+		content = string(r[:maxBrowseRunes]) + "\n\n…（内容过长，已截断）"
+	}
+	if content == "" {
+		content = "页面没有可提取的正文"
+	}
+	return fmt.Sprintf("标题: %s\n链接: %s\n\n%s", page.Title, page.URL, content), page.Screenshot, nil
+}